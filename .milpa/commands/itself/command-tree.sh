@@ -15,6 +15,10 @@ function get_tree () {
   if [[ "${2:-}" != "" ]]; then
     args=( "--template=${2}" )
   fi
+  [[ "$MILPA_OPT_REPO" ]] && args+=( "--repo=$MILPA_OPT_REPO" )
+  [[ "$MILPA_OPT_KIND" ]] && args+=( "--kind=$MILPA_OPT_KIND" )
+  [[ "$MILPA_OPT_INCLUDE_HIDDEN" ]] && args+=( "--include-hidden" )
+  [[ "$MILPA_OPT_WHERE" ]] && args+=( "--where=$MILPA_OPT_WHERE" )
   args+=( "${MILPA_ARG_PREFIX[@]}" )
   "$MILPA_COMPA" __command_tree \
     --depth "$MILPA_OPT_DEPTH" \
@@ -22,7 +26,7 @@ function get_tree () {
     "${args[@]}"
 }
 
-if [[ "$MILPA_OPT_OUTPUT" =~ ^(yaml|json)$ ]]; then
+if [[ "$MILPA_OPT_OUTPUT" =~ ^(yaml|json|dot|markdown)$ ]]; then
   get_tree "$MILPA_OPT_OUTPUT" || @milpa.fail "Could not load tree"
   exit
 fi
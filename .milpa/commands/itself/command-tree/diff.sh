@@ -0,0 +1,10 @@
+#!/usr/bin/env bash
+# SPDX-License-Identifier: Apache-2.0
+# Copyright © 2021 Roberto Hidalgo <milpa@un.rob.mx>
+
+args=( "$MILPA_ARG_PREVIOUS" )
+if [[ "$MILPA_ARG_CURRENT" ]]; then
+  args+=( "$MILPA_ARG_CURRENT" )
+fi
+
+exec "$MILPA_COMPA" __command_tree_diff --format "$MILPA_OPT_OUTPUT" "${args[@]}"
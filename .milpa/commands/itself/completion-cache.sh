@@ -0,0 +1,26 @@
+#!/usr/bin/env bash
+# SPDX-License-Identifier: Apache-2.0
+# Copyright © 2021 Roberto Hidalgo <milpa@un.rob.mx>
+
+cache_dir="${XDG_CACHE_HOME:-$HOME/.cache}/milpa/completions"
+target="$cache_dir"
+if [[ "${#MILPA_ARG_PREFIX[@]}" -gt 0 ]]; then
+  target="$cache_dir/$(IFS=/; echo "${MILPA_ARG_PREFIX[*]}")"
+fi
+
+if [[ ! -d "$target" ]]; then
+  @milpa.log info "No cached completion values found at $target"
+  exit
+fi
+
+if [[ "$MILPA_OPT_REFRESH" ]]; then
+  rm -rf "$target" || @milpa.fail "Could not clear cached values at $target"
+  @milpa.log complete "Cleared cached completion values at $target"
+  exit
+fi
+
+while IFS= read -r command_dir; do
+  name="${command_dir#"$cache_dir/"}"
+  count="$(find "$command_dir" -maxdepth 1 -name '*.json' | wc -l | tr -d ' ')"
+  echo "$(@milpa.fmt bold "milpa ${name//\// }") - $count cached value source(s)"
+done < <(find "$target" -name '*.json' -exec dirname {} \; | sort -u)
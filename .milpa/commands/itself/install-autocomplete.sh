@@ -62,6 +62,27 @@ then reloading your shell'
     dst="$HOME/.config/fish/completions/milpa.fish"
     "$MILPA_COMPA" __generate_completions fish > "$dst" || @milpa.fail "Could not install completions"
   ;;
+  *nu)
+    @milpa.log info "Nushell detected"
+    # nushell loads every file in its autoload folder on startup
+    dst="${XDG_CONFIG_HOME:-$HOME/.config}/nushell/autoload"
+    mkdir -p "$dst" || @milpa.fail "Could not create $dst"
+    "$MILPA_COMPA" __completion_script nushell > "$dst/milpa.nu" || @milpa.fail "Could not install completions"
+    caveats="nushell versions older than 0.101 do not autoload scripts, add the following line to your config.nu:
+
+source $dst/milpa.nu"
+  ;;
+  *pwsh)
+    @milpa.log info "PowerShell detected"
+    dst="${XDG_CONFIG_HOME:-$HOME/.config}/powershell"
+    profile="$dst/Microsoft.PowerShell_profile.ps1"
+    mkdir -p "$dst" || @milpa.fail "Could not create $dst"
+    "$MILPA_COMPA" __completion_script powershell > "$dst/milpa.ps1" || @milpa.fail "Could not install completions"
+    if ! grep -qF "$dst/milpa.ps1" "$profile" 2>/dev/null; then
+      echo ". '$dst/milpa.ps1'" >> "$profile" || @milpa.fail "Could not load completions from $profile"
+      @milpa.log info "Loading completions from $profile"
+    fi
+  ;;
   *)
     @milpa.fail "No completion script found for shell $SHELL"
 esac
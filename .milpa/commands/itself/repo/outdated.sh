@@ -0,0 +1,5 @@
+#!/usr/bin/env bash
+# SPDX-License-Identifier: Apache-2.0
+# Copyright © 2021 Roberto Hidalgo <milpa@un.rob.mx>
+
+exec "$MILPA_COMPA" __repo_outdated --format "$MILPA_OPT_FORMAT"
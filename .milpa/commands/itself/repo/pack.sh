@@ -0,0 +1,8 @@
+#!/usr/bin/env bash
+# SPDX-License-Identifier: Apache-2.0
+# Copyright © 2021 Roberto Hidalgo <milpa@un.rob.mx>
+
+args=( --format "$MILPA_OPT_FORMAT" )
+[[ "$MILPA_OPT_FILE" ]] && args+=( --file "$MILPA_OPT_FILE" )
+
+exec "$MILPA_COMPA" __repo_pack "${args[@]}" "$MILPA_ARG_PATH"
@@ -0,0 +1,27 @@
+#!/usr/bin/env bash
+# SPDX-License-Identifier: Apache-2.0
+# Copyright © 2021 Roberto Hidalgo <milpa@un.rob.mx>
+
+args=( --manifest "$MILPA_OPT_MANIFEST" --format "$MILPA_OPT_FORMAT" )
+[[ "$MILPA_OPT_GLOBAL" ]] && args+=( --global )
+[[ "$MILPA_OPT_UPDATE" ]] && args+=( --update )
+[[ "$MILPA_OPT_INSECURE" ]] && args+=( --insecure )
+
+hooks="$(mktemp)" || @milpa.fail "Could not create temporary file"
+trap 'rm -f "$hooks"' EXIT
+# repos installed before a failure are still listed, so their hooks run before failing
+COMPA_OUT="$hooks" "$MILPA_COMPA" __repo_sync "${args[@]}"
+status=$?
+
+while read -r new_repo; do
+  [[ "$new_repo" ]] || continue
+  if [[ -f "$new_repo/hooks/post-install.sh" ]]; then
+    @milpa.log info "Running post-install hook for $new_repo"
+    #shellcheck disable=1090,1091
+    source "$new_repo/hooks/post-install.sh" || @milpa.fail "Could not run post-install hook to completion"
+  fi
+done <"$hooks"
+
+[[ "$status" -eq 0 ]] || @milpa.fail "Could not sync repos from $MILPA_OPT_MANIFEST"
+
+@milpa.log complete "Repos synced from $MILPA_OPT_MANIFEST"
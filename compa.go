@@ -12,6 +12,7 @@ import (
 	"git.rob.mx/nidito/chinampa/pkg/statuscode"
 	"github.com/unrob/milpa/internal/actions"
 	"github.com/unrob/milpa/internal/bootstrap"
+	"github.com/unrob/milpa/internal/command"
 	_c "github.com/unrob/milpa/internal/constants"
 	"github.com/unrob/milpa/internal/errors"
 	"github.com/unrob/milpa/internal/lookup"
@@ -35,6 +36,7 @@ func main() {
 	isDoctor := actions.DoctorModeEnabled()
 	logger.Debugf("doctor mode enabled: %v", isDoctor)
 
+	bootstrap.MilpaVersion = version
 	err := bootstrap.Run()
 	if err != nil {
 		errors.HandleExit(nil, err) // nolint: errcheck
@@ -52,11 +54,22 @@ See [﹅milpa help docs milpa﹅](/.milpa/docs/milpa/index.md) for more informat
 	chinampa.SetErrorHandler(errors.HandleExit)
 	chinampa.SetVersionCommandName("__version")
 
-	chinampa.Register(actions.Doctor)
-	chinampa.Register(actions.Docs)
-	chinampa.Register(actions.CommandTree)
+	lookup.RegisterBuiltins(
+		actions.Doctor,
+		actions.Docs,
+		actions.CommandTree,
+		actions.CommandTreeDiff,
+		actions.CompletionScript,
+		actions.RepoInstall,
+		actions.RepoList,
+		actions.RepoUpgrade,
+		actions.RepoOutdated,
+		actions.RepoPack,
+		actions.RepoUninstall,
+		actions.RepoSync,
+	)
 
-	err = lookup.AllSubCommands(!isDoctor)
+	err = lookup.AllSubCommands(!isDoctor, command.IsCompleting())
 	if err != nil && !isDoctor {
 		logger.Fatalf("Could not find subcommands: %s", err)
 	} else if err != nil {
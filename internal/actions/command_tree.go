@@ -6,6 +6,8 @@ import (
 	"bytes"
 	"encoding/json"
 	"fmt"
+	"path/filepath"
+	"sort"
 	"strings"
 	"text/template"
 
@@ -15,7 +17,9 @@ import (
 	"git.rob.mx/nidito/chinampa/pkg/render"
 	"git.rob.mx/nidito/chinampa/pkg/runtime"
 	"git.rob.mx/nidito/chinampa/pkg/tree"
+	"github.com/spf13/cobra"
 	milpaCmd "github.com/unrob/milpa/internal/command"
+	_c "github.com/unrob/milpa/internal/constants"
 
 	"gopkg.in/yaml.v3"
 )
@@ -24,25 +28,6 @@ var ctLog = logger.Sub("itself command-tree")
 
 type serializar func(interface{}) ([]byte, error)
 
-func addMetaToTree(t *tree.CommandTree) {
-	if t.Command != nil && t.Command.Meta == nil {
-		meta := &milpaCmd.Meta{
-			Path: t.Command.Name(),
-			Repo: "",
-			Name: t.Command.Path,
-			Kind: milpaCmd.KindVirtual,
-		}
-		t.Command.Meta = &meta
-		if t.Command.Path[0] != "milpa" {
-			t.Command.Path = append([]string{"milpa"}, t.Command.Path...)
-		}
-	}
-
-	for _, subT := range t.Children {
-		addMetaToTree(subT)
-	}
-}
-
 var CommandTree = &command.Command{
 	Path:    []string{"__command_tree"},
 	Hidden:  true,
@@ -66,6 +51,18 @@ var CommandTree = &command.Command{
 
   # same, but as the yaml representation of this command itself
   ` + runtime.Executable + ` __command_tree --output json itself command-tree
+
+  # render a graph of all commands with graphviz
+  ` + runtime.Executable + ` __command_tree --format dot | dot -Tsvg > commands.svg
+
+  # print a markdown table of contents, with arguments and options
+  ` + runtime.Executable + ` __command_tree --format markdown
+
+  # list the executable commands a repo contributes
+  ` + runtime.Executable + ` __command_tree --repo ~/code/infra/.milpa --kind executable
+
+  # list commands with more than two options
+  ` + runtime.Executable + ` __command_tree --where '{{ gt (len .Options) 2 }}'
   ﹅﹅﹅`,
 	Arguments: command.Arguments{
 		{
@@ -85,13 +82,29 @@ var CommandTree = &command.Command{
 			Default:     "text",
 			Description: "The format to output results in",
 			Values: &command.ValueSource{
-				Static: &([]string{"yaml", "json", "text", "autocomplete"}),
+				Static: &([]string{"yaml", "json", "text", "autocomplete", "dot", "markdown"}),
 			},
 		},
 		"template": &command.Option{
 			Default:     "{{ .Name }} - {{ .Summary }}\n",
 			Description: "a go-template to apply to every command",
 		},
+		"repo": &command.Option{
+			Description: "Only include commands from the repo at this path",
+		},
+		"kind": &command.Option{
+			Description: "Only include commands of this kind",
+			Values: &command.ValueSource{
+				Static: &([]string{string(milpaCmd.KindExecutable), string(milpaCmd.KindSource), string(milpaCmd.KindVirtual)}),
+			},
+		},
+		"include-hidden": &command.Option{
+			Type:        command.ValueTypeBoolean,
+			Description: "Include hidden commands",
+		},
+		"where": &command.Option{
+			Description: "Only include commands for which this go-template renders `true`",
+		},
 	},
 	Action: func(cmd *command.Command) error {
 		args := cmd.Arguments[0].ToValue().([]string)
@@ -108,14 +121,16 @@ var CommandTree = &command.Command{
 		depth := cmd.Options["depth"].ToValue().(int)
 		format := cmd.Options["format"].ToString()
 
-		ctLog.Debugf("looking for commands at %s depth: %d", base.Name(), depth)
-		tree.Build(base, depth)
+		filter, err := newTreeFilter(cmd.Options)
+		if err != nil {
+			return err
+		}
 
 		var serializationFn serializar
 		addMeta := func(res serializar) serializar {
 			return func(i interface{}) ([]byte, error) {
 				if t, ok := i.(*tree.CommandTree); ok {
-					addMetaToTree(t)
+					milpaCmd.AddMetaToTree(t)
 				}
 				return res(i)
 			}
@@ -131,7 +146,7 @@ var CommandTree = &command.Command{
 			tpl := template.Must(template.New("treeItem").Funcs(render.TemplateFuncs).Parse(outputTpl))
 			serializationFn = func(t interface{}) ([]byte, error) {
 				tree := t.(*tree.CommandTree)
-				addMetaToTree(tree)
+				milpaCmd.AddMetaToTree(tree)
 				var output bytes.Buffer
 				if err := tpl.Execute(&output, tree.Command); err != nil {
 					return output.Bytes(), err
@@ -139,20 +154,286 @@ var CommandTree = &command.Command{
 				err := tree.Traverse(func(cmd *command.Command) error { return tpl.Execute(&output, cmd) })
 				return output.Bytes(), err
 			}
+		case "dot":
+			serializationFn = func(t interface{}) ([]byte, error) {
+				tree := t.(*tree.CommandTree)
+				milpaCmd.AddMetaToTree(tree)
+				return treeToDot(tree), nil
+			}
+		case "markdown":
+			serializationFn = func(t interface{}) ([]byte, error) {
+				tree := t.(*tree.CommandTree)
+				milpaCmd.AddMetaToTree(tree)
+				return treeToMarkdown(tree), nil
+			}
 		case "autocomplete":
-			serializationFn = func(interface{}) ([]byte, error) {
-				return []byte(strings.Join(tree.ChildrenNames(), "\n") + "\n"), nil
+			serializationFn = func(t interface{}) ([]byte, error) {
+				names := []string{}
+				for _, child := range t.(*tree.CommandTree).Children {
+					if meta, ok := milpaCmd.MetaFor(child.Command); ok && meta.Deprecated != "" {
+						// deprecated commands are hidden from completion
+						continue
+					}
+					names = append(names, child.Command.Name())
+				}
+				return []byte(strings.Join(names, "\n") + "\n"), nil
 			}
 		default:
 			return errors.BadArguments{Msg: fmt.Sprintf("Unknown format <%s> for command tree serialization", format)}
 		}
 
-		serialized, err := tree.Serialize(serializationFn)
+		ctLog.Debugf("looking for commands at %s depth: %d", base.Name(), depth)
+		if !filter.active() {
+			tree.Build(base, depth)
+			serialized, err := tree.Serialize(serializationFn)
+			if err != nil {
+				return err
+			}
+			fmt.Print(serialized)
+			return nil
+		}
+
+		filtered, err := filter.build(base, depth)
+		if err != nil {
+			return err
+		}
+		serialized, err := serializationFn(filtered)
 		if err != nil {
 			return err
 		}
-		fmt.Print(serialized)
+		fmt.Print(string(serialized))
 
 		return nil
 	},
 }
+
+// treeFilter narrows down the commands included in a command tree.
+type treeFilter struct {
+	repo          string
+	repos         map[string]string
+	kind          milpaCmd.Kind
+	includeHidden bool
+	where         *template.Template
+}
+
+func newTreeFilter(opts command.Options) (*treeFilter, error) {
+	filter := &treeFilter{
+		repos:         map[string]string{},
+		kind:          milpaCmd.Kind(opts["kind"].ToString()),
+		includeHidden: opts["include-hidden"].ToValue().(bool),
+	}
+
+	if repo := opts["repo"].ToString(); repo != "" {
+		abs, err := filepath.Abs(repo)
+		if err != nil {
+			return nil, errors.BadArguments{Msg: fmt.Sprintf("Invalid repo path <%s>: %s", repo, err)}
+		}
+		if filepath.Base(abs) != _c.RepoRoot {
+			abs = filepath.Join(abs, _c.RepoRoot)
+		}
+		filter.repo = realPath(abs)
+	}
+
+	if where := opts["where"].ToString(); where != "" {
+		tpl, err := template.New("where").Funcs(render.TemplateFuncs).Parse(where)
+		if err != nil {
+			return nil, errors.BadArguments{Msg: fmt.Sprintf("Invalid --where template: %s", err)}
+		}
+		filter.where = tpl
+	}
+
+	return filter, nil
+}
+
+// realPath resolves symlinks in path, if it exists at all.
+func realPath(path string) string {
+	if real, err := filepath.EvalSymlinks(path); err == nil {
+		return real
+	}
+	return path
+}
+
+func (f *treeFilter) active() bool {
+	return f.repo != "" || f.kind != milpaCmd.KindUnknown || f.includeHidden || f.where != nil
+}
+
+func (f *treeFilter) matches(cmd *command.Command) (bool, error) {
+	meta, _ := milpaCmd.MetaFor(cmd)
+	if f.repo != "" {
+		// repos may be symlinked, so compare where they really are
+		if _, seen := f.repos[meta.Repo]; !seen {
+			f.repos[meta.Repo] = realPath(meta.Repo)
+		}
+		if meta.Repo == "" || f.repos[meta.Repo] != f.repo {
+			return false, nil
+		}
+	}
+
+	if f.kind != milpaCmd.KindUnknown && meta.Kind != f.kind {
+		return false, nil
+	}
+
+	if f.where != nil {
+		var res bytes.Buffer
+		if err := f.where.Execute(&res, cmd); err != nil {
+			return false, fmt.Errorf("could not evaluate --where for %s: %w", cmd.FullName(), err)
+		}
+		return strings.TrimSpace(res.String()) == "true", nil
+	}
+
+	return true, nil
+}
+
+// build walks cobra commands like tree.Build does, keeping commands that match the filter
+// along with the groups leading to them, so that only those kept get their meta added
+// during serialization.
+func (f *treeFilter) build(base *cobra.Command, maxDepth int) (*tree.CommandTree, error) {
+	commands := map[*cobra.Command]*command.Command{}
+	for _, cmd := range tree.CommandList() {
+		commands[cmd.Cobra] = cmd
+	}
+
+	root, ok := commands[base]
+	if !ok {
+		root = command.Root
+	}
+	rootTree := &tree.CommandTree{Command: root, Children: []*tree.CommandTree{}}
+
+	var populate func(cc *cobra.Command, parent *tree.CommandTree, depth int) error
+	populate = func(cc *cobra.Command, parent *tree.CommandTree, depth int) error {
+		for _, subcc := range cc.Commands() {
+			if subcc.Hidden && !f.includeHidden {
+				continue
+			}
+
+			cmd, ok := commands[subcc]
+			if !ok {
+				continue
+			}
+
+			leaf := &tree.CommandTree{Command: cmd, Children: []*tree.CommandTree{}}
+			if depth+1 < maxDepth {
+				if err := populate(subcc, leaf, depth+1); err != nil {
+					return err
+				}
+			}
+
+			matches, err := f.matches(cmd)
+			if err != nil {
+				return err
+			}
+
+			if matches || len(leaf.Children) > 0 {
+				parent.Children = append(parent.Children, leaf)
+			}
+		}
+		return nil
+	}
+
+	return rootTree, populate(base, rootTree, 0)
+}
+
+// repoColors are assigned to repos in the order they're found in the tree.
+var repoColors = []string{"#CEFCD3", "#FCE7CE", "#CEE3FC", "#F6CEFC", "#FCF8CE", "#D9CEFC", "#FCCECE", "#CEFCF4"}
+
+func treeToDot(t *tree.CommandTree) []byte {
+	var out bytes.Buffer
+	repos := []string{}
+	colors := map[string]string{}
+
+	out.WriteString("digraph commands {\n")
+	out.WriteString("  rankdir=LR;\n")
+	out.WriteString("  node [shape=box, style=\"rounded,filled\", fontname=\"Helvetica\"];\n")
+
+	var walk func(t *tree.CommandTree)
+	walk = func(t *tree.CommandTree) {
+		cmd := t.Command
+		id := strings.Join(cmd.Path, " ")
+		shape := "box"
+		color := "#FFFFFF"
+		if meta, ok := milpaCmd.MetaFor(cmd); ok {
+			if meta.Kind == milpaCmd.KindVirtual || meta.Kind == milpaCmd.KindRoot {
+				shape = "folder"
+			}
+			if meta.Repo != "" {
+				if _, seen := colors[meta.Repo]; !seen {
+					colors[meta.Repo] = repoColors[len(repos)%len(repoColors)]
+					repos = append(repos, meta.Repo)
+				}
+				color = colors[meta.Repo]
+			}
+		}
+
+		fmt.Fprintf(&out, "  %q [label=%q, tooltip=%q, shape=%s, fillcolor=%q];\n", id, cmd.Name(), cmd.Summary, shape, color)
+		for _, child := range t.Children {
+			fmt.Fprintf(&out, "  %q -> %q;\n", id, strings.Join(child.Command.Path, " "))
+			walk(child)
+		}
+	}
+	walk(t)
+
+	if len(repos) > 0 {
+		out.WriteString("  subgraph cluster_repos {\n")
+		out.WriteString("    label=\"repos\";\n")
+		for idx, repo := range repos {
+			fmt.Fprintf(&out, "    \"repo-%d\" [label=%q, shape=note, fillcolor=%q];\n", idx, repo, colors[repo])
+		}
+		out.WriteString("  }\n")
+	}
+
+	out.WriteString("}\n")
+	return out.Bytes()
+}
+
+func treeToMarkdown(t *tree.CommandTree) []byte {
+	var out bytes.Buffer
+
+	var walk func(t *tree.CommandTree, depth int)
+	walk = func(t *tree.CommandTree, depth int) {
+		cmd := t.Command
+		indent := strings.Repeat("  ", depth)
+		fmt.Fprintf(&out, "%s- `%s`", indent, strings.Join(cmd.Path, " "))
+		if cmd.Summary != "" {
+			fmt.Fprintf(&out, ": %s", cmd.Summary)
+		}
+		out.WriteString("\n")
+
+		if len(cmd.Arguments) > 0 {
+			fmt.Fprintf(&out, "%s  - Arguments:\n", indent)
+			for _, arg := range cmd.Arguments {
+				name := strings.ToUpper(arg.Name)
+				if arg.Variadic {
+					name += "..."
+				}
+				if !arg.Required {
+					name = "[" + name + "]"
+				}
+				fmt.Fprintf(&out, "%s    - `%s`: %s\n", indent, name, arg.Description)
+			}
+		}
+
+		if len(cmd.Options) > 0 {
+			names := make([]string, 0, len(cmd.Options))
+			for name := range cmd.Options {
+				names = append(names, name)
+			}
+			sort.Strings(names)
+
+			fmt.Fprintf(&out, "%s  - Options:\n", indent)
+			for _, name := range names {
+				flag := "--" + name
+				if short := cmd.Options[name].ShortName; short != "" {
+					flag = "-" + short + ", " + flag
+				}
+				fmt.Fprintf(&out, "%s    - `%s`: %s\n", indent, flag, cmd.Options[name].Description)
+			}
+		}
+
+		for _, child := range t.Children {
+			walk(child, depth+1)
+		}
+	}
+	walk(t, 0)
+
+	return out.Bytes()
+}
@@ -0,0 +1,174 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright © 2021 Roberto Hidalgo <milpa@un.rob.mx>
+package actions
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"git.rob.mx/nidito/chinampa/pkg/command"
+	"git.rob.mx/nidito/chinampa/pkg/errors"
+	"git.rob.mx/nidito/chinampa/pkg/tree"
+	milpaCmd "github.com/unrob/milpa/internal/command"
+	_c "github.com/unrob/milpa/internal/constants"
+	milpaErrors "github.com/unrob/milpa/internal/errors"
+)
+
+// currentTree serializes the command tree of the running process.
+func currentTree(cc *command.Command) ([]byte, error) {
+	tree.Build(cc.Cobra.Root(), 15)
+	serialized, err := tree.Serialize(func(t any) ([]byte, error) {
+		if ct, ok := t.(*tree.CommandTree); ok {
+			milpaCmd.AddMetaToTree(ct)
+		}
+		return json.Marshal(t)
+	})
+	return []byte(serialized), err
+}
+
+// treeForRepos serializes the command tree made up exclusively of repos, a colon-delimited list of paths.
+func treeForRepos(repos string) ([]byte, error) {
+	paths := []string{}
+	for _, path := range strings.Split(repos, ":") {
+		if path == "" {
+			continue
+		}
+
+		abs, err := filepath.Abs(path)
+		if err != nil {
+			return nil, err
+		}
+		if filepath.Base(abs) != _c.RepoRoot {
+			abs = filepath.Join(abs, _c.RepoRoot)
+		}
+
+		if fi, err := os.Stat(abs); err != nil || !fi.IsDir() {
+			return nil, errors.BadArguments{Msg: fmt.Sprintf("No repo found at %s", abs)}
+		}
+		paths = append(paths, abs)
+	}
+
+	if len(paths) == 0 {
+		return nil, errors.BadArguments{Msg: fmt.Sprintf("No repos found in <%s>", repos)}
+	}
+
+	self, err := os.Executable()
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, self, "__command_tree", "--format", "json") // nolint: gosec
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	cmd.Env = append(os.Environ(),
+		_c.EnvVarMilpaPath+"="+strings.Join(paths, ":"),
+		_c.EnvVarMilpaPathParsed+"=true",
+	)
+
+	ctLog.Debugf("serializing command tree for %s", paths)
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("could not serialize command tree for %s: %w\n%s", repos, err, stderr.String())
+	}
+
+	return stdout.Bytes(), nil
+}
+
+// loadTree reads a json snapshot of a command tree, or builds one from a list of repos.
+func loadTree(cmd *command.Command, source string) ([]byte, error) {
+	if source == "" {
+		return currentTree(cmd)
+	}
+
+	if fi, err := os.Stat(source); err == nil && fi.Mode().IsRegular() {
+		return os.ReadFile(source) // nolint: gosec
+	}
+
+	return treeForRepos(source)
+}
+
+var CommandTreeDiff = &command.Command{
+	Path:    []string{"__command_tree_diff"},
+	Hidden:  true,
+	Summary: "Compares two command trees",
+	Description: `Reports commands, arguments and options that were added, removed or changed between two command trees, exiting with status 3 if any of the changes is breaking.
+
+  Each tree is either a path to a json snapshot, as output by ﹅__command_tree --format json﹅, or a colon-delimited list of repos. If no CURRENT tree is specified, the tree for the current MILPA_PATH is used.`,
+	Arguments: command.Arguments{
+		{
+			Name:        "previous",
+			Description: "A json snapshot or list of repos to compare against",
+			Required:    true,
+		},
+		{
+			Name:        "current",
+			Description: "A json snapshot or list of repos with changes",
+		},
+	},
+	Options: command.Options{
+		"format": &command.Option{
+			Default:     "text",
+			Description: "The format to output results in",
+			Values: &command.ValueSource{
+				Static: &([]string{"text", "json"}),
+			},
+		},
+	},
+	Action: func(cmd *command.Command) error {
+		previous, err := loadTree(cmd, cmd.Arguments[0].ToString())
+		if err != nil {
+			return err
+		}
+
+		current, err := loadTree(cmd, cmd.Arguments[1].ToString())
+		if err != nil {
+			return err
+		}
+
+		changes, err := milpaCmd.DiffTrees(previous, current)
+		if err != nil {
+			return err
+		}
+
+		breaking := 0
+		for _, change := range changes {
+			if change.Breaking {
+				breaking++
+			}
+		}
+
+		switch format := cmd.Options["format"].ToString(); format {
+		case "json":
+			serialized, err := json.MarshalIndent(changes, "", "  ")
+			if err != nil {
+				return err
+			}
+			fmt.Println(string(serialized))
+		case "text":
+			for _, change := range changes {
+				marker := " "
+				if change.Breaking {
+					marker = "!"
+				}
+				fmt.Printf("%s %s\n", marker, change)
+			}
+		default:
+			return errors.BadArguments{Msg: fmt.Sprintf("Unknown format <%s> for command tree diff", format)}
+		}
+
+		ctLog.Infof("found %d changes, %d breaking", len(changes), breaking)
+		if breaking > 0 {
+			return milpaErrors.BreakingChangesError{Count: breaking}
+		}
+		return nil
+	},
+}
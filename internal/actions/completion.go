@@ -0,0 +1,106 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright © 2021 Roberto Hidalgo <milpa@un.rob.mx>
+package actions
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"git.rob.mx/nidito/chinampa/pkg/command"
+	"git.rob.mx/nidito/chinampa/pkg/errors"
+	"github.com/spf13/cobra"
+)
+
+// nushellCompletion is an external completer for nushell that calls `__complete` and
+// understands cobra's ShellCompDirective values. Completions for other programs are
+// delegated to any previously configured external completer.
+const nushellCompletion = `# nushell completion for @NAME@
+# generated by ﹅@NAME@ itself install-autocomplete﹅, load with:
+#   source @NAME@.nu
+
+let __@IDENT@_completer = {|spans: list<string>|
+  let lines = (^@NAME@ __complete ...($spans | skip 1) | complete | get stdout | lines)
+  let directive = ($lines | where {|line| $line starts-with ":" } | append ":0" | first | str replace ":" "" | into int)
+
+  # ShellCompDirectiveError
+  if ($directive bit-and @ERROR@) != 0 {
+    return null
+  }
+
+  # ShellCompDirectiveFilterFileExt and ShellCompDirectiveFilterDirs, use nushell's file completion
+  if ($directive bit-and @FILES@) != 0 {
+    return null
+  }
+
+  let values = ($lines
+    | where {|line| not ($line starts-with ":") and ($line | is-not-empty) }
+    | each {|line|
+      let parts = ($line | split row "\t")
+      if ($parts | length) > 1 {
+        {value: $parts.0, description: ($parts | skip 1 | str join "\t")}
+      } else {
+        {value: $parts.0}
+      }
+    })
+
+  # without ShellCompDirectiveNoFileComp, files are offered when no values are found
+  if ($values | is-empty) and ($directive bit-and @NOFILES@) == 0 {
+    return null
+  }
+
+  $values
+}
+
+let __@IDENT@_previous_completer = ($env.config.completions.external.completer? | default null)
+$env.config.completions.external.enable = true
+$env.config.completions.external.completer = {|spans: list<string>|
+  if ($spans | first) == "@NAME@" {
+    do $__@IDENT@_completer $spans
+  } else if $__@IDENT@_previous_completer != null {
+    do $__@IDENT@_previous_completer $spans
+  } else {
+    null
+  }
+}
+`
+
+func nushellScript(name string) string {
+	return strings.NewReplacer(
+		"﹅", "`",
+		"@NAME@", name,
+		"@IDENT@", strings.ReplaceAll(name, "-", "_"),
+		"@ERROR@", fmt.Sprint(int(cobra.ShellCompDirectiveError)),
+		"@FILES@", fmt.Sprint(int(cobra.ShellCompDirectiveFilterFileExt|cobra.ShellCompDirectiveFilterDirs)),
+		"@NOFILES@", fmt.Sprint(int(cobra.ShellCompDirectiveNoFileComp)),
+	).Replace(nushellCompletion)
+}
+
+var CompletionScript = &command.Command{
+	Path:        []string{"__completion_script"},
+	Hidden:      true,
+	Summary:     "Outputs a completion script for shells not supported by __generate_completions",
+	Description: "Prints a completion script for ﹅nushell﹅ or ﹅powershell﹅ that calls ﹅__complete﹅ for values.",
+	Arguments: command.Arguments{
+		{
+			Name:        "shell",
+			Description: "The shell to generate a completion script for",
+			Required:    true,
+			Values: &command.ValueSource{
+				Static: &([]string{"nushell", "powershell"}),
+			},
+		},
+	},
+	Action: func(cmd *command.Command) error {
+		root := cmd.Cobra.Root()
+		switch shell := cmd.Arguments[0].ToString(); shell {
+		case "nushell":
+			_, err := fmt.Fprint(os.Stdout, nushellScript(root.Name()))
+			return err
+		case "powershell":
+			return root.GenPowerShellCompletionWithDesc(os.Stdout)
+		default:
+			return errors.BadArguments{Msg: fmt.Sprintf("Unknown shell <%s>", shell)}
+		}
+	},
+}
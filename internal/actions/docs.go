@@ -4,13 +4,13 @@ package actions
 
 import (
 	"bytes"
+	"context"
 	"fmt"
-	"net/http"
 	"os"
 	"os/signal"
 	"regexp"
 	"strings"
-	"time"
+	"syscall"
 
 	"git.rob.mx/nidito/chinampa/pkg/command"
 	"git.rob.mx/nidito/chinampa/pkg/env"
@@ -19,6 +19,7 @@ import (
 	"git.rob.mx/nidito/chinampa/pkg/statuscode"
 	"github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
+	"github.com/unrob/milpa/internal/bootstrap"
 	"github.com/unrob/milpa/internal/docs"
 
 	"git.rob.mx/nidito/chinampa/pkg/logger"
@@ -31,25 +32,35 @@ var dlog = logger.Sub("action:docs")
 
 var AfterHelp = os.Exit
 
-func startServer(listen, address string) error {
+func startServer(cfg docs.ServerConfig) error {
 	os.Setenv(env.HelpStyle, "markdown")
-	// Replace with DevelopmentStaticResourceHandler to use locally available
-	// static resources during development
-	http.Handle("/static/", docs.EmbeddedStaticResourceHandler())
-	http.HandleFunc("/", docs.RenderHandler(address))
-
-	server := &http.Server{
-		Addr:              listen,
-		ReadHeaderTimeout: 3 * time.Second,
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	return docs.Serve(ctx, cfg)
+}
+
+// serverConfig reads the docs server settings from opts, deriving the base URL from
+// `--listen` unless `--base` was set to something other than its default.
+func serverConfig(opts command.Options) docs.ServerConfig {
+	cfg := docs.ServerConfig{
+		Listen:     opts["listen"].ToString(),
+		Base:       opts["base"].ToString(),
+		PathPrefix: docs.NormalizePathPrefix(opts["path-prefix"].ToString()),
+		TLSCert:    opts["tls-cert"].ToString(),
+		TLSKey:     opts["tls-key"].ToString(),
+		Watch:      opts["watch"].ToValue().(bool),
+		Repos:      bootstrap.MilpaPath,
+	}
+
+	if cfg.Base == opts["base"].Default.(string) && !strings.HasPrefix(cfg.Listen, docs.UnixSocketPrefix) {
+		scheme := "http"
+		if cfg.TLSCert != "" {
+			scheme = "https"
+		}
+		cfg.Base = scheme + "://" + cfg.Listen
 	}
 
-	c := make(chan os.Signal, 1)
-	signal.Notify(c, os.Interrupt)
-	go func() {
-		<-c
-		os.Exit(0)
-	}()
-	return server.ListenAndServe()
+	return cfg
 }
 
 func init() {
@@ -57,10 +68,7 @@ func init() {
 	// the correct address in its own help
 	// as well as rendering available docs topics
 	Docs.HelpFunc = func(printLinks bool) string {
-		base := Docs.Options["base"].ToString()
-		listen := Docs.Options["listen"]
-		defaultListen := listen.Default.(string)
-		base = strings.ReplaceAll(base, defaultListen, listen.ToString())
+		base := serverConfig(Docs.Options).URL()
 		dlog.Debug("showing docs help")
 		topics, err := lookup.Docs([]string{}, "", false)
 		if err != nil {
@@ -83,6 +91,8 @@ milpa help docs --server
 # then head to http://localhost:4242
 ﹅﹅﹅
 
+Pass ﹅--watch﹅ to pick up changes to command specs and docs in your ﹅MILPA_PATH﹅ while the server is running, and reload any open browser tabs as files are saved.
+
 Command and docs are available at their names, replacing spaces with forward slashes ﹅/﹅, for example:
 
 - ` + base + `/help/docs will show this help page.
@@ -134,16 +144,36 @@ var Docs = &command.Command{
 			Type:        command.ValueTypeBoolean,
 			Default:     false,
 		},
+		"watch": {
+			Description: "Reloads commands and open browser tabs when specs or docs change, when using `--server`",
+			Type:        command.ValueTypeBoolean,
+			Default:     false,
+		},
 		"listen": {
-			Description: "The address to listen at when using `--server`",
+			Description: "The address to listen at when using `--server`, or the path to a unix socket prefixed with `unix:`",
 			Type:        command.ValueTypeString,
 			Default:     "localhost:4242",
 		},
 		"base": {
-			Description: "A URL base to use for rendering html links",
+			Description: "A URL base to use for rendering html links, excluding `--path-prefix`",
 			Type:        command.ValueTypeString,
 			Default:     "http://localhost:4242",
 		},
+		"path-prefix": {
+			Description: "A path to serve docs under when using `--server`, for example behind a reverse proxy",
+			Type:        command.ValueTypeString,
+			Default:     "",
+		},
+		"tls-cert": {
+			Description: "The path to a PEM-encoded certificate to serve docs over https, requires `--tls-key`",
+			Type:        command.ValueTypeString,
+			Default:     "",
+		},
+		"tls-key": {
+			Description: "The path to the PEM-encoded private key for `--tls-cert`",
+			Type:        command.ValueTypeString,
+			Default:     "",
+		},
 	},
 	Meta: milpaCommand.Meta{
 		Path: os.Getenv(_c.EnvVarMilpaRoot) + "/milpa/docs",
@@ -155,12 +185,12 @@ var Docs = &command.Command{
 		args := cmd.Arguments[0].ToValue().([]string)
 		if len(args) == 0 {
 			if cmd.Options["server"].ToValue().(bool) {
-				listen := cmd.Options["listen"].ToString()
-				base := cmd.Options["base"]
-				defaultListen := cmd.Options["listen"].Default.(string)
-				address := strings.ReplaceAll(base.ToString(), defaultListen, listen)
-				dlog.Infof("Starting docs server at http://%s, press CTRL-C to stop...", listen)
-				return startServer(listen, address)
+				cfg := serverConfig(cmd.Options)
+				if (cfg.TLSCert == "") != (cfg.TLSKey == "") {
+					return errors.BadArguments{Msg: "--tls-cert and --tls-key must be used together"}
+				}
+				dlog.Infof("Starting docs server at %s (listening on %s), press CTRL-C to stop...", cfg.URL(), cfg.Listen)
+				return startServer(cfg)
 			}
 			dlog.Debug("Rendering docs help page")
 			err := cmd.Cobra.Help()
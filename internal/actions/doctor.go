@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"os"
 	"strings"
+	"time"
 
 	"git.rob.mx/nidito/chinampa/pkg/command"
 	"git.rob.mx/nidito/chinampa/pkg/logger"
@@ -68,7 +69,10 @@ var Doctor = &command.Command{
 		fmt.Fprintln(out, "")
 		bold.Fprintf(out, "Runnable commands:\n")
 
-		for _, cmd := range tree.CommandList() {
+		commands := tree.CommandList()
+		aliasConflicts := mcmd.AliasConflicts(commands)
+		now := time.Now()
+		for _, cmd := range commands {
 			if cmd.Hidden {
 				continue
 			}
@@ -79,6 +83,11 @@ var Doctor = &command.Command{
 			hasFailures := false
 			report := map[string]int{}
 			if meta, ok := cmd.Meta.(mcmd.Meta); ok {
+				if len(meta.AliasOf) > 0 {
+					// aliases are validated along the command they belong to
+					continue
+				}
+
 				// fmt.Println("hasmeta")
 				parsingErrors := meta.ParsingErrors()
 				if len(parsingErrors) > 0 {
@@ -91,6 +100,20 @@ var Doctor = &command.Command{
 				} else {
 					report = cmd.Validate()
 				}
+
+				if meta.RemovalDue(now) {
+					hasFailures = true
+					failures[cmd.FullName()]++
+					message += fail.Sprintf("  - scheduled for removal after %s, delete it or extend removed-after\n", meta.RemovedAfter)
+				} else if meta.Deprecated != "" {
+					message += warn.Sprintf("  - deprecated: %s\n", meta.DeprecationNotice())
+				}
+
+				for _, alias := range aliasConflicts[strings.Join(cmd.Path, " ")] {
+					hasFailures = true
+					failures[cmd.FullName()]++
+					message += fail.Sprintf("  - alias %s collides with an existing command or alias\n", alias)
+				}
 			} else {
 				report = cmd.Validate()
 			}
@@ -0,0 +1,501 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright © 2021 Roberto Hidalgo <milpa@un.rob.mx>
+package actions
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"git.rob.mx/nidito/chinampa/pkg/command"
+	"git.rob.mx/nidito/chinampa/pkg/errors"
+	"github.com/fatih/color"
+	"github.com/unrob/milpa/internal/bootstrap"
+	_c "github.com/unrob/milpa/internal/constants"
+	"github.com/unrob/milpa/internal/repo"
+)
+
+var repoFormatOption = &command.Option{
+	Default:     "text",
+	Description: "The format to output results in",
+	Values: &command.ValueSource{
+		Static: &([]string{"text", "json"}),
+	},
+}
+
+var repoGlobalOption = &command.Option{
+	Type:        command.ValueTypeBoolean,
+	Description: "Use the repos at MILPA_ROOT instead of the user's repos",
+}
+
+var repoInsecureOption = &command.Option{
+	Type:        command.ValueTypeBoolean,
+	Description: "Install repos that are unsigned or not signed by a trusted key",
+}
+
+// repoTrust returns the keys trusted to sign repos, and whether cmd allows unverified repos.
+func repoTrust(cmd *command.Command) (*repo.Trust, error) {
+	keys, err := repo.LoadKeys(allRepoDirs()...)
+	if err != nil {
+		return nil, fmt.Errorf("could not load trusted keys: %w", err)
+	}
+	return &repo.Trust{Keys: keys, Insecure: cmd.Options["insecure"].ToValue().(bool)}, nil
+}
+
+// trustLabel describes the trust status of inst for humans.
+func trustLabel(inst *repo.Installed) string {
+	switch inst.Trust {
+	case repo.TrustVerified:
+		return color.GreenString("verified") + " by " + inst.Signer
+	case repo.TrustUntrusted:
+		label := color.RedString("untrusted")
+		if inst.Signer != "" {
+			label += " signer " + inst.Signer
+		}
+		return label
+	case repo.TrustUnsigned:
+		return color.YellowString("unsigned")
+	case "":
+		return "trust unknown"
+	default:
+		return string(inst.Trust)
+	}
+}
+
+// repoDirs returns the folders for global repos if global is set, or the user's otherwise.
+func repoDirs(global bool) (repo.Dirs, error) {
+	if global {
+		return repo.GlobalDirs(bootstrap.MilpaRoot), nil
+	}
+
+	dirs, ok := repo.UserDirs()
+	if !ok {
+		return dirs, fmt.Errorf("could not find the user's repos, neither XDG_DATA_HOME nor HOME are set")
+	}
+	return dirs, nil
+}
+
+// allRepoDirs returns the folders for the user's repos, if available, and global repos.
+func allRepoDirs() []repo.Dirs {
+	return repo.AllDirs(bootstrap.MilpaRoot)
+}
+
+// writeRepoHooks lists paths in COMPA_OUT, so the calling script can run their hooks.
+func writeRepoHooks(paths []string) error {
+	out := os.Getenv(_c.EnvVarCompaOut)
+	if out == "" || len(paths) == 0 {
+		return nil
+	}
+	return os.WriteFile(out, []byte(strings.Join(paths, "\n")+"\n"), 0600) // nolint: gosec
+}
+
+// printRepos prints installed repos as json, or one per line as text.
+func printRepos(cmd *command.Command, installed []*repo.Installed) error {
+	switch format := cmd.Options["format"].ToString(); format {
+	case "json":
+		serialized, err := json.MarshalIndent(installed, "", "  ")
+		if err != nil {
+			return err
+		}
+		fmt.Println(string(serialized))
+	case "text":
+		for _, inst := range installed {
+			fmt.Println(inst.Path)
+		}
+	default:
+		return errors.BadArguments{Msg: fmt.Sprintf("Unknown format <%s> for repos", format)}
+	}
+	return nil
+}
+
+var RepoInstall = &command.Command{
+	Path:        []string{"__repo_install"},
+	Hidden:      true,
+	Summary:     "Installs a milpa repo",
+	Description: "Symlinks a local repo, unpacks an archive, or clones the ﹅.milpa﹅ folder of a git repo, into the user's or global repos, along with the repos it depends on. Installed paths are listed in ﹅COMPA_OUT﹅, dependencies first, so their post-install hooks can run.",
+	Arguments: command.Arguments{
+		{
+			Name:        "source",
+			Description: "A path, archive or git clone URL to install",
+			Required:    true,
+		},
+	},
+	Options: command.Options{
+		"global": repoGlobalOption,
+		"ref": &command.Option{
+			Default:     "",
+			Description: "A branch, tag or commit to install",
+		},
+		"insecure": repoInsecureOption,
+		"format":   repoFormatOption,
+	},
+	Action: func(cmd *command.Command) error {
+		dirs, err := repoDirs(cmd.Options["global"].ToValue().(bool))
+		if err != nil {
+			return err
+		}
+
+		trust, err := repoTrust(cmd)
+		if err != nil {
+			return err
+		}
+
+		// dependencies of user repos may already be installed globally
+		available := []repo.Dirs{}
+		if dirs.Scope == repo.ScopeUser {
+			available = append(available, repo.GlobalDirs(bootstrap.MilpaRoot))
+		}
+
+		installed, err := repo.InstallWithDependencies(cmd.Arguments[0].ToString(), dirs, cmd.Options["ref"].ToString(), trust, available...)
+		if err != nil {
+			return err
+		}
+
+		paths := []string{}
+		for _, inst := range installed {
+			paths = append(paths, inst.Path)
+		}
+		if err := writeRepoHooks(paths); err != nil {
+			return err
+		}
+		return printRepos(cmd, installed)
+	},
+}
+
+var RepoList = &command.Command{
+	Path:        []string{"__repo_list"},
+	Hidden:      true,
+	Summary:     "Lists installed repos",
+	Description: "Lists the user's and global repos.",
+	Options: command.Options{
+		"paths-only": &command.Option{
+			Type:        command.ValueTypeBoolean,
+			Description: "Only output the paths to repos with no decoration",
+		},
+		"cloned": &command.Option{
+			Type:        command.ValueTypeBoolean,
+			Description: "Only output cloned repos",
+		},
+		"format": repoFormatOption,
+	},
+	Action: func(cmd *command.Command) error {
+		cloned := cmd.Options["cloned"].ToValue().(bool)
+		pathsOnly := cmd.Options["paths-only"].ToValue().(bool)
+		format := cmd.Options["format"].ToString()
+
+		dirs := allRepoDirs()
+		installed, err := repo.List(dirs...)
+		if err != nil {
+			return err
+		}
+
+		if cloned {
+			clones := []*repo.Installed{}
+			for _, inst := range installed {
+				if inst.Kind == repo.KindClone {
+					clones = append(clones, inst)
+				}
+			}
+			installed = clones
+		}
+
+		if pathsOnly || cloned || format != "text" {
+			return printRepos(cmd, installed)
+		}
+
+		bold := color.New(color.Bold)
+		inverted := color.New(color.ReverseVideo)
+		headings := map[repo.Scope]string{
+			repo.ScopeUser:   " Local repos ",
+			repo.ScopeGlobal: " Global repos ",
+		}
+		for idx, d := range dirs {
+			if idx > 0 {
+				fmt.Println()
+			}
+			fmt.Printf("%s: %s\n", inverted.Sprint(headings[d.Scope]), d.Repos)
+			for _, inst := range installed {
+				if inst.Scope == d.Scope {
+					fmt.Printf("%s - %s (%s)\n", bold.Sprint(inst.Path), inst, trustLabel(inst))
+					if meta := inst.Metadata; meta != nil && meta.Name != "" {
+						line := meta.String()
+						if meta.Description != "" {
+							line += ": " + meta.Description
+						}
+						fmt.Printf("  %s\n", line)
+					}
+				}
+			}
+		}
+		return nil
+	},
+}
+
+var RepoUpgrade = &command.Command{
+	Path:        []string{"__repo_upgrade"},
+	Hidden:      true,
+	Summary:     "Upgrades installed milpa repos",
+	Description: "Upgrades a cloned repo to the latest commit of its branch, or every cloned repo if no PATH is given. Repos pinned to a tag or commit are only upgraded with ﹅--latest﹅.",
+	Arguments: command.Arguments{
+		{
+			Name:        "path",
+			Description: "The path or name of the repo to upgrade",
+		},
+	},
+	Options: command.Options{
+		"latest": &command.Option{
+			Type:        command.ValueTypeBoolean,
+			Description: "Upgrade repos pinned to a tag or commit to the newest tag or default branch",
+		},
+		"insecure": repoInsecureOption,
+		"format":   repoFormatOption,
+	},
+	Action: func(cmd *command.Command) error {
+		dirs := allRepoDirs()
+		targets := []*repo.Installed{}
+		if query := cmd.Arguments[0].ToString(); query != "" {
+			inst, err := repo.Find(query, dirs...)
+			if err != nil {
+				return errors.BadArguments{Msg: err.Error()}
+			}
+			targets = append(targets, inst)
+		} else {
+			installed, err := repo.List(dirs...)
+			if err != nil {
+				return err
+			}
+			for _, inst := range installed {
+				if inst.Kind == repo.KindClone && (inst.Branch != "" || inst.Ref != "") {
+					targets = append(targets, inst)
+				}
+			}
+		}
+
+		trust, err := repoTrust(cmd)
+		if err != nil {
+			return err
+		}
+
+		upgraded := []*repo.Installed{}
+		for _, inst := range targets {
+			res, err := repo.Upgrade(inst, cmd.Options["latest"].ToValue().(bool), trust)
+			if err != nil {
+				return err
+			}
+			upgraded = append(upgraded, res)
+		}
+
+		return printRepos(cmd, upgraded)
+	},
+}
+
+var RepoOutdated = &command.Command{
+	Path:        []string{"__repo_outdated"},
+	Hidden:      true,
+	Summary:     "Lists cloned repos behind their remote",
+	Description: "Lists cloned repos whose branch has newer commits at their remote, or that are pinned to a tag or commit older than the newest tag or default branch.",
+	Options: command.Options{
+		"format": repoFormatOption,
+	},
+	Action: func(cmd *command.Command) error {
+		installed, err := repo.List(allRepoDirs()...)
+		if err != nil {
+			return err
+		}
+
+		// repos that could not be checked are listed along the rest before failing
+		outdated, checkErr := repo.Outdated(installed)
+		switch format := cmd.Options["format"].ToString(); format {
+		case "json":
+			serialized, err := json.MarshalIndent(outdated, "", "  ")
+			if err != nil {
+				return err
+			}
+			fmt.Println(string(serialized))
+		case "text":
+			bold := color.New(color.Bold)
+			for _, drift := range outdated {
+				fmt.Printf("%s - %s\n", bold.Sprint(drift.Path), drift)
+			}
+		default:
+			return errors.BadArguments{Msg: fmt.Sprintf("Unknown format <%s> for repos", format)}
+		}
+		return checkErr
+	},
+}
+
+var RepoPack = &command.Command{
+	Path:        []string{"__repo_pack"},
+	Hidden:      true,
+	Summary:     "Packs a milpa repo into an archive",
+	Description: "Writes the ﹅.milpa﹅ folder of a repo, without ﹅.git﹅ folders, into a ﹅.tar.gz﹅, ﹅.tgz﹅ or ﹅.zip﹅ archive.",
+	Arguments: command.Arguments{
+		{
+			Name:        "path",
+			Description: "The folder containing the .milpa folder to pack",
+			Default:     ".",
+		},
+	},
+	Options: command.Options{
+		"file": &command.Option{
+			Default:     "",
+			Description: "The archive to write, defaults to the name of the repo's folder, with a .tar.gz extension",
+		},
+		"format": repoFormatOption,
+	},
+	Action: func(cmd *command.Command) error {
+		dir, err := filepath.Abs(cmd.Arguments[0].ToString())
+		if err != nil {
+			return err
+		}
+
+		dest := cmd.Options["file"].ToString()
+		if dest == "" {
+			name := filepath.Base(strings.TrimSuffix(dir, string(filepath.Separator)+_c.RepoRoot))
+			dest = strings.TrimPrefix(name, ".") + ".tar.gz"
+		}
+
+		sum, err := repo.Pack(dir, dest)
+		if err != nil {
+			return err
+		}
+
+		switch format := cmd.Options["format"].ToString(); format {
+		case "json":
+			serialized, err := json.MarshalIndent(map[string]string{"path": dest, "sha256": sum}, "", "  ")
+			if err != nil {
+				return err
+			}
+			fmt.Println(string(serialized))
+		case "text":
+			fmt.Printf("%s %s\n", sum, dest)
+		default:
+			return errors.BadArguments{Msg: fmt.Sprintf("Unknown format <%s> for repos", format)}
+		}
+		return nil
+	},
+}
+
+var RepoUninstall = &command.Command{
+	Path:        []string{"__repo_uninstall"},
+	Hidden:      true,
+	Summary:     "Removes an installed milpa repo",
+	Description: "Removes an installed repo, and its clone, if any. The repo's post-uninstall hook is copied to ﹅COMPA_OUT﹅ before removal, so it can run afterwards.",
+	Arguments: command.Arguments{
+		{
+			Name:        "path",
+			Description: "The path or name of the repo to uninstall",
+			Required:    true,
+		},
+	},
+	Options: command.Options{
+		"format": repoFormatOption,
+	},
+	Action: func(cmd *command.Command) error {
+		inst, err := repo.Find(cmd.Arguments[0].ToString(), allRepoDirs()...)
+		if err != nil {
+			return errors.BadArguments{Msg: err.Error()}
+		}
+
+		// the hook is gone once the repo is removed, so the calling script gets a copy to run
+		if hook, err := os.ReadFile(filepath.Join(inst.Path, "hooks", "post-uninstall.sh")); err == nil {
+			if out := os.Getenv(_c.EnvVarCompaOut); out != "" {
+				if err := os.WriteFile(out, hook, 0600); err != nil { // nolint: gosec
+					return err
+				}
+			}
+		}
+
+		if err := repo.Uninstall(inst); err != nil {
+			return fmt.Errorf("could not uninstall %s: %w", inst.Path, err)
+		}
+
+		return printRepos(cmd, []*repo.Installed{inst})
+	},
+}
+
+var RepoSync = &command.Command{
+	Path:    []string{"__repo_sync"},
+	Hidden:  true,
+	Summary: "Installs or upgrades the repos listed in a manifest",
+	Description: `Installs or upgrades the repos listed in a ﹅` + repo.ManifestName + `﹅ manifest to the commits pinned in its lockfile, ﹅` + repo.LockfileName + `﹅, creating or updating it as needed.
+
+  The path of every newly installed repo is listed in ﹅COMPA_OUT﹅, so their post-install hooks can run, even if a later repo fails to sync.`,
+	Options: command.Options{
+		"manifest": &command.Option{
+			Default:     repo.ManifestName,
+			Description: "The path to the manifest",
+		},
+		"global": repoGlobalOption,
+		"update": &command.Option{
+			Type:        command.ValueTypeBoolean,
+			Description: "Resolve every ref again, ignoring the lockfile",
+		},
+		"insecure": repoInsecureOption,
+		"format":   repoFormatOption,
+	},
+	Action: func(cmd *command.Command) error {
+		manifestPath := cmd.Options["manifest"].ToString()
+		manifest, err := repo.ReadManifest(manifestPath)
+		if err != nil {
+			if os.IsNotExist(err) {
+				return errors.BadArguments{Msg: fmt.Sprintf("No manifest found at %s", manifestPath)}
+			}
+			return err
+		}
+
+		lockPath := repo.LockfilePath(manifestPath)
+		lock, err := repo.ReadLockfile(lockPath)
+		if err != nil {
+			return err
+		}
+
+		dirs, err := repoDirs(cmd.Options["global"].ToValue().(bool))
+		if err != nil {
+			return err
+		}
+
+		trust, err := repoTrust(cmd)
+		if err != nil {
+			return err
+		}
+
+		// repos synced before a failure stay installed, so they're locked and their hooks run
+		results, lock, syncErr := repo.Sync(manifest, lock, dirs, cmd.Options["update"].ToValue().(bool), trust)
+		if err := lock.Write(lockPath); err != nil {
+			return fmt.Errorf("could not write lockfile at %s: %w", lockPath, err)
+		}
+
+		installed := []string{}
+		for _, res := range results {
+			if res.Status == repo.SyncInstalled {
+				installed = append(installed, res.Path)
+			}
+		}
+		if err := writeRepoHooks(installed); err != nil {
+			return err
+		}
+
+		if syncErr != nil {
+			return syncErr
+		}
+
+		switch format := cmd.Options["format"].ToString(); format {
+		case "json":
+			serialized, err := json.MarshalIndent(results, "", "  ")
+			if err != nil {
+				return err
+			}
+			fmt.Println(string(serialized))
+		case "text":
+			for _, res := range results {
+				fmt.Printf("%s %s\n", res.Status, res.Path)
+			}
+		default:
+			return errors.BadArguments{Msg: fmt.Sprintf("Unknown format <%s> for repos", format)}
+		}
+		return nil
+	},
+}
@@ -3,27 +3,46 @@
 package bootstrap
 
 import (
-	"bytes"
-	"context"
 	"fmt"
 	"os"
-	"os/exec"
 	"path/filepath"
 	"strings"
-	"time"
 
 	"git.rob.mx/nidito/chinampa/pkg/logger"
 	_c "github.com/unrob/milpa/internal/constants"
 	"github.com/unrob/milpa/internal/errors"
+	"github.com/unrob/milpa/internal/repo"
 	"github.com/unrob/milpa/internal/util"
 )
 
 var log = logger.Sub("bootstrap")
 var MilpaPath = ParseMilpaPath()
 
-// ParseMilpaPath turns MILPA_PATH into a string slice.
+// Mounts holds the command prefix repos in MilpaPath are mounted under, by repo path.
+var Mounts = map[string]string{}
+
+// ParseMilpaPath turns MILPA_PATH into a string slice, recording the Mounts of entries
+// like `infra=~/code/infra`.
 func ParseMilpaPath() []string {
-	return strings.Split(os.Getenv(_c.EnvVarMilpaPath), ":")
+	Mounts = map[string]string{}
+	paths := []string{}
+	for _, entry := range strings.Split(os.Getenv(_c.EnvVarMilpaPath), ":") {
+		mount, path := repo.SplitMount(entry)
+		if mount != "" {
+			Mounts[path] = mount
+		}
+		paths = append(paths, path)
+	}
+	return paths
+}
+
+// MilpaPathEnv joins paths back into a MILPA_PATH, along with their Mounts.
+func MilpaPathEnv(paths []string) string {
+	entries := make([]string, len(paths))
+	for idx, path := range paths {
+		entries[idx] = repo.JoinMount(Mounts[path], path)
+	}
+	return strings.Join(entries, ":")
 }
 
 func CheckMilpaPathSet() error {
@@ -36,6 +55,9 @@ func CheckMilpaPathSet() error {
 // MilpaRoot points to the system's milpa installation.
 var MilpaRoot = "/usr/local/lib/milpa"
 
+// MilpaVersion is the version of milpa running, repos requiring other versions are skipped.
+var MilpaVersion = "beta"
+
 func Run() error {
 	envRoot := os.Getenv(_c.EnvVarMilpaRoot)
 	pathMap := NewPathBuilder()
@@ -50,12 +72,15 @@ func Run() error {
 		return errors.EnvironmentError{Err: fmt.Errorf("%s (%s) is not a directory", _c.EnvVarMilpaRoot, MilpaRoot)}
 	}
 
-	if len(MilpaPath) != 0 && MilpaPath[0] != "" {
-		if util.IsTrueIsh(os.Getenv(_c.EnvVarMilpaPathParsed)) {
-			log.Debugf("%s already parsed upstream. %d items found", _c.EnvVarMilpaPath, len(MilpaPath))
-			return nil
-		}
+	if len(MilpaPath) != 0 && MilpaPath[0] != "" && util.IsTrueIsh(os.Getenv(_c.EnvVarMilpaPathParsed)) {
+		log.Debugf("%s already parsed upstream. %d items found", _c.EnvVarMilpaPath, len(MilpaPath))
+		return nil
+	}
 
+	// mounts are keyed by the paths found below
+	explicitMounts := Mounts
+	Mounts = map[string]string{}
+	if len(MilpaPath) != 0 && MilpaPath[0] != "" {
 		log.Debugf("%s is has %d items, parsing", _c.EnvVarMilpaPath, len(MilpaPath))
 		for idx, p := range MilpaPath {
 			if p == "" || !IsDir(p, true) {
@@ -64,10 +89,15 @@ func Run() error {
 				continue
 			}
 
+			mount := explicitMounts[p]
 			if !strings.HasSuffix(p, _c.RepoRoot) {
 				p = filepath.Join(p, _c.RepoRoot)
 				log.Debugf("Updated path to %s", p)
 			}
+			if mount != "" {
+				log.Debugf("Mounting %s under %s", p, mount)
+				Mounts[resolveLink(p)] = mount
+			}
 			pathMap.Add(0, p)
 		}
 	}
@@ -79,10 +109,12 @@ func Run() error {
 
 	pathMap.Add(1, rootRepo)
 	if pwd, err := os.Getwd(); err == nil {
-		pwdRepo := filepath.Join(pwd, _c.RepoRoot)
-		if IsDir(pwdRepo, false) {
-			log.Debugf("Adding pwd repo %s", pwdRepo)
-			pathMap.Add(2, pwdRepo)
+		for depth, dir := range searchDirs(pwd) {
+			dirRepo := filepath.Join(dir, _c.RepoRoot)
+			if IsDir(dirRepo, false) {
+				log.Debugf("Adding pwd repo %s", dirRepo)
+				pathMap.Add(2+depth, dirRepo)
+			}
 		}
 	}
 
@@ -90,27 +122,107 @@ func Run() error {
 	pathMap.AddLookup(_c.EnvVarLookupUserReposDisabled, lookupUserRepos)
 	pathMap.AddLookup(_c.EnvVarLookupGlobalReposDisabled, lookupGlobalRepos)
 
-	MilpaPath = pathMap.Ordered()
-	os.Setenv(_c.EnvVarMilpaPath, strings.Join(MilpaPath, ":"))
+	MilpaPath = supportedRepos(pathMap.Ordered(), rootRepo)
+	os.Setenv(_c.EnvVarMilpaPath, MilpaPathEnv(MilpaPath))
 
 	return nil
 }
 
+// supportedRepos drops the repos in paths that require another version of milpa, and mounts
+// the rest where their metadata asks to, unless MILPA_PATH already mounts them elsewhere.
+func supportedRepos(paths []string, rootRepo string) []string {
+	supported := []string{}
+	for _, path := range paths {
+		if path == rootRepo {
+			if mount, mounted := Mounts[path]; mounted {
+				log.Warnf("Ignoring mount %s for milpa's built-in repo", mount)
+				delete(Mounts, path)
+			}
+			supported = append(supported, path)
+			continue
+		}
+
+		meta, err := repo.ReadMetadata(path)
+		if err != nil {
+			log.Warnf("Ignoring metadata of repo %s: %s", path, err)
+		} else if err := meta.Supports(MilpaVersion); err != nil {
+			log.Warnf("Skipping repo %s: %s", path, err)
+			delete(Mounts, path)
+			continue
+		} else if _, mounted := Mounts[path]; !mounted && meta != nil && meta.Mount != "" {
+			log.Debugf("Mounting %s under %s", path, meta.Mount)
+			Mounts[path] = meta.Mount
+		}
+		supported = append(supported, path)
+	}
+	return supported
+}
+
+// searchDirs returns the folders to look for repos at: pwd and, when MILPA_LOOKUP_PARENTS is
+// enabled, each of its parents up to the lookup boundary, nearest first.
+func searchDirs(pwd string) []string {
+	if !util.IsTrueIsh(os.Getenv(_c.EnvVarLookupParents)) {
+		return []string{pwd}
+	}
+
+	boundary := lookupBoundary()
+	// compare physical paths, since git reports those
+	for _, path := range []*string{&pwd, &boundary} {
+		if real, err := filepath.EvalSymlinks(*path); err == nil {
+			*path = real
+		}
+	}
+	log.Debugf("looking for repos from %s up to %s", pwd, boundary)
+	return Ancestors(pwd, boundary)
+}
+
+// lookupBoundary returns the folder lookups of parent repos stop at, from MILPA_LOOKUP_BOUNDARY:
+// a path, `git` for the top-level of the current git repository, or `home` for the user's
+// home folder. By default, it's the git repository's top-level, or the home folder outside one
+// or with MILPA_DISABLE_GIT set.
+func lookupBoundary() string {
+	boundary := os.Getenv(_c.EnvVarLookupBoundary)
+	switch boundary {
+	case "", "git":
+		if boundary == "git" || !util.IsTrueIsh(os.Getenv(_c.EnvVarLookupGitDisabled)) {
+			if top, ok := gitTopLevel(); ok {
+				return top
+			}
+		}
+		log.Debugf("not in a git repository, looking for repos up to the home folder")
+		fallthrough
+	case "home":
+		home, _ := os.UserHomeDir()
+		return home
+	}
+	return filepath.Clean(boundary)
+}
+
+// Ancestors returns dir followed by its parents, up to and including boundary, or up to the
+// filesystem root with no boundary. It returns only dir when dir is not within boundary.
+func Ancestors(dir string, boundary string) []string {
+	dir = filepath.Clean(dir)
+	if boundary != "" {
+		boundary = filepath.Clean(boundary)
+		if rel, err := filepath.Rel(boundary, dir); err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			return []string{dir}
+		}
+	}
+
+	dirs := []string{}
+	for {
+		dirs = append(dirs, dir)
+		parent := filepath.Dir(dir)
+		if dir == boundary || parent == dir {
+			return dirs
+		}
+		dir = parent
+	}
+}
+
 func lookupGitRepo() []string {
 	log.Debugf("looking for a git repo")
-	ctx, cancel := context.WithTimeout(context.Background(), 1*time.Second)
-	defer cancel()
-
-	cmd := exec.CommandContext(ctx, "git", "rev-parse", "--show-toplevel")
-	var stdout bytes.Buffer
-	cmd.Stdout = &stdout
-	var stderr bytes.Buffer
-	cmd.Stderr = &stderr
-	cmd.Env = os.Environ()
-	err := cmd.Run()
-
-	if ctx.Err() == nil && err == nil {
-		repoRoot := strings.TrimSuffix(stdout.String(), "\n")
+	if repoRoot, ok := gitTopLevel(); ok {
 		gitRepo := filepath.Join(repoRoot, _c.RepoRoot)
 		if IsDir(gitRepo, false) {
 			log.Debugf("Found repo from git: %s", gitRepo)
@@ -122,48 +234,44 @@ func lookupGitRepo() []string {
 
 func lookupUserRepos() []string {
 	log.Debugf("looking for user repos")
-	found := []string{}
-
-	var userRepos string
-	if home := os.Getenv("XDG_DATA_HOME"); home == "" {
-		home = os.Getenv("HOME")
-		userRepos = filepath.Join(home, ".local", "share", "milpa", "repos")
-		if home == "" {
-			log.Debugf("Ignoring user repo lookup, neither XDG_DATA_HOME nor HOME were found in the environment")
-			return found
-		}
-	} else {
-		userRepos = filepath.Join(home, "milpa", "repos")
+	dirs, ok := repo.UserDirs()
+	if !ok {
+		log.Debugf("Ignoring user repo lookup, neither XDG_DATA_HOME nor HOME were found in the environment")
+		return []string{}
 	}
 
-	if files, err := os.ReadDir(userRepos); err == nil {
-		for _, file := range files {
-			userRepo := filepath.Join(userRepos, file.Name())
-			if IsDir(userRepo, true) {
-				log.Debugf("Found user repo: %s", userRepo)
-				found = append(found, userRepo)
-			}
-		}
-	} else {
-		log.Warnf("User repo directory not found: %s", userRepos)
+	found, err := repoDirs(dirs.Repos)
+	if err != nil {
+		log.Warnf("User repo directory not found: %s", dirs.Repos)
+	}
+	for _, userRepo := range found {
+		log.Debugf("Found user repo: %s", userRepo)
 	}
-
 	return found
 }
 
 func lookupGlobalRepos() []string {
 	log.Debugf("looking for global repos")
+	found, _ := repoDirs(repo.GlobalDirs(MilpaRoot).Repos)
+	for _, globalRepo := range found {
+		log.Debugf("Found global repo: %s", globalRepo)
+	}
+	return found
+}
+
+// repoDirs returns the folders, or symlinks to folders, within root.
+func repoDirs(root string) ([]string, error) {
 	found := []string{}
-	globalRepos := filepath.Join(MilpaRoot, "repos")
-	if files, err := os.ReadDir(globalRepos); err == nil {
-		for _, file := range files {
-			globalRepo := filepath.Join(globalRepos, file.Name())
-			if IsDir(globalRepo, true) {
-				log.Debugf("Found global repo: %s", globalRepo)
-				found = append(found, globalRepo)
-			}
-		}
+	files, err := os.ReadDir(root)
+	if err != nil {
+		return found, err
 	}
 
-	return found
+	for _, file := range files {
+		path := filepath.Join(root, file.Name())
+		if IsDir(path, true) {
+			found = append(found, path)
+		}
+	}
+	return found, nil
 }
@@ -6,9 +6,12 @@ import (
 	"bytes"
 	"fmt"
 	"os"
+	"os/exec"
 	"path"
+	"path/filepath"
 	"reflect"
 	"runtime"
+	"sort"
 	"strings"
 	"testing"
 
@@ -358,3 +361,252 @@ func TestBootstrapWithUserRepo(t *testing.T) {
 		}
 	})
 }
+
+func TestBootstrapSkipsUnsupportedRepos(t *testing.T) {
+	root := fromProjectRoot()
+	resetMilpaPath()
+	defer func() { MilpaVersion = "beta" }()
+
+	base := t.TempDir()
+	repos := map[string]string{
+		"supported":   "requires-milpa: \">=0.9, <2\"\n",
+		"unsupported": "requires-milpa: \">=2\"\n",
+		"invalid":     "requires-milpa: \"newest\"\n",
+		"plain":       "",
+	}
+	paths := []string{}
+	for name, contents := range repos {
+		dir := path.Join(base, name, _c.RepoRoot)
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			t.Fatal(err)
+		}
+		if contents != "" {
+			if err := os.WriteFile(path.Join(dir, _c.RepoMetadataName), []byte(contents), 0644); err != nil {
+				t.Fatal(err)
+			}
+		}
+		paths = append(paths, path.Join(base, name))
+	}
+
+	os.Setenv(_c.EnvVarMilpaRoot, root)
+	os.Setenv(_c.EnvVarLookupGitDisabled, "true")
+	os.Setenv(_c.EnvVarLookupGlobalReposDisabled, "true")
+	os.Setenv(_c.EnvVarLookupUserReposDisabled, "true")
+
+	for version, expected := range map[string][]string{
+		"1.0.0":       {"supported", "invalid", "plain"},
+		"2.1.0-rc.1":  {"unsupported", "invalid", "plain"},
+		"development": {"supported", "unsupported", "invalid", "plain"},
+	} {
+		t.Run(version, func(t *testing.T) {
+			// bootstrap replaces MILPA_PATH with the repos it found
+			os.Setenv(_c.EnvVarMilpaPath, strings.Join(paths, ":"))
+			MilpaVersion = version
+			MilpaPath = ParseMilpaPath()
+			if err := Run(); err != nil {
+				t.Fatalf("repo bootstrap raised unexpected error: %s", err)
+			}
+
+			want := []string{}
+			for _, name := range expected {
+				want = append(want, path.Join(base, name, _c.RepoRoot))
+			}
+			// milpa's own repo is always kept
+			want = append(want, root+"/.milpa")
+
+			got := append([]string{}, MilpaPath...)
+			sort.Strings(got)
+			sort.Strings(want)
+			if !reflect.DeepEqual(got, want) {
+				t.Fatalf("Unexpected milpa path: wanted %s, got %s", want, got)
+			}
+		})
+	}
+}
+
+func TestBootstrapMounts(t *testing.T) {
+	root := fromProjectRoot()
+	resetMilpaPath()
+
+	base := t.TempDir()
+	for name, contents := range map[string]string{
+		"infra":    "",
+		"tools":    "mount: tools\n",
+		"override": "mount: ignored\n",
+		"plain":    "",
+	} {
+		dir := path.Join(base, name, _c.RepoRoot)
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			t.Fatal(err)
+		}
+		if contents != "" {
+			if err := os.WriteFile(path.Join(dir, _c.RepoMetadataName), []byte(contents), 0644); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	// mounts apply to the repo a symlinked .milpa points to
+	if err := os.MkdirAll(path.Join(base, "linked"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(path.Join(base, "infra", _c.RepoRoot), path.Join(base, "linked", _c.RepoRoot)); err != nil {
+		t.Fatal(err)
+	}
+
+	os.Setenv(_c.EnvVarMilpaRoot, root)
+	os.Setenv(_c.EnvVarLookupGitDisabled, "true")
+	os.Setenv(_c.EnvVarLookupGlobalReposDisabled, "true")
+	os.Setenv(_c.EnvVarLookupUserReposDisabled, "true")
+	os.Setenv(_c.EnvVarMilpaPath, strings.Join([]string{
+		"ops=" + path.Join(base, "linked"),
+		path.Join(base, "tools"),
+		"mine=" + path.Join(base, "override"),
+		path.Join(base, "plain"),
+		"milpa=" + root,
+	}, ":"))
+	MilpaPath = ParseMilpaPath()
+	if err := Run(); err != nil {
+		t.Fatalf("repo bootstrap raised unexpected error: %s", err)
+	}
+
+	expected := map[string]string{
+		path.Join(base, "infra", _c.RepoRoot):    "ops",
+		path.Join(base, "tools", _c.RepoRoot):    "tools",
+		path.Join(base, "override", _c.RepoRoot): "mine",
+	}
+	if !reflect.DeepEqual(Mounts, expected) {
+		t.Fatalf("Unexpected mounts: wanted %v, got %v", expected, Mounts)
+	}
+
+	exported := os.Getenv(_c.EnvVarMilpaPath)
+	if !strings.Contains(exported, "ops="+path.Join(base, "infra", _c.RepoRoot)) || strings.Contains(exported, "milpa=") {
+		t.Fatalf("Unexpected MILPA_PATH exported: %s", exported)
+	}
+
+	// commands run by milpa read mounts from the already parsed MILPA_PATH
+	os.Setenv(_c.EnvVarMilpaPathParsed, "true")
+	defer os.Unsetenv(_c.EnvVarMilpaPathParsed)
+	MilpaPath = ParseMilpaPath()
+	if err := Run(); err != nil {
+		t.Fatalf("repo bootstrap raised unexpected error: %s", err)
+	}
+
+	if !reflect.DeepEqual(Mounts, expected) {
+		t.Fatalf("Unexpected mounts after parsing: wanted %v, got %v", expected, Mounts)
+	}
+	for _, p := range MilpaPath {
+		if strings.Contains(p, "=") {
+			t.Fatalf("Mount left in MILPA_PATH entry %s", p)
+		}
+	}
+}
+
+func TestAncestors(t *testing.T) {
+	for _, test := range []struct {
+		dir      string
+		boundary string
+		expected []string
+	}{
+		{"/a/b/c", "/a", []string{"/a/b/c", "/a/b", "/a"}},
+		{"/a/b/c/", "/a/b/", []string{"/a/b/c", "/a/b"}},
+		{"/a/b", "/a/b", []string{"/a/b"}},
+		{"/a/b", "/x", []string{"/a/b"}},
+		{"/a/b", "/a/b/c", []string{"/a/b"}},
+		{"/a/bc", "/a/b", []string{"/a/bc"}},
+		{"/a/b", "", []string{"/a/b", "/a", "/"}},
+	} {
+		if got := Ancestors(test.dir, test.boundary); !reflect.DeepEqual(got, test.expected) {
+			t.Errorf("ancestors of %s up to %q: wanted %v, got %v", test.dir, test.boundary, test.expected, got)
+		}
+	}
+}
+
+func TestBootstrapLooksUpParents(t *testing.T) {
+	root := fromProjectRoot()
+	resetMilpaPath()
+
+	base, err := filepath.EvalSymlinks(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	monorepo := path.Join(base, "monorepo")
+	for _, dir := range []string{"", "monorepo", "monorepo/services/payments", "monorepo/services/payments/api/src"} {
+		if err := os.MkdirAll(path.Join(base, dir, _c.RepoRoot), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// folders without a .milpa are skipped
+	if err := os.Remove(path.Join(base, "monorepo/services/payments/api/src", _c.RepoRoot)); err != nil {
+		t.Fatal(err)
+	}
+
+	os.Setenv(_c.EnvVarMilpaRoot, root)
+	os.Setenv(_c.EnvVarLookupGlobalReposDisabled, "true")
+	os.Setenv(_c.EnvVarLookupUserReposDisabled, "true")
+	t.Setenv("HOME", monorepo)
+	t.Chdir(path.Join(monorepo, "services/payments/api/src"))
+
+	payments := path.Join(monorepo, "services/payments", _c.RepoRoot)
+	for name, test := range map[string]struct {
+		env      map[string]string
+		git      bool
+		expected []string
+	}{
+		"disabled": {
+			expected: []string{},
+		},
+		"up to the home folder": {
+			env:      map[string]string{_c.EnvVarLookupParents: "true"},
+			expected: []string{payments, path.Join(monorepo, _c.RepoRoot)},
+		},
+		"up to a path": {
+			env:      map[string]string{_c.EnvVarLookupParents: "true", _c.EnvVarLookupBoundary: path.Join(monorepo, "services")},
+			expected: []string{payments},
+		},
+		"outside the boundary": {
+			env:      map[string]string{_c.EnvVarLookupParents: "true", _c.EnvVarLookupBoundary: path.Join(base, "elsewhere")},
+			expected: []string{},
+		},
+		"up to the git root": {
+			env:      map[string]string{_c.EnvVarLookupParents: "true", _c.EnvVarLookupBoundary: "git"},
+			git:      true,
+			expected: []string{payments, path.Join(monorepo, "services", _c.RepoRoot)},
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			os.Setenv(_c.EnvVarLookupGitDisabled, "true")
+			defer os.Unsetenv(_c.EnvVarLookupParents)
+			defer os.Unsetenv(_c.EnvVarLookupBoundary)
+			for key, value := range test.env {
+				os.Setenv(key, value)
+			}
+
+			if test.git {
+				if _, err := exec.LookPath("git"); err != nil {
+					t.Skip("git is not available")
+				}
+				services := path.Join(monorepo, "services")
+				if err := os.MkdirAll(path.Join(services, _c.RepoRoot), 0755); err != nil {
+					t.Fatal(err)
+				}
+				if out, err := exec.Command("git", "init", "-q", services).CombinedOutput(); err != nil {
+					t.Fatalf("could not init git repo: %s", out)
+				}
+				defer os.RemoveAll(path.Join(services, ".git"))
+				defer os.RemoveAll(path.Join(services, _c.RepoRoot))
+			}
+
+			os.Setenv(_c.EnvVarMilpaPath, "")
+			MilpaPath = ParseMilpaPath()
+			if err := Run(); err != nil {
+				t.Fatalf("repo bootstrap raised unexpected error: %s", err)
+			}
+
+			// milpa's own repo comes first
+			expected := append([]string{root + "/.milpa"}, test.expected...)
+			if !reflect.DeepEqual(MilpaPath, expected) {
+				t.Fatalf("Unexpected milpa path: wanted %s, got %s", expected, MilpaPath)
+			}
+		})
+	}
+}
@@ -0,0 +1,238 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright © 2021 Roberto Hidalgo <milpa@un.rob.mx>
+package bootstrap
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/unrob/milpa/internal/util"
+)
+
+// gitEnvironment changes how git finds repositories, only git itself can tell where these are.
+var gitEnvironment = []string{
+	"GIT_DIR",
+	"GIT_WORK_TREE",
+	"GIT_COMMON_DIR",
+	"GIT_CEILING_DIRECTORIES",
+	"GIT_DISCOVERY_ACROSS_FILESYSTEM",
+}
+
+// gitTopLevel returns the top-level folder of the git repository at the working directory,
+// only running git when the filesystem is not enough to tell.
+func gitTopLevel() (string, bool) {
+	if pwd, err := os.Getwd(); err == nil {
+		top, err := DiscoverGitTopLevel(pwd)
+		if err == nil {
+			return top, top != ""
+		}
+		log.Debugf("could not discover git repo, asking git: %s", err)
+	}
+
+	return askGitTopLevel()
+}
+
+// askGitTopLevel runs git to find the top-level folder of the git repository at the working
+// directory.
+func askGitTopLevel() (string, bool) {
+	ctx, cancel := context.WithTimeout(context.Background(), 1*time.Second)
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, "git", "rev-parse", "--show-toplevel")
+	var stdout bytes.Buffer
+	cmd.Stdout = &stdout
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	cmd.Env = os.Environ()
+	err := cmd.Run()
+
+	if ctx.Err() != nil || err != nil {
+		return "", false
+	}
+	return strings.TrimSuffix(stdout.String(), "\n"), true
+}
+
+// DiscoverGitTopLevel walks up from dir looking for the top-level folder of a git working
+// tree, like `git rev-parse --show-toplevel` does, including linked worktrees and submodules.
+// It returns an empty string outside of a working tree, such as within bare repositories, and
+// an error when only git can tell, like for repositories owned by other users, that git
+// refuses unless they're listed in safe.directory.
+func DiscoverGitTopLevel(dir string) (string, error) {
+	for _, name := range gitEnvironment {
+		if os.Getenv(name) != "" {
+			return "", fmt.Errorf("%s is set", name)
+		}
+	}
+
+	// git reports physical paths
+	if real, err := filepath.EvalSymlinks(dir); err == nil {
+		dir = real
+	}
+
+	for {
+		dotGit := filepath.Join(dir, ".git")
+		if fi, err := os.Stat(dotGit); err == nil {
+			switch {
+			case fi.IsDir() && isGitDir(dotGit):
+				if err := ownedByCurrentUser(dir, dotGit); err != nil {
+					return "", err
+				}
+				return workTree(dotGit, dir)
+			case fi.Mode().IsRegular():
+				// worktrees and submodules point to their git folder with a file
+				gitDir, err := readGitFile(dotGit)
+				if err != nil {
+					return "", err
+				}
+				if err := ownedByCurrentUser(dir, dotGit, gitDir); err != nil {
+					return "", err
+				}
+				return workTree(gitDir, dir)
+			}
+		}
+
+		if isGitDir(dir) {
+			// bare repositories, and git folders themselves, have no working tree
+			return "", nil
+		}
+
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return "", nil
+		}
+		dir = parent
+	}
+}
+
+// ownedByCurrentUser errors unless every path is owned by the current user.
+func ownedByCurrentUser(paths ...string) error {
+	uid := os.Getuid()
+	for _, path := range paths {
+		fi, err := os.Stat(path)
+		if err != nil {
+			return err
+		}
+
+		if stat, ok := fi.Sys().(*syscall.Stat_t); ok && int(stat.Uid) != uid {
+			return fmt.Errorf("%s is owned by another user", path)
+		}
+	}
+	return nil
+}
+
+// isGitDir tells if path looks like a git folder the way git does: it has a HEAD file,
+// and objects and refs folders, directly or at the commondir of linked worktrees.
+func isGitDir(path string) bool {
+	if fi, err := os.Stat(filepath.Join(path, "HEAD")); err != nil || !fi.Mode().IsRegular() {
+		return false
+	}
+
+	common := path
+	if contents, err := os.ReadFile(filepath.Join(path, "commondir")); err == nil { // nolint: gosec
+		common = strings.TrimSpace(string(contents))
+		if !filepath.IsAbs(common) {
+			common = filepath.Join(path, common)
+		}
+	}
+
+	return util.IsDir(filepath.Join(common, "objects")) && util.IsDir(filepath.Join(common, "refs"))
+}
+
+// readGitFile returns the git folder a `.git` file at path points to.
+func readGitFile(path string) (string, error) {
+	contents, err := os.ReadFile(path) // nolint: gosec
+	if err != nil {
+		return "", err
+	}
+
+	gitDir, found := strings.CutPrefix(strings.TrimSpace(string(contents)), "gitdir: ")
+	if !found {
+		return "", fmt.Errorf("%s is not a gitfile", path)
+	}
+
+	if !filepath.IsAbs(gitDir) {
+		gitDir = filepath.Join(filepath.Dir(path), gitDir)
+	}
+
+	if !isGitDir(gitDir) {
+		return "", fmt.Errorf("%s points to %s, which is not a git folder", path, gitDir)
+	}
+	return gitDir, nil
+}
+
+// workTree returns the top-level folder of the working tree of gitDir, found at dir unless
+// its config sets core.worktree, as submodules do. Bare repositories have none.
+func workTree(gitDir string, dir string) (string, error) {
+	core, err := coreConfig(filepath.Join(gitDir, "config"))
+	if err != nil {
+		return "", err
+	}
+
+	if util.IsTrueIsh(strings.ToLower(core["bare"])) {
+		return "", nil
+	}
+
+	if tree := core["worktree"]; tree != "" {
+		if !filepath.IsAbs(tree) {
+			tree = filepath.Join(gitDir, tree)
+		}
+		if real, err := filepath.EvalSymlinks(tree); err == nil {
+			tree = real
+		}
+		return tree, nil
+	}
+
+	return dir, nil
+}
+
+// coreConfig reads the core section of the git config at path, returning no settings if
+// there's no such file. Configs including others can only be read by git.
+func coreConfig(path string) (map[string]string, error) {
+	core := map[string]string{}
+	contents, err := os.ReadFile(path) // nolint: gosec
+	if os.IsNotExist(err) {
+		return core, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	section := ""
+	scanner := bufio.NewScanner(bytes.NewReader(contents))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || line[0] == '#' || line[0] == ';' {
+			continue
+		}
+
+		if line[0] == '[' {
+			section = ""
+			if fields := strings.Fields(strings.Trim(line, "[]")); len(fields) > 0 {
+				section = strings.ToLower(fields[0])
+			}
+			if section == "include" || section == "includeif" {
+				return nil, fmt.Errorf("%s includes other configs", path)
+			}
+			continue
+		}
+
+		if section != "core" {
+			continue
+		}
+
+		key, value, found := strings.Cut(line, "=")
+		if !found {
+			// keys with no value are booleans set to true
+			value = "true"
+		}
+		core[strings.ToLower(strings.TrimSpace(key))] = strings.Trim(strings.TrimSpace(value), `"`)
+	}
+	return core, scanner.Err()
+}
@@ -0,0 +1,158 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright © 2021 Roberto Hidalgo <milpa@un.rob.mx>
+package bootstrap_test
+
+import (
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+
+	. "github.com/unrob/milpa/internal/bootstrap"
+)
+
+// gitTestdata copies testdata/git to a temporary folder, renaming every dot-git to .git, since
+// git won't keep track of those.
+func gitTestdata(t *testing.T) string {
+	t.Helper()
+	_, filename, _, _ := runtime.Caller(0)
+	src := filepath.Join(filepath.Dir(filename), "testdata", "git")
+	dst, err := filepath.EvalSymlinks(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = filepath.WalkDir(src, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		rel, _ := filepath.Rel(src, path)
+		target := filepath.Join(dst, strings.ReplaceAll(rel, "dot-git", ".git"))
+		if d.IsDir() {
+			return os.MkdirAll(target, 0755)
+		}
+
+		if d.Name() == ".gitkeep" {
+			return nil
+		}
+
+		contents, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		return os.WriteFile(target, contents, 0644)
+	})
+	if err != nil {
+		t.Fatalf("could not copy testdata: %s", err)
+	}
+	return dst
+}
+
+func TestDiscoverGitTopLevel(t *testing.T) {
+	root := gitTestdata(t)
+	_, gitErr := exec.LookPath("git")
+
+	for dir, expected := range map[string]string{
+		"repo":                "repo",
+		"repo/src/deep":       "repo",
+		"repo/.git/objects":   "",
+		"repo/lib":            "repo/lib",
+		"repo/lib/src":        "repo/lib",
+		"worktree/src":        "worktree",
+		"bare.git":            "",
+		"bare.git/refs/heads": "",
+	} {
+		t.Run(dir, func(t *testing.T) {
+			if expected != "" {
+				expected = filepath.Join(root, expected)
+			}
+
+			top, err := DiscoverGitTopLevel(filepath.Join(root, dir))
+			if err != nil {
+				t.Fatalf("could not discover git repo: %s", err)
+			}
+
+			if top != expected {
+				t.Fatalf("unexpected top-level, wanted %q, got %q", expected, top)
+			}
+
+			if gitErr != nil {
+				return
+			}
+
+			// make sure git agrees
+			cmd := exec.Command("git", "rev-parse", "--show-toplevel")
+			cmd.Dir = filepath.Join(root, dir)
+			cmd.Env = append(os.Environ(), "GIT_CEILING_DIRECTORIES="+root)
+			out, _ := cmd.Output()
+			if fromGit := strings.TrimSpace(string(out)); fromGit != expected {
+				t.Fatalf("git disagrees, wanted %q, got %q", expected, fromGit)
+			}
+		})
+	}
+
+	t.Run("plain/src", func(t *testing.T) {
+		// plain is not a working tree, so the walk continues above root, which may be in one
+		outside, err := DiscoverGitTopLevel(filepath.Dir(root))
+		if err != nil {
+			t.Skipf("could not discover git repo above %s: %s", root, err)
+		}
+
+		top, err := DiscoverGitTopLevel(filepath.Join(root, "plain/src"))
+		if err != nil || top != outside {
+			t.Fatalf("unexpected top-level, wanted %q, got %q, %v", outside, top, err)
+		}
+	})
+
+	t.Run("worktree added by git", func(t *testing.T) {
+		if gitErr != nil {
+			t.Skip("git is not available")
+		}
+
+		repo := filepath.Join(root, "real")
+		for _, args := range [][]string{
+			{"init", "-q", repo},
+			{"-C", repo, "-c", "user.name=milpa", "-c", "user.email=milpa@example.com", "commit", "-q", "--allow-empty", "-m", "first"},
+			{"-C", repo, "worktree", "add", "-q", filepath.Join(root, "real-feature")},
+		} {
+			if out, err := exec.Command("git", args...).CombinedOutput(); err != nil {
+				t.Fatalf("could not run git %s: %s", args, out)
+			}
+		}
+
+		top, err := DiscoverGitTopLevel(filepath.Join(root, "real-feature"))
+		if err != nil || top != filepath.Join(root, "real-feature") {
+			t.Fatalf("unexpected top-level: %q, %v", top, err)
+		}
+	})
+
+	t.Run("broken gitfile", func(t *testing.T) {
+		if _, err := DiscoverGitTopLevel(filepath.Join(root, "broken/src")); err == nil {
+			t.Fatal("discovered a repo through a broken gitfile")
+		}
+	})
+
+	t.Run("git environment", func(t *testing.T) {
+		t.Setenv("GIT_DIR", filepath.Join(root, "repo/.git"))
+		if _, err := DiscoverGitTopLevel(filepath.Join(root, "plain")); err == nil {
+			t.Fatal("discovered a repo ignoring GIT_DIR")
+		}
+	})
+
+	t.Run("owned by another user", func(t *testing.T) {
+		if os.Getuid() != 0 {
+			t.Skip("changing owners requires root")
+		}
+
+		if err := os.Chown(filepath.Join(root, "repo/.git"), 65534, 65534); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := DiscoverGitTopLevel(filepath.Join(root, "repo/src")); err == nil {
+			t.Fatal("discovered a repo git would refuse as unsafe")
+		}
+	})
+}
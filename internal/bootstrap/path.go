@@ -12,10 +12,8 @@ import (
 )
 
 func IsDir(path string, warn bool) bool {
-	if fi, err := os.Stat(path); err == nil { // nolint: gosec
-		if fi.Mode().IsDir() {
-			return true
-		}
+	if util.IsDir(path) {
+		return true
 	}
 
 	if warn {
@@ -25,6 +23,18 @@ func IsDir(path string, warn bool) bool {
 	return false
 }
 
+// resolveLink returns the path a symlink at path points to, or path if it's not a symlink.
+func resolveLink(path string) string {
+	if pathR, err := os.Readlink(path); err == nil {
+		// Output of os.Readlink is OS-dependent...
+		if !filepath.IsAbs(pathR) {
+			pathR = filepath.Join(filepath.Dir(path), pathR)
+		}
+		return pathR
+	}
+	return path
+}
+
 type pathLayer map[string]bool
 
 func (pl pathLayer) add(path string) {
@@ -66,11 +76,19 @@ func (pb *PathBuilder) resolve() {
 	if pb.resolved {
 		return
 	}
+	// lookups are layered after every path added directly
+	base := 10
+	for layerID := range pb.layers {
+		if layerID >= base {
+			base = layerID + 1
+		}
+	}
+
 	var wg sync.WaitGroup
 	for idx, lookup := range pb.lookups {
 		wg.Add(1)
 		lookup := lookup
-		layerID := idx + 10
+		layerID := idx + base
 		go func() {
 			defer wg.Done()
 			for _, f := range lookup() {
@@ -101,13 +119,7 @@ func (pb *PathBuilder) Add(layerID int, path string) {
 	}
 
 	// Resolve symlinks before checking if unique
-	if pathR, err := os.Readlink(path); err == nil {
-		// Output of os.Readlink is OS-dependent...
-		if !filepath.IsAbs(pathR) {
-			pathR = filepath.Join(filepath.Dir(path), pathR)
-		}
-		path = pathR
-	}
+	path = resolveLink(path)
 
 	if _, exists := pb.unique[path]; exists {
 		return
@@ -107,3 +107,20 @@ func TestResolve(t *testing.T) {
 		t.Fatalf("unexpected result on second resolve, wanted: %v. got: %s", res, expected)
 	}
 }
+
+func TestResolveAfterDeepLayers(t *testing.T) {
+	tdp := testdataPathBuilder()
+
+	pb := &PathBuilder{}
+	pb.AddLookup("lookup", func() []string {
+		return []string{tdp("layer0/uno")}
+	})
+	// i.e. the parents of a deeply nested working directory
+	pb.Add(2, tdp("layer1/one"))
+	pb.Add(12, tdp("layer1/two"))
+
+	expected := []string{tdp("layer1/one"), tdp("layer1/two"), tdp("layer0/uno")}
+	if res := pb.Ordered(); !reflect.DeepEqual(res, expected) {
+		t.Fatalf("unexpected result, wanted: %v. got: %s", expected, res)
+	}
+}
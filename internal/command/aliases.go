@@ -0,0 +1,161 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright © 2021 Roberto Hidalgo <milpa@un.rob.mx>
+package command
+
+import (
+	"fmt"
+	"strings"
+
+	"git.rob.mx/nidito/chinampa/pkg/command"
+	"git.rob.mx/nidito/chinampa/pkg/logger"
+	"github.com/spf13/cobra"
+)
+
+// Alias is an additional name a command can be called by.
+type Alias struct {
+	// Name is a list of words naming this alias
+	Name []string `json:"name" yaml:"name"`
+	// Deprecated aliases are hidden from help and completion, and log a warning when used
+	Deprecated bool `json:"deprecated" yaml:"deprecated"`
+}
+
+func (a Alias) String() string {
+	return strings.Join(a.Name, " ")
+}
+
+// parseAliases reads `aliases` and `deprecated-alias` from a spec, for a command named name.
+// Aliases with a single word are siblings of the command, otherwise they are full command paths,
+// under mount for commands of mounted repos.
+func parseAliases(spec *milpaSpec, name []string, mount string) ([]Alias, error) {
+	aliases := []Alias{}
+	add := func(value string, deprecated bool) error {
+		words := strings.Fields(value)
+		if len(words) > 0 && words[0] == "milpa" {
+			words = words[1:]
+		}
+
+		if len(words) == 0 {
+			return fmt.Errorf("empty alias for %s", strings.Join(name, " "))
+		}
+
+		if len(words) == 1 {
+			words = append(append([]string{}, name[:len(name)-1]...), words[0])
+		} else if mount != "" {
+			words = append([]string{mount}, words...)
+		}
+
+		alias := Alias{Name: words, Deprecated: deprecated}
+		if alias.String() == strings.Join(name, " ") {
+			return fmt.Errorf("alias %s is the name of the command itself", alias)
+		}
+
+		for _, existing := range aliases {
+			if existing.String() == alias.String() {
+				return fmt.Errorf("alias %s is declared more than once", alias)
+			}
+		}
+
+		aliases = append(aliases, alias)
+		return nil
+	}
+
+	for _, value := range spec.Aliases {
+		if err := add(value, false); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, value := range spec.DeprecatedAliases {
+		if err := add(value, true); err != nil {
+			return nil, err
+		}
+	}
+
+	return aliases, nil
+}
+
+// NewAlias returns a copy of cmd that runs it when called by alias, without parsing its spec again.
+func NewAlias(cmd *command.Command, alias Alias) (*command.Command, error) {
+	meta, ok := MetaFor(cmd)
+	if !ok {
+		return nil, fmt.Errorf("unknown meta: %s", cmd.Path)
+	}
+
+	// aliases get their own arguments and options, as these hold the values they're called with,
+	// and their own cobra command
+	aliased := *cmd
+	aliased.Arguments = copyArguments(cmd.Arguments)
+	aliased.Options = copyOptions(cmd.Options)
+	aliased.Cobra = nil
+
+	canonical := "milpa " + strings.Join(cmd.Path, " ")
+	meta.AliasOf = cmd.Path
+	meta.Aliases = nil
+	aliased.Meta = meta
+	aliased.Path = alias.Name
+	aliased.Hidden = cmd.Hidden || alias.Deprecated
+	aliased.Description = fmt.Sprintf("Alias of ﹅%s﹅.\n\n%s", canonical, cmd.Description)
+
+	if alias.Deprecated {
+		action := aliased.Action
+		aliased.Action = func(c *command.Command) error {
+			logger.Main.Warnf("milpa %s is deprecated, use %s instead", alias, canonical)
+			return action(c)
+		}
+	}
+
+	return aliased.SetBindings(), nil
+}
+
+// AliasConflicts returns the aliases of cmds that collide with commands or earlier aliases, keyed by the name of the command declaring them.
+// Built-in commands count as taken, both those in cmds and, once chinampa builds it, those in the cobra tree.
+func AliasConflicts(cmds []*command.Command) map[string][]Alias {
+	taken := map[string]string{}
+	declared := map[string]bool{}
+	for _, cmd := range cmds {
+		name := strings.Join(cmd.Path, " ")
+		declared[name] = true
+		if meta, ok := MetaFor(cmd); !ok || len(meta.AliasOf) == 0 {
+			taken[name] = ""
+		}
+	}
+
+	if command.Root.Cobra != nil {
+		// cobra's own help and completion commands are only found here
+		for _, name := range cobraPaths(command.Root.Cobra.Root(), nil) {
+			if !declared[name] {
+				taken[name] = ""
+			}
+		}
+	}
+
+	conflicts := map[string][]Alias{}
+	for _, cmd := range cmds {
+		meta, ok := MetaFor(cmd)
+		if !ok {
+			continue
+		}
+
+		name := strings.Join(cmd.Path, " ")
+		for _, alias := range meta.Aliases {
+			if owner, exists := taken[alias.String()]; exists && owner != name {
+				conflicts[name] = append(conflicts[name], alias)
+				continue
+			}
+			taken[alias.String()] = name
+		}
+	}
+
+	return conflicts
+}
+
+// cobraPaths returns the names of every command under cc, prefixed by parent.
+func cobraPaths(cc *cobra.Command, parent []string) []string {
+	names := []string{}
+	for _, child := range cc.Commands() {
+		path := append(append([]string{}, parent...), child.Name())
+		names = append(names, strings.Join(path, " "))
+		names = append(names, cobraPaths(child, path)...)
+	}
+	return names
+}
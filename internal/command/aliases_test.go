@@ -0,0 +1,104 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright © 2021 Roberto Hidalgo <milpa@un.rob.mx>
+package command_test
+
+import (
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+
+	"git.rob.mx/nidito/chinampa/pkg/command"
+	"github.com/spf13/cobra"
+	. "github.com/unrob/milpa/internal/command"
+)
+
+func TestAliases(t *testing.T) {
+	path, repo := writeSpec(t, `summary: aliased
+description: aliased
+aliases: [stash, milpa db cached]
+deprecated-alias: [old cached]
+arguments:
+  - name: thing
+    description: a thing
+`)
+
+	cmd, err := New(path, repo, false)
+	if err != nil {
+		t.Fatalf("could not parse spec: %s", err)
+	}
+
+	meta, _ := MetaFor(cmd)
+	expected := []Alias{
+		{Name: []string{"stash"}},
+		{Name: []string{"db", "cached"}},
+		{Name: []string{"old", "cached"}, Deprecated: true},
+	}
+	if !reflect.DeepEqual(meta.Aliases, expected) {
+		t.Fatalf("unexpected aliases, wanted %v, got %v", expected, meta.Aliases)
+	}
+
+	// aliases are copies of the parsed command, their specs are not read again
+	if err := os.Remove(strings.TrimSuffix(path, ".sh") + ".yaml"); err != nil {
+		t.Fatal(err)
+	}
+
+	aliased, err := NewAlias(cmd, expected[2])
+	if err != nil {
+		t.Fatalf("could not create alias: %s", err)
+	}
+
+	if len(aliased.Arguments) != len(cmd.Arguments) || (len(cmd.Arguments) > 0 && aliased.Arguments[0] == cmd.Arguments[0]) {
+		t.Fatalf("alias does not have its own arguments: %v", aliased.Arguments)
+	}
+
+	aliasMeta, _ := MetaFor(aliased)
+	if !reflect.DeepEqual(aliased.Path, []string{"old", "cached"}) || !reflect.DeepEqual(aliasMeta.AliasOf, []string{"cached"}) {
+		t.Fatalf("unexpected alias %v of %v", aliased.Path, aliasMeta.AliasOf)
+	}
+
+	if !aliased.Hidden {
+		t.Fatal("deprecated alias is not hidden")
+	}
+
+	if EnvironmentMap(aliased)["MILPA_COMMAND_NAME"] != "cached" {
+		t.Fatalf("alias does not run as its command: %v", EnvironmentMap(aliased))
+	}
+
+	for _, spec := range []string{"aliases: [cached]", "aliases: ['']", "aliases: [stash]\ndeprecated-alias: [stash]"} {
+		path, repo := writeSpec(t, "summary: bad\ndescription: bad\n"+spec)
+		if _, err := New(path, repo, false); err == nil {
+			t.Errorf("parsing %q did not fail", spec)
+		}
+	}
+}
+
+func TestAliasConflicts(t *testing.T) {
+	commandWithAliases := func(name string, aliases ...string) *command.Command {
+		meta := Meta{Name: strings.Split(name, " ")}
+		for _, alias := range aliases {
+			meta.Aliases = append(meta.Aliases, Alias{Name: strings.Split(alias, " ")})
+		}
+		return &command.Command{Path: meta.Name, Meta: meta}
+	}
+
+	root := command.Root.Cobra
+	defer func() { command.Root.Cobra = root }()
+	command.Root.Cobra = &cobra.Command{Use: "milpa"}
+	command.Root.Cobra.AddCommand(&cobra.Command{Use: "completion"})
+
+	conflicts := AliasConflicts([]*command.Command{
+		{Path: []string{"help", "docs"}},
+		commandWithAliases("database migrate", "db migrate", "database seed"),
+		commandWithAliases("database seed"),
+		commandWithAliases("deploy", "db migrate", "ship", "help docs", "completion"),
+	})
+
+	expected := map[string][]Alias{
+		"database migrate": {{Name: []string{"database", "seed"}}},
+		"deploy":           {{Name: []string{"db", "migrate"}}, {Name: []string{"help", "docs"}}, {Name: []string{"completion"}}},
+	}
+	if !reflect.DeepEqual(conflicts, expected) {
+		t.Fatalf("unexpected conflicts, wanted %v, got %v", expected, conflicts)
+	}
+}
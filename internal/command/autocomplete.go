@@ -14,13 +14,169 @@ import (
 	"github.com/spf13/cobra"
 )
 
+type word struct {
+	text   string
+	quoted bool
+}
+
+// splitWords splits line following shell quoting rules, keeping go-template actions intact.
+func splitWords(line string) ([]word, error) {
+	words := []word{}
+	var current strings.Builder
+	inWord := false
+	quoted := false
+	var quote rune
+
+	flush := func() {
+		if inWord {
+			words = append(words, word{text: current.String(), quoted: quoted})
+		}
+		current.Reset()
+		inWord = false
+		quoted = false
+	}
+
+	runes := []rune(line)
+	for idx := 0; idx < len(runes); idx++ {
+		char := runes[idx]
+
+		if char == '{' && idx+1 < len(runes) && runes[idx+1] == '{' {
+			end := strings.Index(string(runes[idx:]), "}}")
+			if end == -1 {
+				return nil, fmt.Errorf("unterminated template action in <%s>", line)
+			}
+			action := []rune(string(runes[idx:])[:end+2])
+			current.WriteString(string(action))
+			inWord = true
+			idx += len(action) - 1
+			continue
+		}
+
+		switch {
+		case quote == '\'':
+			if char == '\'' {
+				quote = 0
+			} else {
+				current.WriteRune(char)
+			}
+		case quote == '"':
+			switch {
+			case char == '"':
+				quote = 0
+			case char == '\\' && idx+1 < len(runes) && strings.ContainsRune(`"\$`+"`", runes[idx+1]):
+				idx++
+				current.WriteRune(runes[idx])
+			default:
+				current.WriteRune(char)
+			}
+		case char == '\'' || char == '"':
+			quote = char
+			inWord = true
+			quoted = true
+		case char == '\\':
+			if idx+1 < len(runes) {
+				idx++
+				current.WriteRune(runes[idx])
+				inWord = true
+			}
+		case char == ' ' || char == '\t' || char == '\n':
+			flush()
+		default:
+			current.WriteRune(char)
+			inWord = true
+		}
+	}
+
+	if quote != 0 {
+		return nil, fmt.Errorf("unterminated quote in <%s>", line)
+	}
+	flush()
+
+	return words, nil
+}
+
+// ShellWords splits line into words following shell quoting rules, keeping go-template actions intact.
+func ShellWords(line string) ([]string, error) {
+	words, err := splitWords(line)
+	if err != nil {
+		return nil, err
+	}
+
+	res := make([]string, len(words))
+	for idx, w := range words {
+		res[idx] = w.text
+	}
+	return res, nil
+}
+
+// QuoteWord quotes value so ShellWords returns it as a single word.
+func QuoteWord(value string) string {
+	if value != "" && !strings.ContainsAny(value, " \t\n'\"\\") {
+		return value
+	}
+
+	var quoted strings.Builder
+	quoted.WriteRune('\'')
+	rest := value
+	for rest != "" {
+		start := strings.Index(rest, "{{")
+		if start == -1 {
+			start = len(rest)
+		}
+		quoted.WriteString(strings.ReplaceAll(rest[:start], "'", `'\''`))
+		rest = rest[start:]
+		if rest == "" {
+			break
+		}
+
+		end := strings.Index(rest, "}}")
+		if end == -1 {
+			quoted.WriteString(rest)
+			break
+		}
+		quoted.WriteString(rest[:end+2])
+		rest = rest[end+2:]
+	}
+	quoted.WriteRune('\'')
+	return quoted.String()
+}
+
+// milpaArgs turns a `milpa` value source into arguments, resolving templates for every word so
+// interpolated values with spaces or quotes stay intact.
+func milpaArgs(cmd *command.Command, config string, currentValue string) ([]string, error) {
+	words, err := splitWords(config)
+	if err != nil {
+		return nil, err
+	}
+
+	args := []string{}
+	for _, w := range words {
+		resolved, err := cmd.ResolveTemplate(w.text, currentValue)
+		if err != nil {
+			// templates spanning multiple words, like {{ if }} blocks, can only be resolved as a whole
+			cmdLine, err := cmd.ResolveTemplate(config, currentValue)
+			if err != nil {
+				return nil, err
+			}
+			return ShellWords(cmdLine)
+		}
+
+		if resolved == "" && !w.quoted {
+			continue
+		}
+		args = append(args, resolved)
+	}
+
+	return args, nil
+}
+
 func MilpaComplete(cmd *command.Command, currentValue string, config string) (values []string, flag cobra.ShellCompDirective, err error) {
-	cmdLine, err := cmd.ResolveTemplate(config, currentValue)
+	cmdArgs, err := milpaArgs(cmd, config, currentValue)
 	if err != nil {
 		return nil, cobra.ShellCompDirectiveError, err
 	}
 
-	args := append([]string{"milpa"}, strings.Split(cmdLine, " ")...)
+	args := append([]string{"milpa"}, cmdArgs...)
 	envMap := EnvironmentMap(cmd)
 	env := os.Environ()
 	for k, v := range envMap {
@@ -0,0 +1,144 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright © 2021 Roberto Hidalgo <milpa@un.rob.mx>
+package command_test
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/spf13/cobra"
+	. "github.com/unrob/milpa/internal/command"
+)
+
+func TestShellWords(t *testing.T) {
+	cases := []struct {
+		line     string
+		expected []string
+	}{
+		{"itself repo list", []string{"itself", "repo", "list"}},
+		{"  spaced\t\tout  ", []string{"spaced", "out"}},
+		{`say 'hello world'`, []string{"say", "hello world"}},
+		{`say "hello world"`, []string{"say", "hello world"}},
+		{`say hello\ world`, []string{"say", "hello world"}},
+		{`say "a \"quoted\" word"`, []string{"say", `a "quoted" word`}},
+		{`say 'it'\''s'`, []string{"say", "it's"}},
+		{`say 'no \escapes'`, []string{"say", `no \escapes`}},
+		{`say "" ''`, []string{"say", "", ""}},
+		{`--name="my project"`, []string{"--name=my project"}},
+		{`list {{ Opt "scheme" }}`, []string{"list", `{{ Opt "scheme" }}`}},
+		{`list --prefix={{ Arg "some thing" }}/x`, []string{"list", `--prefix={{ Arg "some thing" }}/x`}},
+		{`list "{{ Arg "name" }}"`, []string{"list", `{{ Arg "name" }}`}},
+		{`list '{{ Arg "it's" }}'`, []string{"list", `{{ Arg "it's" }}`}},
+		{"", []string{}},
+	}
+
+	for _, c := range cases {
+		got, err := ShellWords(c.line)
+		if err != nil {
+			t.Errorf("could not split %q: %s", c.line, err)
+			continue
+		}
+
+		if !reflect.DeepEqual(got, c.expected) {
+			t.Errorf("unexpected words for %q, wanted %q, got %q", c.line, c.expected, got)
+		}
+	}
+
+	for _, line := range []string{`say "unterminated`, `say 'unterminated`, `list {{ Opt "scheme"`} {
+		if _, err := ShellWords(line); err == nil {
+			t.Errorf("splitting %q did not fail", line)
+		}
+	}
+}
+
+func TestQuoteWord(t *testing.T) {
+	words := []string{
+		"plain",
+		"",
+		"hello world",
+		"it's",
+		`a "quoted" word`,
+		`back\slash`,
+		`{{ Arg "name" }}`,
+		`--name={{ Arg "it's" }} and 'more'`,
+	}
+
+	for _, w := range words {
+		quoted := QuoteWord(w)
+		got, err := ShellWords(quoted)
+		if err != nil {
+			t.Errorf("could not split quoted %q: %s", quoted, err)
+			continue
+		}
+
+		if !reflect.DeepEqual(got, []string{w}) {
+			t.Errorf("quoting did not round-trip %q, got %q from %q", w, got, quoted)
+		}
+	}
+}
+
+func TestMilpaValueLists(t *testing.T) {
+	path, repo := writeSpec(t, `summary: milpa values
+description: milpa values
+arguments:
+  - name: tag
+    description: the tag
+    values:
+      milpa: [itself, tags, --prefix, '{{ Opt "prefix" }}', "with spaces"]
+options:
+  prefix:
+    description: the prefix
+`)
+
+	cmd, err := New(path, repo, false)
+	if err != nil {
+		t.Fatalf("could not parse spec: %s", err)
+	}
+
+	if cmd.Arguments[0].Values == nil {
+		t.Fatal("values not parsed from milpa list")
+	}
+}
+
+func TestMilpaComplete(t *testing.T) {
+	// a fake milpa that outputs each of its arguments in a line
+	bin := t.TempDir()
+	if err := os.WriteFile(filepath.Join(bin, "milpa"), []byte("#!/usr/bin/env bash\nprintf '%s\\n' \"$@\"\n"), 0o700); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", bin+":"+os.Getenv("PATH"))
+
+	path, repo := writeSpec(t, "summary: milpa values\ndescription: milpa values\n")
+	cmd, err := New(path, repo, false)
+	if err != nil {
+		t.Fatalf("could not parse spec: %s", err)
+	}
+	cmd.Cobra = &cobra.Command{}
+
+	current := `some "thing" it's`
+	list := []string{"itself", "tags", "--prefix={{ Current }}", "{{ Current }}", "with spaces"}
+	quoted := make([]string, len(list))
+	for idx, word := range list {
+		quoted[idx] = QuoteWord(word)
+	}
+
+	for config, expected := range map[string][]string{
+		`itself tags --prefix={{ Current }} {{ Current }} "with spaces"`: {"itself", "tags", "--prefix=" + current, current, "with spaces"},
+		`itself tags '{{ Current }}' ""`:                                 {"itself", "tags", current, ""},
+		`itself tags {{ if Current }}{{ Current }}{{ end }}`:             {"itself", "tags", current},
+		strings.Join(quoted, " "):                                        {"itself", "tags", "--prefix=" + current, current, "with spaces"},
+	} {
+		values, _, err := MilpaComplete(cmd, current, config)
+		if err != nil {
+			t.Errorf("could not complete %s: %s", config, err)
+			continue
+		}
+
+		if !reflect.DeepEqual(values, expected) {
+			t.Errorf("unexpected arguments for %s, wanted %q, got %q", config, expected, values)
+		}
+	}
+}
@@ -0,0 +1,164 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright © 2021 Roberto Hidalgo <milpa@un.rob.mx>
+package command
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"git.rob.mx/nidito/chinampa/pkg/command"
+	"git.rob.mx/nidito/chinampa/pkg/exec"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+// CompletionCacheDir returns the folder where completion values are cached.
+func CompletionCacheDir() string {
+	base := os.Getenv("XDG_CACHE_HOME")
+	if base == "" {
+		base = filepath.Join(os.Getenv("HOME"), ".cache")
+	}
+	return filepath.Join(base, "milpa", "completions")
+}
+
+type cachedValues struct {
+	Expires time.Time                `json:"expires"`
+	Values  []string                 `json:"values"`
+	Flag    cobra.ShellCompDirective `json:"flag"`
+}
+
+func cachePath(cmd *command.Command, kind string, source string, resolved string) string {
+	sum := sha256.Sum256([]byte(strings.Join([]string{cmd.FullName(), kind, source, resolved}, "\x00")))
+	return filepath.Join(append(append([]string{CompletionCacheDir()}, cmd.Path...), hex.EncodeToString(sum[:])+".json")...)
+}
+
+func readCache(path string) (*cachedValues, bool) {
+	contents, err := os.ReadFile(path) // nolint: gosec
+	if err != nil {
+		return nil, false
+	}
+
+	cached := &cachedValues{}
+	if err := json.Unmarshal(contents, cached); err != nil || time.Now().After(cached.Expires) {
+		return nil, false
+	}
+	return cached, true
+}
+
+func writeCache(path string, cached *cachedValues) {
+	contents, err := json.Marshal(cached)
+	if err == nil {
+		err = os.MkdirAll(filepath.Dir(path), 0700)
+	}
+	if err == nil {
+		err = os.WriteFile(path, contents, 0600)
+	}
+	if err != nil {
+		logrus.Debugf("could not write completion cache at %s: %s", path, err)
+	}
+}
+
+// cached wraps fn so its results are stored for ttl, keyed by command, source and resolved template.
+func cached(kind string, source string, ttl time.Duration, fn command.CompletionFunc) command.CompletionFunc {
+	return func(cmd *command.Command, currentValue string, config string) ([]string, cobra.ShellCompDirective, error) {
+		resolved, err := cmd.ResolveTemplate(source, currentValue)
+		if err != nil {
+			return fn(cmd, currentValue, config)
+		}
+
+		path := cachePath(cmd, kind, source, resolved)
+		if hit, ok := readCache(path); ok {
+			logrus.Debugf("using cached completion values from %s", path)
+			return hit.Values, hit.Flag, nil
+		}
+
+		values, flag, err := fn(cmd, currentValue, config)
+		if err == nil {
+			writeCache(path, &cachedValues{Expires: time.Now().Add(ttl), Values: values, Flag: flag})
+		}
+		return values, flag, err
+	}
+}
+
+// scriptCompletion runs a `script` value source like chinampa does, so it can be cached.
+func scriptCompletion(script string, timeout int) command.CompletionFunc {
+	return func(cmd *command.Command, currentValue string, _ string) ([]string, cobra.ShellCompDirective, error) {
+		cmdLine, err := cmd.ResolveTemplate(script, currentValue)
+		if err != nil {
+			return nil, cobra.ShellCompDirectiveError, err
+		}
+
+		env := os.Environ()
+		for k, v := range EnvironmentMap(cmd) {
+			env = append(env, fmt.Sprintf("%s=%s", k, v))
+		}
+
+		duration := 5 * time.Second
+		if timeout > 0 {
+			duration = time.Duration(timeout) * time.Second
+		}
+		logger := logrus.NewEntry(logrus.StandardLogger())
+		if cmd.Cobra != nil {
+			logger = logrus.WithContext(cmd.Cobra.Context())
+		}
+		return exec.Exec(cmd.FullName(), []string{"/bin/bash", "-c", cmdLine}, env, duration, logger)
+	}
+}
+
+type valuesCacheSpec struct {
+	Values *struct {
+		Cache string `yaml:"cache"`
+		Milpa string `yaml:"milpa"`
+	} `yaml:"values"`
+}
+
+// cacheValueSources enables caching for value sources with a `cache` duration in a command's spec.
+func cacheValueSources(cmd *command.Command, spec *milpaSpec) error {
+	apply := func(name string, vs *command.ValueSource, cfg valuesCacheSpec) error {
+		if vs == nil || cfg.Values == nil || cfg.Values.Cache == "" {
+			return nil
+		}
+
+		ttl, err := time.ParseDuration(cfg.Values.Cache)
+		if err != nil {
+			return fmt.Errorf("invalid values.cache for %s: %w", name, err)
+		}
+
+		switch {
+		case vs.Script != "":
+			// chinampa runs scripts itself unless they're cleared, serialized commands keep
+			// them as declared
+			vs.Func = cached("script", vs.Script, ttl, scriptCompletion(vs.Script, vs.Timeout))
+			vs.Script = ""
+		case vs.Func != nil:
+			vs.Func = cached("milpa", cfg.Values.Milpa, ttl, vs.Func)
+		default:
+			return fmt.Errorf("values.cache for %s requires a script or milpa source", name)
+		}
+		return nil
+	}
+
+	for idx, cfg := range spec.Arguments {
+		if idx < len(cmd.Arguments) {
+			if err := apply(cmd.Arguments[idx].Name, cmd.Arguments[idx].Values, cfg); err != nil {
+				return err
+			}
+		}
+	}
+
+	for name, cfg := range spec.Options {
+		if opt, ok := cmd.Options[name]; ok {
+			if err := apply("--"+name, opt.Values, cfg); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
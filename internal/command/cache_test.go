@@ -0,0 +1,100 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright © 2021 Roberto Hidalgo <milpa@un.rob.mx>
+package command_test
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"git.rob.mx/nidito/chinampa/pkg/tree"
+	. "github.com/unrob/milpa/internal/command"
+)
+
+func writeSpec(t *testing.T, spec string) (string, string) {
+	t.Helper()
+	repo := filepath.Join(t.TempDir(), ".milpa")
+	path := filepath.Join(repo, "commands", "cached.sh")
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte("#!/usr/bin/env bash\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(repo, "commands", "cached.yaml"), []byte(spec), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path, repo
+}
+
+func TestCachedValueSources(t *testing.T) {
+	cacheDir := t.TempDir()
+	t.Setenv("XDG_CACHE_HOME", cacheDir)
+
+	path, repo := writeSpec(t, `summary: cached values
+description: cached values
+arguments:
+  - name: tag
+    description: a tag
+    values:
+      script: echo "$RANDOM"
+      cache: 30s
+`)
+
+	cmd, err := New(path, repo, false)
+	if err != nil {
+		t.Fatalf("could not parse spec: %s", err)
+	}
+
+	vs := cmd.Arguments[0].Values
+	if vs.Script != "" || vs.Func == nil {
+		t.Fatalf("script source was not replaced with a cached one: %+v", vs)
+	}
+
+	ct := &tree.CommandTree{Command: cmd, Children: []*tree.CommandTree{}}
+	AddMetaToTree(ct)
+	if declared := ct.Command.Arguments[0].Values; declared.Script != `echo "$RANDOM"` || declared.Func != nil {
+		t.Fatalf("serialized script source does not match its spec: %+v", declared)
+	}
+
+	if vs.Script != "" || cmd.Arguments[0].Values != vs {
+		t.Fatalf("serializing changed the cached source: %+v", cmd.Arguments[0].Values)
+	}
+
+	if CompletionCacheDir() != filepath.Join(cacheDir, "milpa", "completions") {
+		t.Fatalf("unexpected cache dir: %s", CompletionCacheDir())
+	}
+
+	first, _, err := vs.Func(cmd, "", "")
+	if err != nil {
+		t.Fatalf("could not complete: %s", err)
+	}
+
+	cached, err := filepath.Glob(filepath.Join(CompletionCacheDir(), "cached", "*.json"))
+	if err != nil || len(cached) != 1 {
+		t.Fatalf("expected a single cache entry, found %v (%v)", cached, err)
+	}
+
+	second, _, err := vs.Func(cmd, "", "")
+	if err != nil {
+		t.Fatalf("could not complete: %s", err)
+	}
+
+	if !reflect.DeepEqual(first, second) {
+		t.Fatalf("cached values differ: %v != %v", first, second)
+	}
+
+	path, repo = writeSpec(t, `summary: bad cache
+description: bad cache
+arguments:
+  - name: tag
+    description: a tag
+    values:
+      script: echo tag
+      cache: soon
+`)
+	if _, err := New(path, repo, false); err == nil {
+		t.Fatal("parsing an invalid cache duration did not fail")
+	}
+}
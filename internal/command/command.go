@@ -11,10 +11,11 @@ import (
 	"git.rob.mx/nidito/chinampa/pkg/logger"
 	_c "github.com/unrob/milpa/internal/constants"
 	"github.com/unrob/milpa/internal/errors"
-	"gopkg.in/yaml.v3"
 )
 
-func New(path string, repo string) (cmd *command.Command, err error) {
+// New parses the command at path, from repo. Values keep their descriptions only when
+// completing, as validation compares values alone.
+func New(path string, repo string, completing bool) (cmd *command.Command, err error) {
 	meta := metaForPath(path, repo)
 	cmd = &command.Command{
 		Path:      meta.Name,
@@ -44,9 +45,21 @@ func New(path string, repo string) (cmd *command.Command, err error) {
 		spec = path
 	}
 
+	parsed := &milpaSpec{}
 	var contents []byte
 	if contents, err = os.ReadFile(spec); err == nil {
-		err = yaml.Unmarshal(contents, cmd)
+		err = parseSpec(cmd, parsed, contents, &meta, completing)
+	}
+
+	if err == nil {
+		meta.Aliases, err = parseAliases(parsed, meta.Name, meta.Mount)
+		if err == nil && len(meta.Aliases) > 0 && meta.Kind == KindVirtual {
+			err = fmt.Errorf("aliases are not supported for command groups")
+		}
+	}
+
+	if err == nil {
+		err = parseLifecycle(parsed, &meta)
 	}
 
 	if err != nil {
@@ -73,6 +86,7 @@ Run ﹅milpa itself doctor﹅ to diagnose your installed commands.
 	}
 
 	cmd.Meta = meta
+	deprecate(cmd, meta, completing)
 	return cmd.SetBindings(), nil
 }
 
@@ -0,0 +1,271 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright © 2021 Roberto Hidalgo <milpa@un.rob.mx>
+package command
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// ChangeKind describes what changed between two versions of a command tree.
+type ChangeKind string
+
+const (
+	ChangeCommandAdded    ChangeKind = "command-added"
+	ChangeCommandRemoved  ChangeKind = "command-removed"
+	ChangeArgumentAdded   ChangeKind = "argument-added"
+	ChangeArgumentRemoved ChangeKind = "argument-removed"
+	ChangeArgumentChanged ChangeKind = "argument-changed"
+	ChangeOptionAdded     ChangeKind = "option-added"
+	ChangeOptionRemoved   ChangeKind = "option-removed"
+	ChangeOptionChanged   ChangeKind = "option-changed"
+)
+
+// Change is a single difference between two command trees.
+type Change struct {
+	Command  string     `json:"command" yaml:"command"`
+	Kind     ChangeKind `json:"kind" yaml:"kind"`
+	Detail   string     `json:"detail" yaml:"detail"`
+	Breaking bool       `json:"breaking" yaml:"breaking"`
+}
+
+func (c Change) String() string {
+	if c.Detail == "" {
+		return fmt.Sprintf("%s: %s", c.Command, c.Kind)
+	}
+	return fmt.Sprintf("%s: %s %s", c.Command, c.Kind, c.Detail)
+}
+
+type valuesSpec struct {
+	Static *[]string `json:"static,omitempty"`
+}
+
+type argumentSpec struct {
+	Name     string          `json:"name"`
+	Required bool            `json:"required"`
+	Variadic bool            `json:"variadic"`
+	Default  any             `json:"default,omitempty"`
+	Values   json.RawMessage `json:"values,omitempty"`
+}
+
+type optionSpec struct {
+	ShortName string          `json:"short-name,omitempty"`
+	Type      string          `json:"type"`
+	Default   any             `json:"default,omitempty"`
+	Repeated  bool            `json:"repeated"`
+	Values    json.RawMessage `json:"values,omitempty"`
+}
+
+type commandSpec struct {
+	Path      []string               `json:"path"`
+	Arguments []*argumentSpec        `json:"arguments"`
+	Options   map[string]*optionSpec `json:"options"`
+}
+
+type treeSpec struct {
+	Command  *commandSpec `json:"command"`
+	Children []*treeSpec  `json:"children"`
+}
+
+func flattenTree(t *treeSpec, into map[string]*commandSpec) {
+	if t == nil {
+		return
+	}
+
+	if t.Command != nil {
+		into[strings.Join(t.Command.Path, " ")] = t.Command
+	}
+
+	for _, child := range t.Children {
+		flattenTree(child, into)
+	}
+}
+
+func parseTree(serialized []byte) (map[string]*commandSpec, error) {
+	t := &treeSpec{}
+	if err := json.Unmarshal(serialized, t); err != nil {
+		return nil, err
+	}
+
+	commands := map[string]*commandSpec{}
+	flattenTree(t, commands)
+	return commands, nil
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func formatValue(value any) string {
+	if value == nil {
+		return "none"
+	}
+	res, _ := json.Marshal(value)
+	return string(res)
+}
+
+// diffValues compares values sources, flagging previously allowed static values that are now gone.
+func diffValues(old, updated json.RawMessage) (detail string, breaking bool, changed bool) {
+	var oldSource, newSource any
+	_ = json.Unmarshal(old, &oldSource)
+	_ = json.Unmarshal(updated, &newSource)
+	if reflect.DeepEqual(oldSource, newSource) {
+		return "", false, false
+	}
+
+	oldValues := &valuesSpec{}
+	newValues := &valuesSpec{}
+	_ = json.Unmarshal(old, oldValues)
+	_ = json.Unmarshal(updated, newValues)
+
+	if oldValues.Static != nil && newValues.Static != nil {
+		// descriptions may change freely
+		remaining := map[string]bool{}
+		for _, v := range *newValues.Static {
+			remaining[ValueOf(v)] = true
+		}
+
+		removed := []string{}
+		for _, v := range *oldValues.Static {
+			if !remaining[ValueOf(v)] {
+				removed = append(removed, ValueOf(v))
+			}
+		}
+
+		if len(removed) > 0 {
+			return fmt.Sprintf("no longer accepts values %s", strings.Join(removed, ", ")), true, true
+		}
+	}
+
+	return "values source changed", false, true
+}
+
+func diffArguments(name string, old, updated []*argumentSpec) (changes []Change) {
+	for idx, arg := range old {
+		if idx >= len(updated) {
+			changes = append(changes, Change{name, ChangeArgumentRemoved, arg.Name, true})
+			continue
+		}
+
+		next := updated[idx]
+		if next.Name != arg.Name {
+			changes = append(changes, Change{name, ChangeArgumentChanged, fmt.Sprintf("%s renamed to %s", arg.Name, next.Name), false})
+		}
+
+		if !arg.Required && next.Required {
+			changes = append(changes, Change{name, ChangeArgumentChanged, fmt.Sprintf("%s is now required", next.Name), true})
+		} else if arg.Required && !next.Required {
+			changes = append(changes, Change{name, ChangeArgumentChanged, fmt.Sprintf("%s is now optional", next.Name), false})
+		}
+
+		if arg.Variadic && !next.Variadic {
+			changes = append(changes, Change{name, ChangeArgumentChanged, fmt.Sprintf("%s no longer accepts multiple values", next.Name), true})
+		} else if !arg.Variadic && next.Variadic {
+			changes = append(changes, Change{name, ChangeArgumentChanged, fmt.Sprintf("%s now accepts multiple values", next.Name), false})
+		}
+
+		if !reflect.DeepEqual(arg.Default, next.Default) {
+			changes = append(changes, Change{name, ChangeArgumentChanged, fmt.Sprintf("%s default changed from %s to %s", next.Name, formatValue(arg.Default), formatValue(next.Default)), true})
+		}
+
+		if detail, breaking, changed := diffValues(arg.Values, next.Values); changed {
+			changes = append(changes, Change{name, ChangeArgumentChanged, fmt.Sprintf("%s %s", next.Name, detail), breaking})
+		}
+	}
+
+	for idx := len(old); idx < len(updated); idx++ {
+		arg := updated[idx]
+		detail := arg.Name
+		if arg.Required {
+			detail += " (required)"
+		}
+		changes = append(changes, Change{name, ChangeArgumentAdded, detail, arg.Required})
+	}
+
+	return changes
+}
+
+func diffOptions(name string, old, updated map[string]*optionSpec) (changes []Change) {
+	for _, optName := range sortedKeys(old) {
+		opt := old[optName]
+		next, ok := updated[optName]
+		flag := "--" + optName
+		if !ok {
+			changes = append(changes, Change{name, ChangeOptionRemoved, flag, true})
+			continue
+		}
+
+		if opt.Type != next.Type {
+			changes = append(changes, Change{name, ChangeOptionChanged, fmt.Sprintf("%s type changed from %s to %s", flag, formatValue(opt.Type), formatValue(next.Type)), true})
+		}
+
+		if opt.ShortName != next.ShortName {
+			changes = append(changes, Change{name, ChangeOptionChanged, fmt.Sprintf("%s short name changed from %s to %s", flag, formatValue(opt.ShortName), formatValue(next.ShortName)), opt.ShortName != ""})
+		}
+
+		if opt.Repeated && !next.Repeated {
+			changes = append(changes, Change{name, ChangeOptionChanged, fmt.Sprintf("%s can no longer be repeated", flag), true})
+		} else if !opt.Repeated && next.Repeated {
+			changes = append(changes, Change{name, ChangeOptionChanged, fmt.Sprintf("%s can now be repeated", flag), false})
+		}
+
+		if !reflect.DeepEqual(opt.Default, next.Default) {
+			changes = append(changes, Change{name, ChangeOptionChanged, fmt.Sprintf("%s default changed from %s to %s", flag, formatValue(opt.Default), formatValue(next.Default)), true})
+		}
+
+		if detail, breaking, changed := diffValues(opt.Values, next.Values); changed {
+			changes = append(changes, Change{name, ChangeOptionChanged, fmt.Sprintf("%s %s", flag, detail), breaking})
+		}
+	}
+
+	for _, optName := range sortedKeys(updated) {
+		if _, ok := old[optName]; !ok {
+			changes = append(changes, Change{name, ChangeOptionAdded, "--" + optName, false})
+		}
+	}
+
+	return changes
+}
+
+// DiffTrees compares two command trees serialized as json, i.e. by
+// `milpa itself command-tree --output json`, and returns their differences.
+func DiffTrees(old, updated []byte) ([]Change, error) {
+	oldCommands, err := parseTree(old)
+	if err != nil {
+		return nil, fmt.Errorf("could not parse previous command tree: %w", err)
+	}
+
+	newCommands, err := parseTree(updated)
+	if err != nil {
+		return nil, fmt.Errorf("could not parse current command tree: %w", err)
+	}
+
+	changes := []Change{}
+	for _, name := range sortedKeys(oldCommands) {
+		next, ok := newCommands[name]
+		if !ok {
+			changes = append(changes, Change{name, ChangeCommandRemoved, "", true})
+			continue
+		}
+
+		cmd := oldCommands[name]
+		changes = append(changes, diffArguments(name, cmd.Arguments, next.Arguments)...)
+		changes = append(changes, diffOptions(name, cmd.Options, next.Options)...)
+	}
+
+	for _, name := range sortedKeys(newCommands) {
+		if _, ok := oldCommands[name]; !ok {
+			changes = append(changes, Change{name, ChangeCommandAdded, "", false})
+		}
+	}
+
+	return changes, nil
+}
@@ -0,0 +1,101 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright © 2021 Roberto Hidalgo <milpa@un.rob.mx>
+package command_test
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	. "github.com/unrob/milpa/internal/command"
+)
+
+const previousTree = `{
+  "command": {"path": ["milpa"], "arguments": [], "options": {}},
+  "children": [
+    {
+      "command": {
+        "path": ["milpa", "deploy"],
+        "arguments": [
+          {"name": "env", "required": true, "variadic": false, "values": {"static": ["staging", "production"]}},
+          {"name": "services", "required": false, "variadic": true}
+        ],
+        "options": {
+          "dry-run": {"type": "bool", "repeated": false},
+          "region": {"type": "string", "default": "us-east-1", "repeated": false},
+          "verbose": {"type": "bool", "repeated": false}
+        }
+      },
+      "children": []
+    },
+    {"command": {"path": ["milpa", "old"], "arguments": [], "options": {}}, "children": []}
+  ]
+}`
+
+const currentTree = `{
+  "command": {"path": ["milpa"], "arguments": [], "options": {}},
+  "children": [
+    {
+      "command": {
+        "path": ["milpa", "deploy"],
+        "arguments": [
+          {"name": "env", "required": true, "variadic": false, "values": {"static": ["production"]}},
+          {"name": "services", "required": true, "variadic": true}
+        ],
+        "options": {
+          "dry-run": {"type": "bool", "repeated": false},
+          "region": {"type": "string", "default": "eu-west-1", "repeated": false},
+          "timeout": {"type": "int", "repeated": false}
+        }
+      },
+      "children": []
+    },
+    {"command": {"path": ["milpa", "new"], "arguments": [], "options": {}}, "children": []}
+  ]
+}`
+
+func TestDiffTrees(t *testing.T) {
+	changes, err := DiffTrees([]byte(previousTree), []byte(currentTree))
+	if err != nil {
+		t.Fatalf("could not diff trees: %s", err)
+	}
+
+	expected := []Change{
+		{Command: "milpa deploy", Kind: ChangeArgumentChanged, Detail: "env no longer accepts values staging", Breaking: true},
+		{Command: "milpa deploy", Kind: ChangeArgumentChanged, Detail: "services is now required", Breaking: true},
+		{Command: "milpa deploy", Kind: ChangeOptionChanged, Detail: `--region default changed from "us-east-1" to "eu-west-1"`, Breaking: true},
+		{Command: "milpa deploy", Kind: ChangeOptionRemoved, Detail: "--verbose", Breaking: true},
+		{Command: "milpa deploy", Kind: ChangeOptionAdded, Detail: "--timeout", Breaking: false},
+		{Command: "milpa old", Kind: ChangeCommandRemoved, Detail: "", Breaking: true},
+		{Command: "milpa new", Kind: ChangeCommandAdded, Detail: "", Breaking: false},
+	}
+
+	if !reflect.DeepEqual(changes, expected) {
+		t.Fatalf("unexpected changes:\nwanted %v\ngot    %v", expected, changes)
+	}
+
+	compact := strings.NewReplacer("\n", "", "  ", "", ": ", ":").Replace(currentTree)
+	same, err := DiffTrees([]byte(currentTree), []byte(compact))
+	if err != nil {
+		t.Fatalf("could not diff trees: %s", err)
+	}
+
+	if len(same) != 0 {
+		t.Fatalf("found changes between identical trees: %v", same)
+	}
+
+	described := strings.Replace(currentTree, `"static": ["production"]`, `"static": ["production\tlive traffic"]`, 1)
+	changes, err = DiffTrees([]byte(currentTree), []byte(described))
+	if err != nil {
+		t.Fatalf("could not diff trees: %s", err)
+	}
+
+	expected = []Change{{Command: "milpa deploy", Kind: ChangeArgumentChanged, Detail: "env values source changed", Breaking: false}}
+	if !reflect.DeepEqual(changes, expected) {
+		t.Fatalf("unexpected changes after describing values:\nwanted %v\ngot    %v", expected, changes)
+	}
+
+	if _, err := DiffTrees([]byte("not json"), []byte(currentTree)); err == nil {
+		t.Fatal("diffing an invalid tree did not fail")
+	}
+}
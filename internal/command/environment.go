@@ -5,6 +5,7 @@ package command
 import (
 	"fmt"
 	"strings"
+	"sync"
 
 	"git.rob.mx/nidito/chinampa/pkg/command"
 	"git.rob.mx/nidito/chinampa/pkg/env"
@@ -12,6 +13,7 @@ import (
 	"github.com/spf13/pflag"
 	"github.com/unrob/milpa/internal/bootstrap"
 	_c "github.com/unrob/milpa/internal/constants"
+	"github.com/unrob/milpa/internal/repo"
 	"github.com/unrob/milpa/internal/util"
 )
 
@@ -103,19 +105,48 @@ func OptionsEnvMap(cmd *command.Command, dst *map[string]string) {
 	})
 }
 
+// repoDependencies holds the dependency paths of every repo, resolved once per process, since
+// that reads the metadata of every repo they depend on.
+var repoDependencies = struct {
+	sync.Mutex
+	paths map[string][]string
+}{paths: map[string][]string{}}
+
+func dependencyPaths(path string) []string {
+	repoDependencies.Lock()
+	defer repoDependencies.Unlock()
+	deps, resolved := repoDependencies.paths[path]
+	if !resolved {
+		deps = repo.DependencyPaths(path, repo.AllDirs(bootstrap.MilpaRoot)...)
+		repoDependencies.paths[path] = deps
+	}
+	return deps
+}
+
 func EnvironmentMap(cmd *command.Command) map[string]string {
 	meta := cmd.Meta.(Meta)
+	name := cmd.FullName()
+	if len(meta.AliasOf) > 0 {
+		// scripts always see the name of the command they belong to
+		name = strings.Join(meta.AliasOf, " ")
+	}
+	deps := []string{}
+	if meta.Repo != "" {
+		deps = dependencyPaths(meta.Repo)
+	}
+
 	return map[string]string{
-		_c.OutputCommandName: cmd.FullName(),
-		_c.OutputCommandKind: string(meta.Kind),
-		_c.OutputCommandRepo: meta.Repo,
-		_c.OutputCommandPath: meta.Path,
+		_c.OutputCommandName:             name,
+		_c.OutputCommandKind:             string(meta.Kind),
+		_c.OutputCommandRepo:             meta.Repo,
+		_c.OutputCommandRepoDependencies: strings.Join(deps, ":"),
+		_c.OutputCommandPath:             meta.Path,
 	}
 }
 
 func ToEval(cmd *command.Command, args []string) string {
 	output := []string{}
-	for name, value := range util.EnvironmentMap(bootstrap.MilpaPath) {
+	for name, value := range util.EnvironmentMap(bootstrap.MilpaPathEnv(bootstrap.MilpaPath)) {
 		output = append(output, fmt.Sprintf("export %s=%s", name, shellescape.Quote(value)))
 	}
 
@@ -135,7 +166,7 @@ func ToEval(cmd *command.Command, args []string) string {
 }
 
 func Env(cmd *command.Command, seed []string) []string {
-	for name, value := range util.EnvironmentMap(bootstrap.MilpaPath) {
+	for name, value := range util.EnvironmentMap(bootstrap.MilpaPathEnv(bootstrap.MilpaPath)) {
 		seed = append(seed, fmt.Sprintf("%s=%s", name, shellescape.Quote(value)))
 	}
 
@@ -3,11 +3,16 @@
 package command_test
 
 import (
+	"os"
+	"path/filepath"
+	"slices"
 	"strings"
 	"testing"
 
 	"git.rob.mx/nidito/chinampa/pkg/command"
+	"github.com/unrob/milpa/internal/bootstrap"
 	. "github.com/unrob/milpa/internal/command"
+	"github.com/unrob/milpa/internal/repo"
 )
 
 func TestArgumentsToEnv(t *testing.T) {
@@ -295,3 +300,63 @@ func TestOptionsToEnv(t *testing.T) {
 		})
 	}
 }
+
+func TestEnvironmentMilpaPath(t *testing.T) {
+	path, repo := writeSpec(t, "summary: environment\ndescription: environment\n")
+	cmd, err := New(path, repo, false)
+	if err != nil {
+		t.Fatalf("could not parse spec: %s", err)
+	}
+
+	mp, mounts := bootstrap.MilpaPath, bootstrap.Mounts
+	defer func() { bootstrap.MilpaPath, bootstrap.Mounts = mp, mounts }()
+	mounted := t.TempDir()
+	bootstrap.MilpaPath = []string{repo, mounted}
+	bootstrap.Mounts = map[string]string{mounted: "infra"}
+
+	// scripts get the same MILPA_PATH milpa was called with, mounts included
+	expected := "MILPA_PATH=" + repo + ":infra=" + mounted
+	if eval := strings.Split(ToEval(cmd, []string{}), "\n"); !slices.Contains(eval, "export "+expected) {
+		t.Fatalf("%s not exported to scripts: %v", expected, eval)
+	}
+
+	if env := Env(cmd, []string{}); !slices.Contains(env, expected) {
+		t.Fatalf("%s not set for value scripts: %v", expected, env)
+	}
+}
+
+func TestEnvironmentRepoDependencies(t *testing.T) {
+	t.Setenv("XDG_DATA_HOME", t.TempDir())
+	dirs, _ := repo.UserDirs()
+	base := filepath.Join(t.TempDir(), "base")
+	if err := os.MkdirAll(filepath.Join(base, ".milpa", "util"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	installed, err := repo.InstallWithDependencies(base, dirs, "", nil)
+	if err != nil {
+		t.Fatalf("could not install dependency: %s", err)
+	}
+
+	path, repoPath := writeSpec(t, "summary: environment\ndescription: environment\n")
+	metadata := filepath.Join(repoPath, "repo.yaml")
+	if err := os.WriteFile(metadata, []byte("depends:\n  - "+base+"\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	cmd, err := New(path, repoPath, false)
+	if err != nil {
+		t.Fatalf("could not parse spec: %s", err)
+	}
+
+	expected := installed[0].Path
+	if deps := EnvironmentMap(cmd)["MILPA_COMMAND_REPO_DEPENDENCIES"]; deps != expected {
+		t.Fatalf("unexpected dependencies, wanted %q, got %q", expected, deps)
+	}
+
+	// dependencies are resolved once per repo
+	if err := os.Remove(metadata); err != nil {
+		t.Fatal(err)
+	}
+	if deps := EnvironmentMap(cmd)["MILPA_COMMAND_REPO_DEPENDENCIES"]; deps != expected {
+		t.Fatalf("dependencies were resolved again, got %q", deps)
+	}
+}
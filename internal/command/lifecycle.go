@@ -0,0 +1,74 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright © 2021 Roberto Hidalgo <milpa@un.rob.mx>
+package command
+
+import (
+	"fmt"
+	"time"
+
+	"git.rob.mx/nidito/chinampa/pkg/command"
+	"git.rob.mx/nidito/chinampa/pkg/logger"
+)
+
+// RemovalDateFormat is the format of `removed-after` dates in specs.
+const RemovalDateFormat = "2006-01-02"
+
+const defaultDeprecationNotice = "this command is deprecated"
+
+// parseLifecycle reads `deprecated` and `removed-after` from a spec into meta.
+func parseLifecycle(spec *milpaSpec, meta *Meta) error {
+	if spec.RemovedAfter != "" {
+		if _, err := time.Parse(RemovalDateFormat, spec.RemovedAfter); err != nil {
+			return fmt.Errorf("invalid removed-after date <%s>, expected a date like 2027-01-31", spec.RemovedAfter)
+		}
+		if spec.Deprecated == "" {
+			spec.Deprecated = defaultDeprecationNotice
+		}
+	}
+
+	meta.Deprecated = spec.Deprecated
+	meta.RemovedAfter = spec.RemovedAfter
+	return nil
+}
+
+// RemovalDue tells if a command was scheduled for removal before now.
+func (meta Meta) RemovalDue(now time.Time) bool {
+	if meta.RemovedAfter == "" {
+		return false
+	}
+
+	date, err := time.Parse(RemovalDateFormat, meta.RemovedAfter)
+	return err == nil && now.After(date.AddDate(0, 0, 1))
+}
+
+// DeprecationNotice describes why and until when a command is deprecated.
+func (meta Meta) DeprecationNotice() string {
+	if meta.Deprecated == "" {
+		return ""
+	}
+
+	if meta.RemovedAfter == "" {
+		return meta.Deprecated
+	}
+	return fmt.Sprintf("%s; it will be removed after %s", meta.Deprecated, meta.RemovedAfter)
+}
+
+// deprecate hides a deprecated cmd when completing, adds a banner to its help, and
+// warns whenever it runs.
+func deprecate(cmd *command.Command, meta Meta, completing bool) {
+	notice := meta.DeprecationNotice()
+	if notice == "" {
+		return
+	}
+
+	// cobra leaves hidden commands out of help too, so they're only hidden from completion
+	cmd.Hidden = cmd.Hidden || completing
+	cmd.Description = fmt.Sprintf("> ⚠️ **Deprecated**: %s\n\n%s", notice, cmd.Description)
+
+	if action := cmd.Action; action != nil {
+		cmd.Action = func(c *command.Command) error {
+			logger.Main.Warnf("milpa %s is deprecated: %s", c.FullName(), notice)
+			return action(c)
+		}
+	}
+}
@@ -0,0 +1,76 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright © 2021 Roberto Hidalgo <milpa@un.rob.mx>
+package command_test
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	. "github.com/unrob/milpa/internal/command"
+)
+
+func TestDeprecatedCommands(t *testing.T) {
+	path, repo := writeSpec(t, `summary: deprecated
+description: retired
+deprecated: use milpa x y instead
+removed-after: 2027-01-01
+`)
+
+	cmd, err := New(path, repo, false)
+	if err != nil {
+		t.Fatalf("could not parse spec: %s", err)
+	}
+
+	if cmd.Hidden {
+		t.Fatal("deprecated command is hidden from help")
+	}
+
+	completing, err := New(path, repo, true)
+	if err != nil {
+		t.Fatalf("could not parse spec: %s", err)
+	}
+	if !completing.Hidden {
+		t.Fatal("deprecated command is not hidden from completion")
+	}
+
+	if !strings.HasPrefix(cmd.Description, "> ⚠️ **Deprecated**: use milpa x y instead; it will be removed after 2027-01-01\n\nretired") {
+		t.Fatalf("missing deprecation banner: %s", cmd.Description)
+	}
+
+	meta, _ := MetaFor(cmd)
+	cases := map[string]bool{
+		"2026-12-31T23:00:00Z": false,
+		"2027-01-01T12:00:00Z": false,
+		"2027-01-02T00:00:01Z": true,
+	}
+	for date, expected := range cases {
+		now, _ := time.Parse(time.RFC3339, date)
+		if got := meta.RemovalDue(now); got != expected {
+			t.Errorf("unexpected removal due at %s, wanted %v, got %v", date, expected, got)
+		}
+	}
+
+	path, repo = writeSpec(t, "summary: removed\ndescription: removed\nremoved-after: 2027-01-01\n")
+	cmd, err = New(path, repo, false)
+	if err != nil {
+		t.Fatalf("could not parse spec: %s", err)
+	}
+	if meta, _ := MetaFor(cmd); meta.DeprecationNotice() != "this command is deprecated; it will be removed after 2027-01-01" {
+		t.Fatalf("unexpected notice: %s", meta.DeprecationNotice())
+	}
+
+	path, repo = writeSpec(t, "summary: current\ndescription: current\n")
+	cmd, err = New(path, repo, false)
+	if err != nil {
+		t.Fatalf("could not parse spec: %s", err)
+	}
+	if meta, _ := MetaFor(cmd); cmd.Hidden || meta.DeprecationNotice() != "" || meta.RemovalDue(time.Now()) {
+		t.Fatal("command without lifecycle fields is deprecated")
+	}
+
+	path, repo = writeSpec(t, "summary: bad\ndescription: bad\nremoved-after: next year\n")
+	if _, err := New(path, repo, false); err == nil {
+		t.Fatal("parsing an invalid removal date did not fail")
+	}
+}
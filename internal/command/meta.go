@@ -6,7 +6,11 @@ import (
 	"path/filepath"
 	"strings"
 
+	"git.rob.mx/nidito/chinampa/pkg/command"
+	"git.rob.mx/nidito/chinampa/pkg/tree"
+	"github.com/unrob/milpa/internal/bootstrap"
 	_c "github.com/unrob/milpa/internal/constants"
+	"github.com/unrob/milpa/internal/repo"
 )
 
 type Kind string
@@ -27,8 +31,23 @@ type Meta struct {
 	// Name is a list of words naming this command
 	Name []string `json:"name" yaml:"name"`
 	// Kind can be executable (a binary or executable file), source (.sh file), or virtual (a sub-command group)
-	Kind   Kind `json:"kind" yaml:"kind"`
-	issues []error
+	Kind Kind `json:"kind" yaml:"kind"`
+	// Aliases are additional names this command can be called by
+	Aliases []Alias `json:"aliases,omitempty" yaml:"aliases,omitempty"`
+	// AliasOf is the name of the command an alias runs
+	AliasOf []string `json:"alias-of,omitempty" yaml:"alias-of,omitempty"`
+	// Deprecated explains why this command should no longer be used
+	Deprecated string `json:"deprecated,omitempty" yaml:"deprecated,omitempty"`
+	// RemovedAfter is the date, formatted as YYYY-MM-DD, after which this command is removed
+	RemovedAfter string `json:"removed-after,omitempty" yaml:"removed-after,omitempty"`
+	// Mount is the command prefix this command's repo is mounted under, if any
+	Mount string `json:"mount,omitempty" yaml:"mount,omitempty"`
+	// RepoMetadata is read from the repo.yaml of this command's repo, if any
+	RepoMetadata *repo.Metadata `json:"repo-metadata,omitempty" yaml:"repo-metadata,omitempty"`
+	issues       []error
+	// declared holds arguments and options with their value sources as written in the spec,
+	// since completion and validation wrap some of these at runtime
+	declared *command.Command
 }
 
 func metaForPath(path string, repo string) (meta Meta) {
@@ -54,6 +73,10 @@ func metaForPath(path string, repo string) (meta Meta) {
 
 	meta.Repo = repo
 	meta.Name = strings.Split(name, "/")
+	if mount := bootstrap.Mounts[repo]; mount != "" {
+		meta.Mount = mount
+		meta.Name = append([]string{mount}, meta.Name...)
+	}
 	meta.issues = []error{}
 
 	return
@@ -62,3 +85,120 @@ func metaForPath(path string, repo string) (meta Meta) {
 func (meta *Meta) ParsingErrors() []error {
 	return meta.issues
 }
+
+// MetaFor returns the Meta of cmd, whether set while parsing a spec or by AddMetaToTree.
+func MetaFor(cmd *command.Command) (Meta, bool) {
+	switch meta := cmd.Meta.(type) {
+	case Meta:
+		return meta, true
+	case *Meta:
+		return *meta, meta != nil
+	case **Meta:
+		if meta != nil && *meta != nil {
+			return **meta, true
+		}
+	}
+	return Meta{}, false
+}
+
+// AddMetaToTree fills in Meta for commands that have none, i.e. built-in groups, and the
+// metadata of the repo every other command comes from.
+func AddMetaToTree(t *tree.CommandTree) {
+	addMetaToTree(t, map[string]*repo.Metadata{})
+}
+
+func addMetaToTree(t *tree.CommandTree, repos map[string]*repo.Metadata) {
+	if t.Command != nil {
+		addMeta(t.Command, repos)
+		t.Command = asDeclared(t.Command)
+	}
+
+	for _, subT := range t.Children {
+		addMetaToTree(subT, repos)
+	}
+}
+
+func addMeta(cmd *command.Command, repos map[string]*repo.Metadata) {
+	if meta, ok := cmd.Meta.(Meta); ok && meta.Repo != "" {
+		if _, seen := repos[meta.Repo]; !seen {
+			// invalid metadata is reported during bootstrap
+			repos[meta.Repo], _ = repo.ReadMetadata(meta.Repo)
+		}
+		meta.RepoMetadata = repos[meta.Repo]
+		cmd.Meta = meta
+		return
+	}
+
+	if cmd.Meta == nil {
+		meta := &Meta{
+			Path: cmd.Name(),
+			Repo: "",
+			Name: cmd.Path,
+			Kind: KindVirtual,
+		}
+		cmd.Meta = &meta
+		if cmd.Path[0] != "milpa" {
+			cmd.Path = append([]string{"milpa"}, cmd.Path...)
+		}
+	}
+}
+
+// declare keeps a copy of the arguments and options of cmd, before their value sources
+// are wrapped for completion and validation.
+func declare(cmd *command.Command, meta *Meta) {
+	meta.declared = &command.Command{
+		Arguments: copyArguments(cmd.Arguments),
+		Options:   copyOptions(cmd.Options),
+	}
+}
+
+// copyValues returns a copy of vs, so changing one does not change the other.
+func copyValues(vs *command.ValueSource) *command.ValueSource {
+	if vs == nil {
+		return nil
+	}
+	copied := *vs
+	return &copied
+}
+
+// copyArguments returns copies of args, along with their value sources.
+func copyArguments(args command.Arguments) command.Arguments {
+	copied := make(command.Arguments, len(args))
+	for idx, arg := range args {
+		if arg == nil {
+			continue
+		}
+		argCopy := *arg
+		argCopy.Values = copyValues(arg.Values)
+		copied[idx] = &argCopy
+	}
+	return copied
+}
+
+// copyOptions returns copies of opts, along with their value sources.
+func copyOptions(opts command.Options) command.Options {
+	copied := make(command.Options, len(opts))
+	for name, opt := range opts {
+		if opt == nil {
+			continue
+		}
+		optCopy := *opt
+		optCopy.Values = copyValues(opt.Values)
+		copied[name] = &optCopy
+	}
+	return copied
+}
+
+// asDeclared returns a copy of cmd with its arguments and options as written in its spec,
+// for serialization.
+func asDeclared(cmd *command.Command) *command.Command {
+	meta, ok := MetaFor(cmd)
+	if !ok || meta.declared == nil {
+		return cmd
+	}
+
+	declared := *cmd
+	declared.Arguments = meta.declared.Arguments
+	declared.Options = meta.declared.Options
+	return &declared
+}
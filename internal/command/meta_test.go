@@ -0,0 +1,52 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright © 2021 Roberto Hidalgo <milpa@un.rob.mx>
+package command_test
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/unrob/milpa/internal/bootstrap"
+	. "github.com/unrob/milpa/internal/command"
+)
+
+func TestMountedCommands(t *testing.T) {
+	path, repo := writeSpec(t, "summary: mounted\ndescription: mounted\naliases: [c, old cached]\n")
+	group := filepath.Join(repo, "commands", "cached", "_cached.yaml")
+	if err := os.MkdirAll(filepath.Dir(group), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(group, []byte("summary: a group\ndescription: a group\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	defer func() { bootstrap.Mounts = map[string]string{} }()
+	for mount, expected := range map[string][]string{
+		"":      {"cached"},
+		"infra": {"infra", "cached"},
+	} {
+		aliases := []string{"c", "old cached"}
+		if mount != "" {
+			aliases = []string{mount + " c", mount + " old cached"}
+		}
+
+		bootstrap.Mounts = map[string]string{repo: mount}
+		for _, spec := range []string{path, group} {
+			cmd, err := New(spec, repo, false)
+			if err != nil {
+				t.Fatalf("could not parse spec %s: %s", spec, err)
+			}
+
+			meta, _ := MetaFor(cmd)
+			if !reflect.DeepEqual(cmd.Path, expected) || !reflect.DeepEqual(meta.Name, expected) || meta.Mount != mount {
+				t.Fatalf("unexpected name for %s mounted at %q: %v, meta %+v", spec, mount, cmd.Path, meta)
+			}
+
+			if spec == path && (len(meta.Aliases) != 2 || meta.Aliases[0].String() != aliases[0] || meta.Aliases[1].String() != aliases[1]) {
+				t.Fatalf("unexpected aliases mounted at %q: %v", mount, meta.Aliases)
+			}
+		}
+	}
+}
@@ -0,0 +1,182 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright © 2021 Roberto Hidalgo <milpa@un.rob.mx>
+package command
+
+import (
+	"os"
+	"strings"
+
+	"git.rob.mx/nidito/chinampa/pkg/command"
+	"github.com/spf13/cobra"
+	"gopkg.in/yaml.v3"
+)
+
+// DescriptionSeparator splits a completion value from its description, i.e. `value<TAB>description`.
+const DescriptionSeparator = "\t"
+
+// IsCompleting tells if this process is serving shell completions, for commands to be
+// parsed with described values.
+func IsCompleting() bool {
+	return len(os.Args) > 1 && strings.HasPrefix(os.Args[1], cobra.ShellCompRequestCmd)
+}
+
+// ValueOf returns a completion value without its description.
+func ValueOf(described string) string {
+	value, _, _ := strings.Cut(described, DescriptionSeparator)
+	return value
+}
+
+// expandStaticMaps replaces `static` value maps in a spec with a list of
+// values, described as `value<TAB>description`.
+func expandStaticMaps(doc *yaml.Node) {
+	var walk func(node *yaml.Node, parentKey string)
+	walk = func(node *yaml.Node, parentKey string) {
+		switch node.Kind {
+		case yaml.DocumentNode, yaml.SequenceNode:
+			for _, child := range node.Content {
+				walk(child, parentKey)
+			}
+		case yaml.MappingNode:
+			for idx := 0; idx+1 < len(node.Content); idx += 2 {
+				key, value := node.Content[idx], node.Content[idx+1]
+				if parentKey == "values" && key.Value == "static" && value.Kind == yaml.MappingNode {
+					list := &yaml.Node{Kind: yaml.SequenceNode, Tag: "!!seq", Line: value.Line, Column: value.Column}
+					for item := 0; item+1 < len(value.Content); item += 2 {
+						entry := value.Content[item].Value
+						if value.Content[item+1].Value != "" {
+							entry += DescriptionSeparator + value.Content[item+1].Value
+						}
+						list.Content = append(list.Content, &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: entry})
+					}
+					node.Content[idx+1] = list
+					continue
+				}
+				walk(value, key.Value)
+			}
+		}
+	}
+	walk(doc, "")
+}
+
+// joinMilpaLists replaces `milpa` value sources written as a list of
+// arguments with the equivalent, quoted, command line.
+func joinMilpaLists(doc *yaml.Node) {
+	var walk func(node *yaml.Node, parentKey string)
+	walk = func(node *yaml.Node, parentKey string) {
+		switch node.Kind {
+		case yaml.DocumentNode, yaml.SequenceNode:
+			for _, child := range node.Content {
+				walk(child, parentKey)
+			}
+		case yaml.MappingNode:
+			for idx := 0; idx+1 < len(node.Content); idx += 2 {
+				key, value := node.Content[idx], node.Content[idx+1]
+				if parentKey == "values" && key.Value == "milpa" && value.Kind == yaml.SequenceNode {
+					words := make([]string, len(value.Content))
+					for item, arg := range value.Content {
+						words[item] = QuoteWord(arg.Value)
+					}
+					node.Content[idx+1] = &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: strings.Join(words, " "), Line: value.Line, Column: value.Column}
+					continue
+				}
+				walk(value, key.Value)
+			}
+		}
+	}
+	walk(doc, "")
+}
+
+// withoutDescriptions drops descriptions from values returned by fn.
+func withoutDescriptions(fn command.CompletionFunc) command.CompletionFunc {
+	return func(cmd *command.Command, currentValue string, config string) ([]string, cobra.ShellCompDirective, error) {
+		values, flag, err := fn(cmd, currentValue, config)
+		for idx, value := range values {
+			values[idx] = ValueOf(value)
+		}
+		return values, flag, err
+	}
+}
+
+// milpaSpec holds the keys of a spec milpa reads, on top of those chinampa decodes into commands.
+type milpaSpec struct {
+	Aliases           []string                   `yaml:"aliases"`
+	DeprecatedAliases []string                   `yaml:"deprecated-alias"`
+	Deprecated        string                     `yaml:"deprecated"`
+	RemovedAfter      string                     `yaml:"removed-after"`
+	Arguments         []valuesCacheSpec          `yaml:"arguments"`
+	Options           map[string]valuesCacheSpec `yaml:"options"`
+}
+
+// parseSpec decodes a command spec into cmd and spec, keeping value descriptions only when
+// completing, since validation compares values alone. The value sources, as declared, are kept
+// in meta.
+func parseSpec(cmd *command.Command, spec *milpaSpec, contents []byte, meta *Meta, completing bool) error {
+	doc := &yaml.Node{}
+	if err := yaml.Unmarshal(contents, doc); err != nil {
+		return err
+	}
+
+	if doc.Kind == 0 {
+		// empty specs are fine
+		return nil
+	}
+
+	expandStaticMaps(doc)
+	joinMilpaLists(doc)
+	if err := doc.Decode(cmd); err != nil {
+		return err
+	}
+
+	if err := doc.Decode(spec); err != nil {
+		return err
+	}
+
+	declare(cmd, meta)
+
+	if err := cacheValueSources(cmd, spec); err != nil {
+		return err
+	}
+
+	if completing {
+		// cobra takes care of showing descriptions
+		return nil
+	}
+
+	strip := func(vs *command.ValueSource) {
+		if vs == nil {
+			return
+		}
+
+		if vs.Static != nil {
+			// declared values share this list
+			values := make([]string, len(*vs.Static))
+			for idx, value := range *vs.Static {
+				values[idx] = ValueOf(value)
+			}
+			vs.Static = &values
+		}
+
+		switch {
+		case vs.Script != "":
+			// chinampa runs scripts itself unless they're cleared
+			vs.Func = withoutDescriptions(scriptCompletion(vs.Script, vs.Timeout))
+			vs.Script = ""
+		case vs.Func != nil:
+			vs.Func = withoutDescriptions(vs.Func)
+		}
+	}
+
+	for _, arg := range cmd.Arguments {
+		if arg != nil {
+			strip(arg.Values)
+		}
+	}
+
+	for _, opt := range cmd.Options {
+		if opt != nil {
+			strip(opt.Values)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,115 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright © 2021 Roberto Hidalgo <milpa@un.rob.mx>
+package command_test
+
+import (
+	"reflect"
+	"testing"
+
+	"git.rob.mx/nidito/chinampa/pkg/tree"
+	. "github.com/unrob/milpa/internal/command"
+)
+
+func TestValueOf(t *testing.T) {
+	cases := map[string]string{
+		"i-0a12f\tweb server":    "i-0a12f",
+		"plain":                  "plain",
+		"tabs\tin\tdescriptions": "tabs",
+		"":                       "",
+	}
+
+	for described, expected := range cases {
+		if got := ValueOf(described); got != expected {
+			t.Errorf("unexpected value for %q, wanted %q, got %q", described, expected, got)
+		}
+	}
+}
+
+func TestStaticValueMaps(t *testing.T) {
+	path, repo := writeSpec(t, `summary: described values
+description: described values
+arguments:
+  - name: increment
+    description: the increment
+    values:
+      static:
+        major: breaking changes
+        minor: new features
+        patch: ""
+options:
+  scheme:
+    description: the scheme
+    values:
+      static: [semver, calver]
+`)
+
+	cmd, err := New(path, repo, false)
+	if err != nil {
+		t.Fatalf("could not parse spec: %s", err)
+	}
+
+	static := cmd.Arguments[0].Values.Static
+	if static == nil || !reflect.DeepEqual(*static, []string{"major", "minor", "patch"}) {
+		t.Fatalf("unexpected static values: %v", static)
+	}
+
+	static = cmd.Options["scheme"].Values.Static
+	if static == nil || !reflect.DeepEqual(*static, []string{"semver", "calver"}) {
+		t.Fatalf("unexpected static values: %v", static)
+	}
+
+	described := []string{"major\tbreaking changes", "minor\tnew features", "patch"}
+	ct := &tree.CommandTree{Command: cmd, Children: []*tree.CommandTree{}}
+	AddMetaToTree(ct)
+	if static := ct.Command.Arguments[0].Values.Static; static == nil || !reflect.DeepEqual(*static, described) {
+		t.Fatalf("serialized values lost their descriptions: %v", static)
+	}
+
+	cmd, err = New(path, repo, true)
+	if err != nil {
+		t.Fatalf("could not parse spec: %s", err)
+	}
+
+	if static := cmd.Arguments[0].Values.Static; static == nil || !reflect.DeepEqual(*static, described) {
+		t.Fatalf("unexpected static values when completing: %v", static)
+	}
+}
+
+func TestScriptValueDescriptions(t *testing.T) {
+	path, repo := writeSpec(t, `summary: described values
+description: described values
+arguments:
+  - name: instance
+    description: the instance
+    values:
+      script: printf 'i-0a12f\tweb server\ni-0b34c\n'
+`)
+
+	cmd, err := New(path, repo, false)
+	if err != nil {
+		t.Fatalf("could not parse spec: %s", err)
+	}
+
+	vs := cmd.Arguments[0].Values
+	if vs.Script != "" || vs.Func == nil {
+		t.Fatalf("script source was not replaced for validation: %+v", vs)
+	}
+
+	values, _, err := vs.Func(cmd, "", "")
+	if err != nil {
+		t.Fatalf("could not resolve values: %s", err)
+	}
+
+	if !reflect.DeepEqual(values, []string{"i-0a12f", "i-0b34c"}) {
+		t.Fatalf("descriptions were not dropped: %v", values)
+	}
+
+	cmd, err = New(path, repo, true)
+	if err != nil {
+		t.Fatalf("could not parse spec: %s", err)
+	}
+
+	if vs := cmd.Arguments[0].Values; vs.Script == "" || vs.Func != nil {
+		t.Fatalf("script source was replaced when completing: %+v", vs)
+	}
+}
@@ -32,6 +32,8 @@ const EnvVarDebug = "DEBUG"
 const EnvVarLookupGitDisabled = "MILPA_DISABLE_GIT"
 const EnvVarLookupUserReposDisabled = "MILPA_DISABLE_USER_REPOS" // nolint:gosec
 const EnvVarLookupGlobalReposDisabled = "MILPA_DISABLE_GLOBAL_REPOS"
+const EnvVarLookupParents = "MILPA_LOOKUP_PARENTS"
+const EnvVarLookupBoundary = "MILPA_LOOKUP_BOUNDARY"
 
 // Folder structure.
 const RepoRoot = ".milpa"
@@ -39,7 +41,10 @@ const RepoCommandFolderName = "commands"
 const RepoCommands = ".milpa/commands"
 const RepoDocsFolderName = "docs"
 const RepoDocsTemplateFolderName = ".template"
+const RepoDocsTemplateLayoutName = "template.html"
+const RepoDocsTemplateConfigName = "site.yaml"
 const RepoDocs = ".milpa/docs"
+const RepoMetadataName = "repo.yaml"
 
 // Output variable prefixes.
 const OutputPrefixArg = "MILPA_ARG_"
@@ -47,6 +52,7 @@ const OutputPrefixOpt = "MILPA_OPT_"
 const OutputCommandName = "MILPA_COMMAND_NAME"
 const OutputCommandKind = "MILPA_COMMAND_KIND"
 const OutputCommandRepo = "MILPA_COMMAND_REPO"
+const OutputCommandRepoDependencies = "MILPA_COMMAND_REPO_DEPENDENCIES"
 const OutputCommandPath = "MILPA_COMMAND_PATH"
 
 var OutputPrefixPattern = regexp.MustCompile(`\$\{?[#!]?MILPA_((OPT|ARG)_([0-9a-zA-Z_]+))`)
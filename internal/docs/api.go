@@ -0,0 +1,139 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright © 2021 Roberto Hidalgo <milpa@un.rob.mx>
+package docs
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"git.rob.mx/nidito/chinampa/pkg/command"
+	"git.rob.mx/nidito/chinampa/pkg/tree"
+	milpaCmd "github.com/unrob/milpa/internal/command"
+	"github.com/unrob/milpa/internal/lookup"
+	"gopkg.in/yaml.v3"
+)
+
+// APIPath is where the JSON API is served from.
+const APIPath = "/api/"
+
+// Topic is the API representation of a documentation topic.
+type Topic struct {
+	Topic       string         `json:"topic"`
+	FrontMatter map[string]any `json:"front-matter"`
+	Markdown    string         `json:"markdown"`
+}
+
+type apiError struct {
+	Error string `json:"error"`
+}
+
+func writeJSON(w http.ResponseWriter, status int, data any) {
+	var body bytes.Buffer
+	enc := json.NewEncoder(&body)
+	// markdown is full of <, > and &, so keep them readable
+	enc.SetEscapeHTML(false)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(data); err != nil {
+		log.Errorf("could not serialize response: %s", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Add("content-type", "application/json")
+	w.WriteHeader(status)
+	if _, err := w.Write(body.Bytes()); err != nil {
+		log.Errorf("could not write response: %s", err)
+	}
+}
+
+func commandTree(path []string) ([]byte, error) {
+	// tree.Build and tree.Serialize work on package-level state shared by every request,
+	// and the command tree itself changes when watching for changes
+	lookup.TreeMutex.Lock()
+	defer lookup.TreeMutex.Unlock()
+
+	root := command.Root.Cobra.Root()
+	base, remaining, err := root.Find(path)
+	if err != nil || (len(path) > 0 && len(remaining) > 0) {
+		return nil, fmt.Errorf("no command named %s", strings.Join(path, " "))
+	}
+
+	tree.Build(base, 15)
+	serialized, err := tree.Serialize(func(t any) ([]byte, error) {
+		if ct, ok := t.(*tree.CommandTree); ok {
+			milpaCmd.AddMetaToTree(ct)
+		}
+		return json.MarshalIndent(t, "", "  ")
+	})
+	return []byte(serialized), err
+}
+
+func docsTopic(query []string) (*Topic, error) {
+	contents, err := FromQuery(query)
+	if err != nil {
+		return nil, err
+	}
+
+	topic := &Topic{
+		Topic:       strings.Join(query, "/"),
+		FrontMatter: map[string]any{},
+		Markdown:    string(contents),
+	}
+
+	frontmatterSep := []byte("---\n")
+	if len(contents) > 4 && strings.HasPrefix(string(contents), string(frontmatterSep)) {
+		parts := strings.SplitN(string(contents), string(frontmatterSep), 3)
+		if len(parts) == 3 {
+			if err := yaml.Unmarshal([]byte(parts[1]), &topic.FrontMatter); err != nil {
+				return nil, fmt.Errorf("could not parse front matter for %s: %w", topic.Topic, err)
+			}
+			topic.Markdown = parts[2]
+		}
+	}
+
+	return topic, nil
+}
+
+// APIHandler serves command specs and documentation topics as JSON:
+//
+//   - /api/commands returns the full command tree
+//   - /api/commands/{path} returns the tree for the command at path
+//   - /api/docs/{topic} returns a topic's markdown and front matter
+func APIHandler() func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		prefix := strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, APIPath), "/")
+		comps := strings.Split(prefix, "/")
+		log.Infof("Handling api request for: %s", comps)
+
+		switch comps[0] {
+		case "commands":
+			serialized, err := commandTree(comps[1:])
+			if err != nil {
+				writeJSON(w, http.StatusNotFound, apiError{Error: err.Error()})
+				return
+			}
+
+			w.Header().Add("content-type", "application/json")
+			if _, err := w.Write(serialized); err != nil {
+				log.Errorf("could not write response: %s", err)
+			}
+		case "docs":
+			if len(comps) < 2 {
+				writeJSON(w, http.StatusNotFound, apiError{Error: "a topic is required"})
+				return
+			}
+
+			topic, err := docsTopic(comps[1:])
+			if err != nil {
+				writeJSON(w, http.StatusNotFound, apiError{Error: err.Error()})
+				return
+			}
+			writeJSON(w, http.StatusOK, topic)
+		default:
+			writeJSON(w, http.StatusNotFound, apiError{Error: fmt.Sprintf("unknown api endpoint %s", r.URL.Path)})
+		}
+	}
+}
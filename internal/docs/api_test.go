@@ -0,0 +1,182 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright © 2021 Roberto Hidalgo <milpa@un.rob.mx>
+package docs_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"reflect"
+	"runtime"
+	"strings"
+	"testing"
+
+	"git.rob.mx/nidito/chinampa"
+	"git.rob.mx/nidito/chinampa/pkg/command"
+	"github.com/spf13/cobra"
+	"github.com/unrob/milpa/internal/bootstrap"
+	milpaCmd "github.com/unrob/milpa/internal/command"
+	. "github.com/unrob/milpa/internal/docs"
+)
+
+func fromProjectRoot() string {
+	_, filename, _, _ := runtime.Caller(0)
+	dir := path.Join(path.Dir(filename), "../../")
+	if err := os.Chdir(dir); err != nil {
+		panic(err)
+	}
+	wd, _ := os.Getwd()
+	return wd
+}
+
+func TestAPIDocs(t *testing.T) {
+	root := fromProjectRoot()
+	bootstrap.MilpaPath = []string{root + "/.milpa"}
+	handler := APIHandler()
+
+	t.Run("existing topic", func(t *testing.T) {
+		rec := httptest.NewRecorder()
+		handler(rec, httptest.NewRequest(http.MethodGet, "/api/docs/milpa/environment", nil))
+
+		if rec.Code != http.StatusOK {
+			t.Fatalf("unexpected status %d: %s", rec.Code, rec.Body.String())
+		}
+
+		if ct := rec.Header().Get("content-type"); ct != "application/json" {
+			t.Fatalf("unexpected content type: %s", ct)
+		}
+
+		topic := &Topic{}
+		if err := json.Unmarshal(rec.Body.Bytes(), topic); err != nil {
+			t.Fatalf("could not decode response: %s", err)
+		}
+
+		if topic.Topic != "milpa/environment" {
+			t.Fatalf("unexpected topic: %s", topic.Topic)
+		}
+
+		if desc := topic.FrontMatter["description"]; desc != "An overview of all milpa environment variables" {
+			t.Fatalf("unexpected front matter description: %v", desc)
+		}
+
+		if weight := topic.FrontMatter["weight"]; weight != float64(10) {
+			t.Fatalf("unexpected front matter weight: %v", weight)
+		}
+
+		if !strings.HasPrefix(topic.Markdown, "There's a few environment variables") {
+			t.Fatalf("front matter was not stripped from markdown: %s", topic.Markdown)
+		}
+	})
+
+	t.Run("missing topic", func(t *testing.T) {
+		rec := httptest.NewRecorder()
+		handler(rec, httptest.NewRequest(http.MethodGet, "/api/docs/milpa/does-not-exist", nil))
+
+		if rec.Code != http.StatusNotFound {
+			t.Fatalf("unexpected status %d: %s", rec.Code, rec.Body.String())
+		}
+
+		if !strings.Contains(rec.Body.String(), `"error": "Missing topic named <milpa/does-not-exist.md>`) {
+			t.Fatalf("unexpected error body: %s", rec.Body.String())
+		}
+	})
+
+	t.Run("misspelled topic", func(t *testing.T) {
+		rec := httptest.NewRecorder()
+		handler(rec, httptest.NewRequest(http.MethodGet, "/api/docs/milpa/enviornment", nil))
+
+		if rec.Code != http.StatusNotFound {
+			t.Fatalf("unexpected status %d: %s", rec.Code, rec.Body.String())
+		}
+
+		if !strings.Contains(rec.Body.String(), "did you mean `milpa help docs milpa environment`?") {
+			t.Fatalf("missing suggestion in error body: %s", rec.Body.String())
+		}
+	})
+
+	t.Run("unknown endpoint", func(t *testing.T) {
+		rec := httptest.NewRecorder()
+		handler(rec, httptest.NewRequest(http.MethodGet, "/api/nope", nil))
+
+		if rec.Code != http.StatusNotFound {
+			t.Fatalf("unexpected status %d: %s", rec.Code, rec.Body.String())
+		}
+	})
+}
+
+type apiTree struct {
+	Command struct {
+		Path    []string `json:"path"`
+		Summary string   `json:"summary"`
+	} `json:"command"`
+	Children []*apiTree `json:"children"`
+}
+
+func TestAPICommands(t *testing.T) {
+	root := *command.Root
+	defer func() { *command.Root = root }()
+	command.Root.Path = []string{"milpa"}
+	command.Root.Cobra = &cobra.Command{Use: "milpa"}
+
+	parent := command.Root.Cobra
+	for _, path := range [][]string{{"group"}, {"group", "nested"}} {
+		cmd := &command.Command{
+			Path:    path,
+			Summary: "summary of " + strings.Join(path, " "),
+			Meta:    milpaCmd.Meta{Name: path, Kind: milpaCmd.KindExecutable},
+		}
+		cmd.Cobra = &cobra.Command{Use: cmd.Name(), Short: cmd.Summary}
+		chinampa.Register(cmd)
+		parent.AddCommand(cmd.Cobra)
+		parent = cmd.Cobra
+	}
+	handler := APIHandler()
+
+	for url, expected := range map[string][]string{
+		"/api/commands":               {"milpa"},
+		"/api/commands/":              {"milpa"},
+		"/api/commands/group":         {"group"},
+		"/api/commands/group/nested/": {"group", "nested"},
+	} {
+		t.Run(url, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			handler(rec, httptest.NewRequest(http.MethodGet, url, nil))
+
+			if rec.Code != http.StatusOK {
+				t.Fatalf("unexpected status %d: %s", rec.Code, rec.Body.String())
+			}
+
+			if ct := rec.Header().Get("content-type"); ct != "application/json" {
+				t.Fatalf("unexpected content type: %s", ct)
+			}
+
+			res := &apiTree{}
+			if err := json.Unmarshal(rec.Body.Bytes(), res); err != nil {
+				t.Fatalf("could not decode response: %s", err)
+			}
+
+			if !reflect.DeepEqual(res.Command.Path, expected) {
+				t.Fatalf("unexpected command, wanted %v, got %v", expected, res.Command.Path)
+			}
+
+			if expected[0] == "milpa" && (len(res.Children) != 1 || len(res.Children[0].Children) != 1) {
+				t.Fatalf("unexpected children: %s", rec.Body.String())
+			}
+		})
+	}
+
+	t.Run("unknown command", func(t *testing.T) {
+		rec := httptest.NewRecorder()
+		handler(rec, httptest.NewRequest(http.MethodGet, "/api/commands/group/nope", nil))
+
+		if rec.Code != http.StatusNotFound {
+			t.Fatalf("unexpected status %d: %s", rec.Code, rec.Body.String())
+		}
+
+		if !strings.Contains(rec.Body.String(), `"error": "no command named group nope"`) {
+			t.Fatalf("unexpected error body: %s", rec.Body.String())
+		}
+	})
+}
@@ -10,6 +10,9 @@ import (
 	"git.rob.mx/nidito/chinampa/pkg/errors"
 	"git.rob.mx/nidito/chinampa/pkg/logger"
 	"github.com/unrob/milpa/internal/bootstrap"
+	_c "github.com/unrob/milpa/internal/constants"
+	milpaErrors "github.com/unrob/milpa/internal/errors"
+	"github.com/unrob/milpa/internal/lookup"
 )
 
 var log = logger.Sub("documentation")
@@ -45,5 +48,39 @@ func FromQuery(query []string) ([]byte, error) {
 	}
 
 	missingPath := strings.Join(query, "/")
-	return nil, errors.BadArguments{Msg: fmt.Sprintf("Missing topic named <%s.md> or <%s/index.md> in any of %s", missingPath, missingPath, strings.Join(bootstrap.MilpaPath, ":"))}
+	msg := fmt.Sprintf("Missing topic named <%s.md> or <%s/index.md> in any of %s", missingPath, missingPath, strings.Join(bootstrap.MilpaPath, ":"))
+	suggestions := []string{}
+	for _, topic := range milpaErrors.Suggestions(missingPath, topics(), milpaErrors.MaxTypoDistance(query[len(query)-1])) {
+		suggestions = append(suggestions, "milpa help docs "+strings.ReplaceAll(topic, "/", " "))
+	}
+	if suggestion := milpaErrors.DidYouMean(suggestions); suggestion != "" {
+		msg += "; " + suggestion
+	}
+	return nil, errors.BadArguments{Msg: msg}
+}
+
+// topics lists the names of every doc in MILPA_PATH, i.e. `milpa/command/spec`.
+func topics() []string {
+	docs, err := lookup.AllDocs()
+	if err != nil {
+		log.Debugf("could not list docs: %s", err)
+		return []string{}
+	}
+
+	res := []string{}
+	for _, doc := range docs {
+		for _, path := range bootstrap.MilpaPath {
+			rel, found := strings.CutPrefix(doc, path+"/"+_c.RepoDocsFolderName+"/")
+			if !found {
+				continue
+			}
+
+			topic := strings.TrimSuffix(strings.TrimSuffix(rel, ".md"), "/index")
+			if topic != "index" {
+				res = append(res, topic)
+			}
+			break
+		}
+	}
+	return res
 }
@@ -0,0 +1,9 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright © 2021 Roberto Hidalgo <milpa@un.rob.mx>
+package docs
+
+// MarkdownToHTML exposes mdToHTML to tests.
+func MarkdownToHTML(md []byte) (string, error) {
+	html, _, err := mdToHTML(md)
+	return html.String(), err
+}
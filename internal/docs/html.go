@@ -9,13 +9,17 @@ import (
 	"html/template"
 	"net/http"
 	"os"
+	"regexp"
 	"strings"
 
 	"git.rob.mx/nidito/chinampa/pkg/command"
 	"git.rob.mx/nidito/chinampa/pkg/render"
 	chromahtml "github.com/alecthomas/chroma/v2/formatters/html"
 	"github.com/spf13/cobra"
+	"github.com/unrob/milpa/internal/bootstrap"
 	_c "github.com/unrob/milpa/internal/constants"
+	"github.com/unrob/milpa/internal/lookup"
+	"github.com/unrob/milpa/internal/repo"
 	"github.com/yuin/goldmark"
 	highlighting "github.com/yuin/goldmark-highlighting/v2"
 
@@ -38,6 +42,52 @@ type TemplateContents struct {
 	Tree           *Page
 	TOC            *Entries
 	CommandPattern string
+	LiveReload     string
+	PathPrefix     string
+	Theme          *Theme
+	// Repos are listed on the home page, for repos with a repo.yaml
+	Repos []*RepoInfo
+}
+
+// RepoInfo describes a repo with metadata on the home page.
+type RepoInfo struct {
+	Path          string
+	Name          string
+	Version       string
+	Description   string
+	Maintainers   string
+	RequiresMilpa string
+	Mount         string
+}
+
+// reposWithMetadata returns the repos among paths with a repo.yaml, or mounted under a prefix.
+func reposWithMetadata(paths []string) []*RepoInfo {
+	res := []*RepoInfo{}
+	for _, path := range paths {
+		meta, err := repo.ReadMetadata(path)
+		if err != nil {
+			log.Warnf("could not read metadata of repo %s: %s", path, err)
+			continue
+		}
+
+		mount := bootstrap.Mounts[path]
+		if meta == nil && mount == "" {
+			continue
+		} else if meta == nil {
+			meta = &repo.Metadata{}
+		}
+
+		res = append(res, &RepoInfo{
+			Path:          path,
+			Name:          meta.Name,
+			Version:       meta.Version,
+			Description:   meta.Description,
+			Maintainers:   strings.Join(meta.Maintainers, ", "),
+			RequiresMilpa: meta.RequiresMilpa,
+			Mount:         mount,
+		})
+	}
+	return res
 }
 
 func FixLinks(contents []byte) []byte {
@@ -49,8 +99,22 @@ func FixLinks(contents []byte) []byte {
 	return bytes.ReplaceAll(fixedLinks, []byte(".md#"), []byte("/#"))
 }
 
-func getHTMLLayout() (*template.Template, error) {
-	return template.New("html-help").Funcs(render.TemplateFuncs).Parse(string(LayoutTemplate))
+var rootRelativeLink = regexp.MustCompile(`(href|src)="/([^/])`)
+
+// PrefixLinks prepends prefix to every root-relative link and resource in html.
+func PrefixLinks(html []byte, prefix string) []byte {
+	if prefix == "" {
+		return html
+	}
+	return rootRelativeLink.ReplaceAll(html, []byte(`$1="`+prefix+`/$2`))
+}
+
+func getHTMLLayout(theme *Theme) (*template.Template, error) {
+	tpl, err := template.New("html-help").Funcs(render.TemplateFuncs).Parse(string(theme.Layout))
+	if err != nil && theme.LayoutPath != "" {
+		return nil, fmt.Errorf("could not parse docs layout at %s: %w", theme.LayoutPath, err)
+	}
+	return tpl, err
 }
 
 var notFoundContents = []byte("# Not found\n\nThat is weird, if you have a second and a github account, [let me know](https://github.com/unRob/milpa/issues/new?labels=docs&title=Page+not+found&template=docs-page-not-found.yml).\n")
@@ -151,7 +215,13 @@ func DevelopmentStaticResourceHandler() http.Handler {
 	return http.FileServer(http.Dir(path))
 }
 
-func RenderHandler(serverAddr string) func(http.ResponseWriter, *http.Request) {
+func RenderHandler(cfg ServerConfig) func(http.ResponseWriter, *http.Request) {
+	reloadPath := ""
+	if cfg.Watch {
+		reloadPath = cfg.PathPrefix + ReloadPath
+	}
+	serverAddr := cfg.URL()
+
 	return func(w http.ResponseWriter, r *http.Request) {
 		if strings.HasSuffix(r.URL.Path, ".ico") {
 			w.WriteHeader(http.StatusNotFound)
@@ -166,7 +236,9 @@ func RenderHandler(serverAddr string) func(http.ResponseWriter, *http.Request) {
 
 		log.Infof("Handling request for: %s", comps)
 
+		lookup.TreeMutex.Lock()
 		contents, desc, err := contentsForRequest(comps)
+		lookup.TreeMutex.Unlock()
 		if err != nil {
 			log.Errorf("404: %s", comps)
 			w.WriteHeader(http.StatusNotFound)
@@ -179,7 +251,9 @@ func RenderHandler(serverAddr string) func(http.ResponseWriter, *http.Request) {
 			return
 		}
 
-		tpl, err := getHTMLLayout()
+		// themes are loaded on every request so changes show up without a restart
+		theme := LoadTheme(cfg.Repos)
+		tpl, err := getHTMLLayout(theme)
 		if err != nil {
 			log.Error(err)
 			w.WriteHeader(http.StatusInternalServerError)
@@ -192,17 +266,26 @@ func RenderHandler(serverAddr string) func(http.ResponseWriter, *http.Request) {
 			w.WriteHeader(http.StatusInternalServerError)
 			return
 		}
+		var repos []*RepoInfo
+		if len(comps) == 0 {
+			repos = reposWithMetadata(cfg.Repos)
+		}
+
 		var pageHTML bytes.Buffer
 		err = tpl.Execute(&pageHTML, &TemplateContents{
 			Base:           serverAddr,
 			IsHome:         len(comps) == 0,
 			RelPermalink:   "/" + prefix,
 			Permalink:      serverAddr + "/" + prefix,
-			Content:        template.HTML(md.String()), // nolint:gosec
+			Content:        template.HTML(PrefixLinks(md.Bytes(), cfg.PathPrefix)), // nolint:gosec
 			Description:    desc,
 			Tree:           pageTree,
 			TOC:            toc,
 			CommandPattern: cp,
+			LiveReload:     reloadPath,
+			PathPrefix:     cfg.PathPrefix,
+			Theme:          theme,
+			Repos:          repos,
 		})
 
 		if err != nil {
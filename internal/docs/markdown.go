@@ -165,6 +165,53 @@ func (t *tocTransformer) Transform(doc *ast.Document, reader text.Reader, _ pars
 	}
 }
 
+// DiagramLanguages lists the fenced code block languages rendered as
+// diagrams by the browser, instead of as highlighted source code.
+var DiagramLanguages = map[string]bool{
+	"mermaid": true,
+	"dot":     true,
+}
+
+// KindDiagram is the ast.NodeKind of diagram blocks.
+var KindDiagram = ast.NewNodeKind("Diagram")
+
+// diagramBlock holds the source of a fenced code block in one of DiagramLanguages.
+type diagramBlock struct {
+	ast.BaseBlock
+	Language string
+}
+
+func (n *diagramBlock) Kind() ast.NodeKind { return KindDiagram }
+func (n *diagramBlock) IsRaw() bool        { return true }
+func (n *diagramBlock) Dump(source []byte, level int) {
+	ast.DumpHelper(n, source, level, map[string]string{"Language": n.Language}, nil)
+}
+
+type diagramTransformer struct{}
+
+var _ parser.ASTTransformer = &diagramTransformer{}
+
+func (t *diagramTransformer) Transform(doc *ast.Document, reader text.Reader, _ parser.Context) {
+	src := reader.Source()
+	fences := []*ast.FencedCodeBlock{}
+	err := ast.Walk(doc, func(n ast.Node, entering bool) (ast.WalkStatus, error) {
+		if fence, ok := n.(*ast.FencedCodeBlock); ok && entering && DiagramLanguages[string(fence.Language(src))] {
+			fences = append(fences, fence)
+		}
+		return ast.WalkContinue, nil
+	})
+	if err != nil {
+		log.Errorf("error walking ast: %s", err)
+	}
+
+	// replace after walking, so the walk doesn't trip on the modified tree
+	for _, fence := range fences {
+		diagram := &diagramBlock{Language: string(fence.Language(src))}
+		diagram.SetLines(fence.Lines())
+		fence.Parent().ReplaceChild(fence.Parent(), fence, diagram)
+	}
+}
+
 type milpaRenderer struct {
 	html.Config
 	me *milpaExtension
@@ -176,6 +223,26 @@ func (r *milpaRenderer) SetOption(name renderer.OptionName, value any) {
 
 func (r *milpaRenderer) RegisterFuncs(reg renderer.NodeRendererFuncRegisterer) {
 	reg.Register(ast.KindHeading, r.renderHeading)
+	reg.Register(KindDiagram, r.renderDiagram)
+}
+
+func (r *milpaRenderer) renderDiagram(w util.BufWriter, source []byte, node ast.Node, entering bool) (ast.WalkStatus, error) {
+	if !entering {
+		return ast.WalkContinue, nil
+	}
+
+	diagram := node.(*diagramBlock)
+	// the source stays readable if the renderer can't be loaded
+	if _, err := fmt.Fprintf(w, `<pre class="diagram" data-diagram="%s">`, diagram.Language); err != nil {
+		return ast.WalkStop, err
+	}
+	lines := diagram.Lines()
+	for i := 0; i < lines.Len(); i++ {
+		line := lines.At(i)
+		r.Writer.RawWrite(w, line.Value(source))
+	}
+	_, err := w.WriteString("</pre>\n")
+	return ast.WalkSkipChildren, err
 }
 
 func (r *milpaRenderer) renderHeading(w util.BufWriter, source []byte, node ast.Node, entering bool) (ast.WalkStatus, error) {
@@ -228,6 +295,7 @@ func (me *milpaExtension) Extend(m goldmark.Markdown) {
 		parser.WithAutoHeadingID(),
 		parser.WithASTTransformers(
 			util.Prioritized(me.TOC, 100),
+			util.Prioritized(&diagramTransformer{}, 200),
 		),
 	)
 }
@@ -377,6 +445,7 @@ func buildSiteTree() (*Page, string, error) {
 	}
 
 	// Add commands to tree
+	lookup.TreeMutex.Lock()
 	tree.Build(command.Root.Cobra.Root(), 20)
 	_, err := tree.Serialize(func(t interface{}) ([]byte, error) {
 		tree := t.(*tree.CommandTree)
@@ -384,6 +453,7 @@ func buildSiteTree() (*Page, string, error) {
 		log.Debugf("Found %d commands", len(names))
 		return nil, err
 	})
+	lookup.TreeMutex.Unlock()
 	if err != nil {
 		log.Errorf("could not build command tree: %s", err)
 		return nil, "", err
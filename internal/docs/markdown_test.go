@@ -0,0 +1,72 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright © 2021 Roberto Hidalgo <milpa@un.rob.mx>
+package docs_test
+
+import (
+	"strings"
+	"testing"
+
+	. "github.com/unrob/milpa/internal/docs"
+)
+
+func TestDiagrams(t *testing.T) {
+	cases := []struct {
+		name     string
+		md       string
+		expected []string
+		missing  []string
+	}{
+		{
+			name:     "mermaid",
+			md:       "# title\n\n```mermaid\ngraph TD;\n  A-->B;\n```\n",
+			expected: []string{"<pre class=\"diagram\" data-diagram=\"mermaid\">graph TD;\n  A--&gt;B;\n</pre>\n"},
+			missing:  []string{"<code", "chroma"},
+		},
+		{
+			name:     "dot",
+			md:       "```dot\ndigraph { a -> b }\n```\n",
+			expected: []string{"<pre class=\"diagram\" data-diagram=\"dot\">digraph { a -&gt; b }\n</pre>\n"},
+		},
+		{
+			name: "nested and repeated",
+			md:   "- item\n\n  ```dot\n  digraph { a }\n  ```\n\n> quoted\n>\n> ```mermaid\n> pie\n> ```\n",
+			expected: []string{
+				"<pre class=\"diagram\" data-diagram=\"dot\">digraph { a }\n</pre>",
+				"<pre class=\"diagram\" data-diagram=\"mermaid\">pie\n</pre>",
+			},
+		},
+		{
+			name:     "other languages",
+			md:       "```bash\necho \"a -> b\"\n```\n",
+			expected: []string{"chroma"},
+			missing:  []string{"diagram"},
+		},
+		{
+			name:     "no language",
+			md:       "```\ngraph TD;\n```\n",
+			expected: []string{"<pre><code>graph TD;\n</code></pre>"},
+			missing:  []string{"diagram"},
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			html, err := MarkdownToHTML([]byte(c.md))
+			if err != nil {
+				t.Fatalf("could not render: %s", err)
+			}
+
+			for _, expected := range c.expected {
+				if !strings.Contains(html, expected) {
+					t.Errorf("missing %q in:\n%s", expected, html)
+				}
+			}
+
+			for _, missing := range c.missing {
+				if strings.Contains(html, missing) {
+					t.Errorf("unexpected %q in:\n%s", missing, html)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,165 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright © 2021 Roberto Hidalgo <milpa@un.rob.mx>
+package docs
+
+import (
+	"context"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	_c "github.com/unrob/milpa/internal/constants"
+)
+
+// ReloadPath is where browsers subscribe to live-reload events.
+const ReloadPath = "/__reload"
+
+// Reloader keeps track of open browser tabs and notifies them of changes
+// through server-sent events.
+type Reloader struct {
+	clients map[chan string]bool
+	mutex   sync.Mutex
+}
+
+func NewReloader() *Reloader {
+	return &Reloader{
+		clients: map[chan string]bool{},
+	}
+}
+
+// Broadcast asks every connected client to reload.
+func (rl *Reloader) Broadcast() {
+	rl.mutex.Lock()
+	defer rl.mutex.Unlock()
+	log.Debugf("notifying %d clients of changes", len(rl.clients))
+	for client := range rl.clients {
+		select {
+		case client <- "reload":
+		default:
+			// client already has a pending reload
+		}
+	}
+}
+
+func (rl *Reloader) subscribe() chan string {
+	rl.mutex.Lock()
+	defer rl.mutex.Unlock()
+	client := make(chan string, 1)
+	rl.clients[client] = true
+	return client
+}
+
+func (rl *Reloader) unsubscribe(client chan string) {
+	rl.mutex.Lock()
+	defer rl.mutex.Unlock()
+	delete(rl.clients, client)
+}
+
+func (rl *Reloader) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		w.WriteHeader(http.StatusNotImplemented)
+		return
+	}
+
+	w.Header().Set("content-type", "text/event-stream")
+	w.Header().Set("cache-control", "no-cache")
+	w.Header().Set("connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	client := rl.subscribe()
+	defer rl.unsubscribe(client)
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case event := <-client:
+			if _, err := fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event, r.URL.Path); err != nil {
+				log.Debugf("could not notify client: %s", err)
+				return
+			}
+			flusher.Flush()
+		}
+	}
+}
+
+type fileStamp struct {
+	modTime time.Time
+	size    int64
+}
+
+func (stamp fileStamp) equal(other fileStamp) bool {
+	return stamp.size == other.size && stamp.modTime.Equal(other.modTime)
+}
+
+// snapshot records the modification time of every command spec and doc in repos.
+func snapshot(repos []string) map[string]fileStamp {
+	files := map[string]fileStamp{}
+	for _, repo := range repos {
+		for _, folder := range []string{_c.RepoCommandFolderName, _c.RepoDocsFolderName} {
+			err := filepath.WalkDir(filepath.Join(repo, folder), func(path string, d fs.DirEntry, err error) error {
+				if err != nil || d.IsDir() {
+					return nil
+				}
+
+				if ext := filepath.Ext(path); ext != ".md" && ext != ".yaml" {
+					return nil
+				}
+
+				if info, err := d.Info(); err == nil {
+					files[path] = fileStamp{modTime: info.ModTime(), size: info.Size()}
+				}
+				return nil
+			})
+			if err != nil {
+				log.Debugf("could not walk %s/%s: %s", repo, folder, err)
+			}
+		}
+	}
+	return files
+}
+
+// Watch polls repos every interval, calling onChange with the sorted list of
+// `.md` and `.yaml` files that were created, modified or removed.
+func Watch(ctx context.Context, repos []string, interval time.Duration, onChange func(changed []string)) {
+	previous := snapshot(repos)
+	log.Debugf("watching %d files for changes", len(previous))
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			current := snapshot(repos)
+			changed := []string{}
+			for path, stamp := range current {
+				if old, exists := previous[path]; !exists || !old.equal(stamp) {
+					changed = append(changed, path)
+				}
+			}
+
+			for path := range previous {
+				if _, exists := current[path]; !exists {
+					changed = append(changed, path)
+				}
+			}
+
+			previous = current
+			if len(changed) == 0 {
+				continue
+			}
+
+			sort.Strings(changed)
+			log.Infof("Detected changes to %d files", len(changed))
+			onChange(changed)
+		}
+	}
+}
@@ -0,0 +1,79 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright © 2021 Roberto Hidalgo <milpa@un.rob.mx>
+package docs_test
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+
+	. "github.com/unrob/milpa/internal/docs"
+)
+
+// waitForChanges collects batches of changes until count files are reported,
+// since writes may be spread across polling intervals.
+func waitForChanges(changes chan []string, count int) []string {
+	found := []string{}
+	timeout := time.After(time.Second)
+	for len(found) < count {
+		select {
+		case changed := <-changes:
+			found = append(found, changed...)
+		case <-timeout:
+			return found
+		}
+	}
+	sort.Strings(found)
+	return found
+}
+
+func TestWatch(t *testing.T) {
+	repo := t.TempDir()
+	for _, dir := range []string{"commands", "docs"} {
+		if err := os.MkdirAll(filepath.Join(repo, dir), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	spec := filepath.Join(repo, "commands", "existing.yaml")
+	if err := os.WriteFile(spec, []byte("summary: before"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	changes := make(chan []string, 10)
+	go Watch(ctx, []string{repo}, 10*time.Millisecond, func(changed []string) {
+		changes <- changed
+	})
+	// give the watcher a chance to take its first snapshot
+	time.Sleep(20 * time.Millisecond)
+
+	doc := filepath.Join(repo, "docs", "new.md")
+	if err := os.WriteFile(doc, []byte("# new"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(spec, []byte("summary: after, and longer"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(repo, "commands", "existing.sh"), []byte("echo ignored"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []string{spec, doc}
+	if changed := waitForChanges(changes, len(expected)); !reflect.DeepEqual(changed, expected) {
+		t.Fatalf("unexpected changes, wanted %v, got %v", expected, changed)
+	}
+
+	if err := os.Remove(doc); err != nil {
+		t.Fatal(err)
+	}
+
+	expected = []string{doc}
+	if changed := waitForChanges(changes, len(expected)); !reflect.DeepEqual(changed, expected) {
+		t.Fatalf("unexpected changes after removal, wanted %v, got %v", expected, changed)
+	}
+}
@@ -0,0 +1,143 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright © 2021 Roberto Hidalgo <milpa@un.rob.mx>
+package docs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/unrob/milpa/internal/lookup"
+)
+
+// UnixSocketPrefix marks a listen address as a path to a unix socket.
+const UnixSocketPrefix = "unix:"
+
+// ServerConfig holds the settings for the docs http server.
+type ServerConfig struct {
+	// Listen is either a host:port pair, or a path to a unix socket prefixed with `unix:`
+	Listen string
+	// Base is the public URL of the server, excluding PathPrefix
+	Base string
+	// PathPrefix is prepended to every route, link and static resource
+	PathPrefix string
+	// TLSCert and TLSKey are paths to a PEM-encoded certificate and key to serve over https
+	TLSCert string
+	TLSKey  string
+	// Watch enables reloading commands and browser tabs whenever Repos change
+	Watch bool
+	Repos []string
+}
+
+// NormalizePathPrefix returns prefix with a single leading slash and no trailing slash.
+func NormalizePathPrefix(prefix string) string {
+	prefix = strings.Trim(prefix, "/")
+	if prefix == "" {
+		return ""
+	}
+	return "/" + prefix
+}
+
+// URL returns the public address of the server, including the path prefix.
+func (cfg ServerConfig) URL() string {
+	return strings.TrimSuffix(cfg.Base, "/") + cfg.PathPrefix
+}
+
+func (cfg ServerConfig) listener() (net.Listener, error) {
+	if !strings.HasPrefix(cfg.Listen, UnixSocketPrefix) {
+		return net.Listen("tcp", cfg.Listen)
+	}
+
+	socket := strings.TrimPrefix(cfg.Listen, UnixSocketPrefix)
+	if fi, err := os.Stat(socket); err == nil {
+		if fi.Mode()&os.ModeSocket == 0 {
+			return nil, fmt.Errorf("refusing to replace non-socket file at %s", socket)
+		}
+		// a previous server did not clean up after itself
+		log.Debugf("removing stale socket at %s", socket)
+		if err := os.Remove(socket); err != nil {
+			return nil, err
+		}
+	}
+	return net.Listen("unix", socket)
+}
+
+// Handler returns the docs server routes, mounted at cfg.PathPrefix.
+func Handler(ctx context.Context, cfg ServerConfig) http.Handler {
+	mux := http.NewServeMux()
+	// Replace with DevelopmentStaticResourceHandler to use locally available
+	// static resources during development
+	mux.Handle("/static/", EmbeddedStaticResourceHandler())
+	mux.Handle(ThemePath, ThemeResourceHandler(cfg.Repos))
+	mux.HandleFunc(APIPath, APIHandler())
+	mux.HandleFunc("/", RenderHandler(cfg))
+
+	if cfg.Watch {
+		reloader := NewReloader()
+		mux.Handle(ReloadPath, reloader)
+		go Watch(ctx, cfg.Repos, time.Second, func(changed []string) {
+			lookup.Refresh(changed)
+			reloader.Broadcast()
+		})
+	}
+
+	if cfg.PathPrefix == "" {
+		return mux
+	}
+
+	root := http.NewServeMux()
+	root.Handle(cfg.PathPrefix+"/", http.StripPrefix(cfg.PathPrefix, mux))
+	root.Handle(cfg.PathPrefix, http.RedirectHandler(cfg.PathPrefix+"/", http.StatusMovedPermanently))
+	return root
+}
+
+// Serve runs the docs server until ctx is done, then shuts it down gracefully.
+func Serve(ctx context.Context, cfg ServerConfig) error {
+	if (cfg.TLSCert == "") != (cfg.TLSKey == "") {
+		return fmt.Errorf("both a TLS certificate and key are required to serve over https")
+	}
+
+	listener, err := cfg.listener()
+	if err != nil {
+		return err
+	}
+
+	server := &http.Server{
+		Handler:           Handler(ctx, cfg),
+		ReadHeaderTimeout: 3 * time.Second,
+		// cancels long-lived requests, like live-reload streams, on shutdown
+		BaseContext: func(net.Listener) context.Context { return ctx },
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		if cfg.TLSCert != "" {
+			done <- server.ServeTLS(listener, cfg.TLSCert, cfg.TLSKey)
+		} else {
+			done <- server.Serve(listener)
+		}
+	}()
+
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+	}
+
+	log.Info("Shutting down docs server")
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		return err
+	}
+
+	if err := <-done; err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,194 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright © 2021 Roberto Hidalgo <milpa@un.rob.mx>
+package docs_test
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	. "github.com/unrob/milpa/internal/docs"
+)
+
+func TestNormalizePathPrefix(t *testing.T) {
+	cases := map[string]string{
+		"":              "",
+		"/":             "",
+		"tools/milpa":   "/tools/milpa",
+		"/tools/milpa/": "/tools/milpa",
+		"//milpa":       "/milpa",
+	}
+
+	for prefix, expected := range cases {
+		if got := NormalizePathPrefix(prefix); got != expected {
+			t.Errorf("unexpected prefix for %q, wanted %q, got %q", prefix, expected, got)
+		}
+	}
+}
+
+func TestPrefixLinks(t *testing.T) {
+	html := []byte(`<a href="/help/docs/">docs</a> <img src="/static/ogp.jpg"> <a href="//example.com/">external</a> <a href="#top">top</a>`)
+	expected := `<a href="/tools/milpa/help/docs/">docs</a> <img src="/tools/milpa/static/ogp.jpg"> <a href="//example.com/">external</a> <a href="#top">top</a>`
+
+	if got := string(PrefixLinks(html, "/tools/milpa")); got != expected {
+		t.Fatalf("unexpected links:\nwanted %s\ngot    %s", expected, got)
+	}
+
+	if got := string(PrefixLinks(html, "")); got != string(html) {
+		t.Fatalf("links changed without a prefix: %s", got)
+	}
+}
+
+func TestHandlerPathPrefix(t *testing.T) {
+	handler := Handler(context.Background(), ServerConfig{PathPrefix: "/tools/milpa"})
+
+	cases := map[string]int{
+		"/tools/milpa/static/css/index.css": http.StatusOK,
+		"/tools/milpa":                      http.StatusMovedPermanently,
+		"/static/css/index.css":             http.StatusNotFound,
+	}
+
+	for path, status := range cases {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+		if rec.Code != status {
+			t.Errorf("unexpected status for %s, wanted %d, got %d", path, status, rec.Code)
+		}
+	}
+}
+
+func TestServeUnixSocket(t *testing.T) {
+	socket := filepath.Join(t.TempDir(), "docs.sock")
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() {
+		done <- Serve(ctx, ServerConfig{Listen: UnixSocketPrefix + socket})
+	}()
+
+	client := &http.Client{
+		Transport: &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				return (&net.Dialer{}).DialContext(ctx, "unix", socket)
+			},
+		},
+	}
+
+	var res *http.Response
+	var err error
+	for tries := 0; tries < 20; tries++ {
+		if res, err = client.Get("http://milpa/static/css/index.css"); err == nil {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if err != nil {
+		t.Fatalf("could not reach server over unix socket: %s", err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("unexpected status: %d", res.StatusCode)
+	}
+
+	cancel()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("server did not shut down cleanly: %s", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("server did not shut down")
+	}
+
+	if _, err := os.Stat(socket); !os.IsNotExist(err) {
+		t.Fatalf("socket was not removed on shutdown: %v", err)
+	}
+}
+
+func TestServeRequiresTLSPair(t *testing.T) {
+	err := Serve(context.Background(), ServerConfig{Listen: "localhost:0", TLSCert: "cert.pem"})
+	if err == nil {
+		t.Fatal("serving with a certificate but no key did not fail")
+	}
+}
+
+func writeTemplateFiles(t *testing.T, files map[string]string) string {
+	t.Helper()
+	repo := t.TempDir()
+	folder := filepath.Join(repo, "docs", ".template")
+	if err := os.MkdirAll(folder, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	for name, contents := range files {
+		if err := os.WriteFile(filepath.Join(folder, name), []byte(contents), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return repo
+}
+
+func TestLoadTheme(t *testing.T) {
+	first := writeTemplateFiles(t, map[string]string{
+		"site.yaml":  "title: infra",
+		"shared.css": "body {}",
+	})
+	second := writeTemplateFiles(t, map[string]string{
+		"site.yaml":     "title: ignored\nlogo: logo.svg",
+		"template.html": "<p>{{ .Theme.Title }}</p>",
+		"shared.css":    "main {}",
+		"extra.js":      "",
+		"logo.svg":      "<svg></svg>",
+	})
+
+	theme := LoadTheme([]string{first, second})
+	if theme.Title != "infra" {
+		t.Errorf("unexpected title: %s", theme.Title)
+	}
+
+	if theme.Logo != ThemePath+"logo.svg" {
+		t.Errorf("unexpected logo: %s", theme.Logo)
+	}
+
+	if expected := []string{ThemePath + "shared.css"}; !reflect.DeepEqual(theme.Styles, expected) {
+		t.Errorf("unexpected styles: %v", theme.Styles)
+	}
+
+	if expected := []string{ThemePath + "extra.js"}; !reflect.DeepEqual(theme.Scripts, expected) {
+		t.Errorf("unexpected scripts: %v", theme.Scripts)
+	}
+
+	if theme.LayoutPath != filepath.Join(second, "docs", ".template", "template.html") {
+		t.Errorf("unexpected layout path: %s", theme.LayoutPath)
+	}
+
+	if defaults := LoadTheme([]string{t.TempDir()}); !reflect.DeepEqual(defaults.Layout, LayoutTemplate) || defaults.Title != "" {
+		t.Errorf("unexpected theme without template folders: %+v", defaults)
+	}
+
+	handler := ThemeResourceHandler([]string{first, second})
+	cases := map[string]string{
+		ThemePath + "shared.css":       "body {}",
+		ThemePath + "logo.svg":         "<svg></svg>",
+		ThemePath + "site.yaml":        "",
+		ThemePath + "../../secret.txt": "",
+	}
+	for path, expected := range cases {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+		if expected == "" {
+			if rec.Code != http.StatusNotFound {
+				t.Errorf("expected %s to be missing, got %d", path, rec.Code)
+			}
+			continue
+		}
+
+		if rec.Code != http.StatusOK || rec.Body.String() != expected {
+			t.Errorf("unexpected response for %s (%d): %s", path, rec.Code, rec.Body.String())
+		}
+	}
+}
@@ -4,6 +4,7 @@
   const allCommands = Array.from(document.querySelectorAll("#milpa-commands option")).map((opt) => opt.value)
   const commandSelector = document.querySelector("#command-selector")
   const initialValue = commandSelector.value
+  const pathPrefix = document.body.dataset.pathPrefix || ""
 
   commandSelector.addEventListener("keydown", function(evt){
     let cmd = this.value
@@ -16,7 +17,7 @@
   function navigateOnChange(input) {
     let cmd = input.value
     if (cmd != initialValue && allCommands.includes(cmd)) {
-      window.location = `/${cmd.replaceAll(" ", "/")}/`
+      window.location = `${pathPrefix}/${cmd.replaceAll(" ", "/")}/`
     } else {
       return true
     }
@@ -58,3 +59,78 @@
     return false
   }))
 })();
+
+(function liveReload () {
+  const endpoint = document.body.dataset.liveReload
+  if (!endpoint) {
+    return
+  }
+
+  const events = new EventSource(endpoint)
+  events.addEventListener("reload", function() {
+    window.location.reload()
+  })
+})();
+
+(function renderDiagrams () {
+  const diagrams = Array.from(document.querySelectorAll("pre.diagram"))
+  if (diagrams.length == 0) {
+    return
+  }
+
+  const pathPrefix = document.body.dataset.pathPrefix || ""
+  const dark = window.matchMedia("(prefers-color-scheme: dark)").matches
+  const renderers = {
+    mermaid: {
+      script: "mermaid.min.js",
+      render: async (source, idx) => {
+        window.mermaid.initialize({ startOnLoad: false, theme: dark ? "dark" : "default" })
+        return (await window.mermaid.render(`diagram-${idx}`, source)).svg
+      },
+    },
+    dot: {
+      script: "viz-standalone.js",
+      render: async (source) => {
+        const viz = await window.Viz.instance()
+        return viz.renderString(source, { format: "svg" })
+      },
+    },
+  }
+
+  function inject (src) {
+    return new Promise((resolve, reject) => {
+      const tag = document.createElement("script")
+      tag.src = src
+      tag.onload = resolve
+      tag.onerror = reject
+      document.head.appendChild(tag)
+    })
+  }
+
+  const loaded = {}
+  function load (renderer) {
+    if (!loaded[renderer.script]) {
+      // builds without vendored renderers leave diagrams as source
+      loaded[renderer.script] = inject(`${pathPrefix}/static/js/vendor/${renderer.script}`)
+    }
+    return loaded[renderer.script]
+  }
+
+  diagrams.forEach(async (pre, idx) => {
+    const renderer = renderers[pre.dataset.diagram]
+    if (!renderer) {
+      return
+    }
+
+    try {
+      await load(renderer)
+      const figure = document.createElement("figure")
+      figure.className = "diagram"
+      figure.innerHTML = await renderer.render(pre.textContent, idx)
+      pre.replaceWith(figure)
+    } catch (err) {
+      // leave the source in place so it can still be read
+      console.error(`could not render ${pre.dataset.diagram} diagram`, err)
+    }
+  })
+})()
@@ -0,0 +1,126 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright © 2021 Roberto Hidalgo <milpa@un.rob.mx>
+package docs
+
+import (
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	_c "github.com/unrob/milpa/internal/constants"
+	"gopkg.in/yaml.v3"
+)
+
+// ThemePath is where files from repos' docs template folders are served from.
+const ThemePath = "/static/theme/"
+
+// Theme customizes the docs server's look, based on the contents of
+// `.milpa/docs/.template` folders. For every setting, the first repo in
+// MILPA_PATH that provides it wins, falling back to milpa's built-in layout.
+type Theme struct {
+	// Title replaces "milpa" as the site's name
+	Title string `yaml:"title"`
+	// Logo is either a URL or the name of a file in the template folder
+	Logo string `yaml:"logo"`
+	// Styles and Scripts are the names of css and js files found in template folders
+	Styles  []string `yaml:"-"`
+	Scripts []string `yaml:"-"`
+	// Layout holds the contents of the html template to render pages with
+	Layout []byte `yaml:"-"`
+	// LayoutPath is the path Layout was read from, if not the built-in one
+	LayoutPath string `yaml:"-"`
+}
+
+func templateFolder(repo string) string {
+	return filepath.Join(repo, _c.RepoDocsFolderName, _c.RepoDocsTemplateFolderName)
+}
+
+// LoadTheme reads the docs template folders of repos, in order of precedence.
+func LoadTheme(repos []string) *Theme {
+	theme := &Theme{Layout: LayoutTemplate}
+	assets := map[string]bool{}
+
+	for _, repo := range repos {
+		folder := templateFolder(repo)
+		files, err := os.ReadDir(folder)
+		if err != nil {
+			continue
+		}
+		log.Debugf("found docs template folder at %s", folder)
+
+		for _, file := range files {
+			name := file.Name()
+			if file.IsDir() {
+				continue
+			}
+
+			switch {
+			case name == _c.RepoDocsTemplateLayoutName && theme.LayoutPath == "":
+				layoutPath := filepath.Join(folder, name)
+				contents, err := os.ReadFile(layoutPath) // nolint: gosec
+				if err != nil {
+					log.Warnf("could not read docs layout at %s: %s", layoutPath, err)
+					continue
+				}
+				theme.Layout = contents
+				theme.LayoutPath = layoutPath
+			case name == _c.RepoDocsTemplateConfigName:
+				site := &Theme{}
+				contents, err := os.ReadFile(filepath.Join(folder, name)) // nolint: gosec
+				if err == nil {
+					err = yaml.Unmarshal(contents, site)
+				}
+				if err != nil {
+					log.Warnf("could not read docs site config at %s: %s", folder, err)
+					continue
+				}
+
+				if theme.Title == "" {
+					theme.Title = site.Title
+				}
+
+				if theme.Logo == "" && site.Logo != "" {
+					theme.Logo = site.Logo
+					if !strings.Contains(site.Logo, "://") {
+						theme.Logo = ThemePath + site.Logo
+					}
+				}
+			case (strings.HasSuffix(name, ".css") || strings.HasSuffix(name, ".js")) && !assets[name]:
+				assets[name] = true
+				if strings.HasSuffix(name, ".css") {
+					theme.Styles = append(theme.Styles, ThemePath+name)
+				} else {
+					theme.Scripts = append(theme.Scripts, ThemePath+name)
+				}
+			}
+		}
+	}
+
+	sort.Strings(theme.Styles)
+	sort.Strings(theme.Scripts)
+	return theme
+}
+
+// ThemeResourceHandler serves files from the first docs template folder in repos that has them.
+func ThemeResourceHandler(repos []string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		name := path.Clean("/" + strings.TrimPrefix(r.URL.Path, ThemePath))
+		if name == "/" || name == "/"+_c.RepoDocsTemplateConfigName || name == "/"+_c.RepoDocsTemplateLayoutName {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+
+		for _, repo := range repos {
+			candidate := filepath.Join(templateFolder(repo), filepath.FromSlash(name))
+			if fi, err := os.Stat(candidate); err == nil && fi.Mode().IsRegular() {
+				http.ServeFile(w, r, candidate)
+				return
+			}
+		}
+
+		w.WriteHeader(http.StatusNotFound)
+	})
+}
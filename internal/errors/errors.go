@@ -5,14 +5,19 @@ package errors
 import (
 	"fmt"
 	"os"
+	"regexp"
 	"strings"
 
 	"git.rob.mx/nidito/chinampa/pkg/errors"
 	"git.rob.mx/nidito/chinampa/pkg/statuscode"
 	"github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
 )
 
+// StatusBreakingChanges is the exit code for command tree diffs with breaking changes.
+const StatusBreakingChanges = 3
+
 type ConfigError struct {
 	Err    error
 	Config string
@@ -22,6 +27,15 @@ type EnvironmentError struct {
 	Err error
 }
 
+// BreakingChangesError signals a command tree diff found breaking changes.
+type BreakingChangesError struct {
+	Count int
+}
+
+func (err BreakingChangesError) Error() string {
+	return fmt.Sprintf("found %d breaking changes", err.Count)
+}
+
 func (err ConfigError) Error() string {
 	if err.Config != "" {
 		return fmt.Sprintf("Invalid configuration %s: %v", err.Config, err.Err)
@@ -43,6 +57,58 @@ func showHelp(cmd *cobra.Command) {
 	}
 }
 
+var unknownCommand = regexp.MustCompile(`^(?:unknown command "([^"]+)" for|Unknown subcommand (\S+))`)
+
+// commandSuggestion returns a hint for an unknown command, looking for similar commands across the tree.
+func commandSuggestion(cmd *cobra.Command, err error) string {
+	match := unknownCommand.FindStringSubmatch(err.Error())
+	if cmd == nil || match == nil {
+		return ""
+	}
+
+	word := match[1] + match[2]
+	attempted := cmd.CommandPath() + " " + word
+	candidates := []string{}
+	var walk func(c *cobra.Command)
+	walk = func(c *cobra.Command) {
+		for _, child := range c.Commands() {
+			if !child.IsAvailableCommand() {
+				continue
+			}
+			candidates = append(candidates, child.CommandPath())
+			walk(child)
+		}
+	}
+	walk(cmd.Root())
+
+	suggestion := DidYouMean(Suggestions(attempted, candidates, MaxTypoDistance(word)))
+	if suggestion == "" {
+		return ""
+	}
+	return fmt.Sprintf("no command `%s`; %s", attempted, suggestion)
+}
+
+// flagSuggestion returns a hint for an unknown flag, looking for similar options of cmd.
+func flagSuggestion(cmd *cobra.Command, err error) string {
+	attempted, found := strings.CutPrefix(err.Error(), "unknown flag: ")
+	if cmd == nil || !found {
+		return ""
+	}
+
+	candidates := []string{}
+	cmd.Flags().VisitAll(func(f *pflag.Flag) {
+		if !f.Hidden {
+			candidates = append(candidates, "--"+f.Name)
+		}
+	})
+
+	suggestion := DidYouMean(Suggestions(attempted, candidates, MaxTypoDistance(attempted)))
+	if suggestion == "" {
+		return ""
+	}
+	return fmt.Sprintf("no option `%s` for `%s`; %s", attempted, cmd.CommandPath(), suggestion)
+}
+
 func HandleExit(cmd *cobra.Command, err error) error {
 	if err == nil {
 		ok, err := cmd.Flags().GetBool("help")
@@ -61,6 +127,9 @@ func HandleExit(cmd *cobra.Command, err error) error {
 	case errors.NotFound:
 		showHelp(cmd)
 		logrus.Error(err)
+		if suggestion := commandSuggestion(cmd, err); suggestion != "" {
+			logrus.Error(suggestion)
+		}
 		os.Exit(statuscode.NotFound)
 	case ConfigError:
 		logrus.Info("run `milpa itself doctor` to diagnose your command")
@@ -69,13 +138,22 @@ func HandleExit(cmd *cobra.Command, err error) error {
 	case EnvironmentError:
 		logrus.Error(err)
 		os.Exit(statuscode.ConfigError)
+	case BreakingChangesError:
+		logrus.Error(err)
+		os.Exit(StatusBreakingChanges)
 	default:
 		if strings.HasPrefix(err.Error(), "unknown command") {
 			showHelp(cmd)
+			if suggestion := commandSuggestion(cmd, err); suggestion != "" {
+				logrus.Error(suggestion)
+			}
 			os.Exit(statuscode.NotFound)
 		} else if strings.HasPrefix(err.Error(), "unknown flag") || strings.HasPrefix(err.Error(), "unknown shorthand flag") {
 			showHelp(cmd)
 			logrus.Error(err)
+			if suggestion := flagSuggestion(cmd, err); suggestion != "" {
+				logrus.Error(suggestion)
+			}
 			os.Exit(statuscode.Usage)
 		}
 	}
@@ -0,0 +1,101 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright © 2021 Roberto Hidalgo <milpa@un.rob.mx>
+package errors
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// maxSuggestions is the most suggestions offered for a single typo.
+const maxSuggestions = 3
+
+// distance returns the number of insertions, deletions, substitutions and
+// transpositions of adjacent characters needed to turn a into b.
+func distance(a, b string) int {
+	ra, rb := []rune(a), []rune(b)
+	rows := make([][]int, len(ra)+1)
+	for i := range rows {
+		rows[i] = make([]int, len(rb)+1)
+		rows[i][0] = i
+	}
+	for j := range rows[0] {
+		rows[0][j] = j
+	}
+
+	for i := 1; i <= len(ra); i++ {
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+
+			rows[i][j] = min(rows[i-1][j]+1, rows[i][j-1]+1, rows[i-1][j-1]+cost)
+			if i > 1 && j > 1 && ra[i-1] == rb[j-2] && ra[i-2] == rb[j-1] {
+				rows[i][j] = min(rows[i][j], rows[i-2][j-2]+1)
+			}
+		}
+	}
+
+	return rows[len(ra)][len(rb)]
+}
+
+// MaxTypoDistance returns how different a word may be from a suggestion for it, between one and three edits.
+func MaxTypoDistance(word string) int {
+	return max(1, min(3, len([]rune(word))/3))
+}
+
+// Suggestions returns up to three candidates at most maxDistance edits away from needle, closest first.
+func Suggestions(needle string, candidates []string, maxDistance int) []string {
+	type scored struct {
+		value    string
+		distance int
+	}
+
+	matches := []scored{}
+	seen := map[string]bool{}
+	for _, candidate := range candidates {
+		if seen[candidate] || candidate == needle {
+			continue
+		}
+		seen[candidate] = true
+
+		if d := distance(strings.ToLower(needle), strings.ToLower(candidate)); d <= maxDistance {
+			matches = append(matches, scored{candidate, d})
+		}
+	}
+
+	sort.Slice(matches, func(i, j int) bool {
+		if matches[i].distance == matches[j].distance {
+			return matches[i].value < matches[j].value
+		}
+		return matches[i].distance < matches[j].distance
+	})
+
+	res := []string{}
+	for idx, match := range matches {
+		if idx == maxSuggestions {
+			break
+		}
+		res = append(res, match.value)
+	}
+	return res
+}
+
+// DidYouMean formats suggestions as a question, or returns an empty string if there are none.
+func DidYouMean(suggestions []string) string {
+	if len(suggestions) == 0 {
+		return ""
+	}
+
+	quoted := make([]string, len(suggestions))
+	for idx, suggestion := range suggestions {
+		quoted[idx] = fmt.Sprintf("`%s`", suggestion)
+	}
+
+	if len(quoted) == 1 {
+		return fmt.Sprintf("did you mean %s?", quoted[0])
+	}
+	return fmt.Sprintf("did you mean %s or %s?", strings.Join(quoted[:len(quoted)-1], ", "), quoted[len(quoted)-1])
+}
@@ -0,0 +1,54 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright © 2021 Roberto Hidalgo <milpa@un.rob.mx>
+package errors_test
+
+import (
+	"reflect"
+	"testing"
+
+	. "github.com/unrob/milpa/internal/errors"
+)
+
+func TestSuggestions(t *testing.T) {
+	commands := []string{"milpa deploy", "milpa delete", "milpa itself create", "milpa itself doctor", "milpa itself command-tree"}
+	cases := []struct {
+		needle   string
+		word     string
+		expected []string
+	}{
+		{"milpa deplyo", "deplyo", []string{"milpa deploy"}},
+		{"milpa deply", "deply", []string{"milpa deploy"}},
+		{"milpa itself cretae", "cretae", []string{"milpa itself create"}},
+		{"milpa itself doktor", "doktor", []string{"milpa itself doctor"}},
+		{"milpa DEPLOY", "DEPLOY", []string{"milpa deploy"}},
+		{"milpa something", "something", []string{}},
+		{"milpa deploy", "deploy", []string{}},
+	}
+
+	for _, c := range cases {
+		got := Suggestions(c.needle, commands, MaxTypoDistance(c.word))
+		if !reflect.DeepEqual(got, c.expected) {
+			t.Errorf("unexpected suggestions for %q, wanted %v, got %v", c.needle, c.expected, got)
+		}
+	}
+
+	got := Suggestions("--fmt", []string{"--format", "--fix", "--fat", "--mfa", "--fmt-all"}, 2)
+	if expected := []string{"--fat", "--fix", "--mfa"}; !reflect.DeepEqual(got, expected) {
+		t.Errorf("unexpected suggestions, wanted %v, got %v", expected, got)
+	}
+}
+
+func TestDidYouMean(t *testing.T) {
+	cases := map[string][]string{
+		"":                              {},
+		"did you mean `a`?":             {"a"},
+		"did you mean `a` or `b`?":      {"a", "b"},
+		"did you mean `a`, `b` or `c`?": {"a", "b", "c"},
+	}
+
+	for expected, suggestions := range cases {
+		if got := DidYouMean(suggestions); got != expected {
+			t.Errorf("unexpected question for %v, wanted %q, got %q", suggestions, expected, got)
+		}
+	}
+}
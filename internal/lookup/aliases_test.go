@@ -0,0 +1,43 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright © 2021 Roberto Hidalgo <milpa@un.rob.mx>
+package lookup_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	ccmd "git.rob.mx/nidito/chinampa/pkg/command"
+	"github.com/unrob/milpa/internal/command"
+	. "github.com/unrob/milpa/internal/lookup"
+)
+
+func TestAliasesFor(t *testing.T) {
+	repo := filepath.Join(t.TempDir(), ".milpa")
+	if err := os.MkdirAll(filepath.Join(repo, "commands"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	script := filepath.Join(repo, "commands", "greet.sh")
+	if err := os.WriteFile(script, []byte("echo hi"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	spec := []byte("summary: greets\ndescription: greets\naliases: [hi, help docs]\n")
+	if err := os.WriteFile(filepath.Join(repo, "commands", "greet.yaml"), spec, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	cmd, err := command.New(script, repo, false)
+	if err != nil {
+		t.Fatalf("could not parse spec: %s", err)
+	}
+
+	docs := &ccmd.Command{Path: []string{"help", "docs"}}
+	aliases := AliasesFor([]*ccmd.Command{docs}, []*ccmd.Command{cmd})
+	if len(aliases) != 1 || aliases[0].FullName() != "hi" {
+		names := []string{}
+		for _, alias := range aliases {
+			names = append(names, alias.FullName())
+		}
+		t.Fatalf("aliases taking built-in commands were not skipped: %v", names)
+	}
+}
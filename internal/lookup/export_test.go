@@ -0,0 +1,13 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright © 2021 Roberto Hidalgo <milpa@un.rob.mx>
+package lookup
+
+import ccmd "git.rob.mx/nidito/chinampa/pkg/command"
+
+// AliasesFor exposes aliasesFor to tests, with builtin as the commands implemented by compa.
+func AliasesFor(builtin []*ccmd.Command, cmds []*ccmd.Command) []*ccmd.Command {
+	previous := builtins
+	defer func() { builtins = previous }()
+	builtins = builtin
+	return aliasesFor(cmds)
+}
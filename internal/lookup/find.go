@@ -62,7 +62,9 @@ func Scripts(query []string) (results map[string]string, err error) {
 	return results, err
 }
 
-func AllSubCommands(returnOnError bool) error {
+// AllSubCommands registers every command found on MILPA_PATH, along with their aliases.
+// Commands are parsed with described values when completing.
+func AllSubCommands(returnOnError bool, completing bool) error {
 	files, err := Scripts([]string{"**"})
 	if err != nil {
 		return err
@@ -78,9 +80,10 @@ func AllSubCommands(returnOnError bool) error {
 	}
 	sort.Strings(keys)
 
+	commands := []*ccmd.Command{}
 	for _, path := range keys {
 		repo := files[path]
-		cmd, specErr := command.New(path, repo)
+		cmd, specErr := command.New(path, repo, completing)
 		if specErr == nil {
 			log.Debugf("Initialized %s", cmd.FullName())
 			chinampa.Register(cmd)
@@ -91,11 +94,67 @@ func AllSubCommands(returnOnError bool) error {
 			}
 			chinampa.Register(cmd)
 		}
+		commands = append(commands, cmd)
 	}
 
+	registerAliases(commands)
+
 	return err
 }
 
+// builtins holds the commands implemented by compa, that aliases may not take.
+var builtins = []*ccmd.Command{}
+
+// RegisterBuiltins registers commands implemented by compa, so aliases declared by specs
+// can't shadow them.
+func RegisterBuiltins(cmds ...*ccmd.Command) {
+	for _, cmd := range cmds {
+		builtins = append(builtins, cmd)
+		chinampa.Register(cmd)
+	}
+}
+
+// registerAliases registers the aliases declared by cmds.
+func registerAliases(cmds []*ccmd.Command) {
+	for _, aliased := range aliasesFor(cmds) {
+		chinampa.Register(aliased)
+	}
+}
+
+// aliasesFor returns commands for the aliases declared by cmds, skipping those that collide
+// with built-ins, other commands or aliases; `milpa itself doctor` reports these.
+func aliasesFor(cmds []*ccmd.Command) []*ccmd.Command {
+	aliases := []*ccmd.Command{}
+	conflicts := command.AliasConflicts(append(append([]*ccmd.Command{}, builtins...), cmds...))
+	for _, cmd := range cmds {
+		meta, ok := command.MetaFor(cmd)
+		if !ok {
+			continue
+		}
+
+		skip := map[string]bool{}
+		for _, alias := range conflicts[strings.Join(cmd.Path, " ")] {
+			log.Debugf("Skipping alias %s for %s, it's already taken", alias, cmd.FullName())
+			skip[alias.String()] = true
+		}
+
+		for _, alias := range meta.Aliases {
+			if skip[alias.String()] {
+				continue
+			}
+
+			aliased, err := command.NewAlias(cmd, alias)
+			if err != nil {
+				log.Debugf("Could not initialize alias %s for %s: %s", alias, cmd.FullName(), err)
+				continue
+			}
+			log.Debugf("Initialized alias %s for %s", alias, cmd.FullName())
+			aliases = append(aliases, aliased)
+		}
+	}
+	return aliases
+}
+
 func AllDocs() ([]string, error) {
 	results := []string{}
 	if err := bootstrap.CheckMilpaPathSet(); err != nil {
@@ -189,7 +189,7 @@ func TestAllSubCommands(t *testing.T) {
 	bootstrap.MilpaPath = []string{root + "/.milpa"}
 	bootstrap.ParseMilpaPath()
 
-	if err := AllSubCommands(true); err != nil {
+	if err := AllSubCommands(true, false); err != nil {
 		t.Fatalf("did not find all subcommands: %s", err)
 	}
 
@@ -200,11 +200,16 @@ func TestAllSubCommands(t *testing.T) {
 	expected := []string{
 		"itself", // this virtual command is found since it has a defaults set
 		"itself command-tree",
+		"itself command-tree diff",
+		"itself completion-cache",
 		"itself create",
 		"itself install-autocomplete",
 		"itself repo",
 		"itself repo install",
 		"itself repo list",
+		"itself repo outdated",
+		"itself repo pack",
+		"itself repo sync",
 		"itself repo uninstall",
 		"itself repo upgrade",
 		"itself upgrade",
@@ -0,0 +1,169 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright © 2021 Roberto Hidalgo <milpa@un.rob.mx>
+package lookup
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"git.rob.mx/nidito/chinampa"
+	ccmd "git.rob.mx/nidito/chinampa/pkg/command"
+	"git.rob.mx/nidito/chinampa/pkg/tree"
+	"github.com/spf13/cobra"
+	"github.com/unrob/milpa/internal/bootstrap"
+	"github.com/unrob/milpa/internal/command"
+	_c "github.com/unrob/milpa/internal/constants"
+)
+
+// TreeMutex guards the command tree, as it's shared between Refresh and whatever
+// reads it concurrently, like the docs server.
+var TreeMutex sync.Mutex
+
+// repoFor returns the MILPA_PATH entry containing path, if any.
+func repoFor(path string) string {
+	found := ""
+	for _, repo := range bootstrap.MilpaPath {
+		if strings.HasPrefix(path, repo+"/") && len(repo) > len(found) {
+			found = repo
+		}
+	}
+	return found
+}
+
+// executableForSpec finds the command a spec describes: itself for group commands,
+// or a sibling `.sh` or extension-less file otherwise.
+func executableForSpec(spec string) string {
+	if _, err := os.Stat(spec); err != nil {
+		return ""
+	}
+
+	base := filepath.Base(spec)
+	if "_"+filepath.Base(filepath.Dir(spec))+".yaml" == base {
+		return spec
+	}
+
+	name := strings.TrimSuffix(spec, ".yaml")
+	for _, candidate := range []string{name + ".sh", name} {
+		if fi, err := os.Stat(candidate); err == nil && fi.Mode().IsRegular() {
+			return candidate
+		}
+	}
+
+	return ""
+}
+
+// nameForSpec returns the command name for a spec, even if it no longer exists.
+func nameForSpec(spec, repo string) []string {
+	name := strings.TrimPrefix(strings.TrimSuffix(spec, ".yaml"), filepath.Join(repo, _c.RepoCommandFolderName)+"/")
+	if "_"+filepath.Base(filepath.Dir(spec))+".yaml" == filepath.Base(spec) {
+		name = filepath.Dir(name)
+	}
+	if mount := bootstrap.Mounts[repo]; mount != "" {
+		name = mount + "/" + name
+	}
+	return strings.Split(name, "/")
+}
+
+func findRegistered(path []string) *ccmd.Command {
+	name := strings.Join(path, " ")
+	for _, cmd := range tree.CommandList() {
+		if cmd.FullName() == name || strings.Join(cmd.Path, " ") == name {
+			return cmd
+		}
+	}
+	return nil
+}
+
+func findCobraChild(parent *cobra.Command, name string) *cobra.Command {
+	for _, child := range parent.Commands() {
+		if child.Name() == name {
+			return child
+		}
+	}
+	return nil
+}
+
+// mount attaches cmd to the cobra tree, creating any missing parent groups.
+func mount(cmd *ccmd.Command) {
+	parent := ccmd.Root.Cobra.Root()
+	for idx, name := range cmd.Path[0 : len(cmd.Path)-1] {
+		child := findCobraChild(parent, name)
+		if child == nil {
+			group := &ccmd.Command{
+				Path: cmd.Path[0 : idx+1],
+				Meta: command.Meta{
+					Name: cmd.Path[0 : idx+1],
+					Kind: command.KindVirtual,
+				},
+			}
+			log.Debugf("creating group %s", group.Path)
+			mount(group.SetBindings())
+			child = group.Cobra
+		}
+		parent = child
+	}
+
+	cc := &cobra.Command{
+		Use:    cmd.Name(),
+		Short:  cmd.Summary,
+		Hidden: cmd.Hidden,
+	}
+	cc.SetHelpFunc(cmd.HelpRenderer(ccmd.Root.Options))
+	cmd.Cobra = cc
+	chinampa.Register(cmd)
+	parent.AddCommand(cc)
+}
+
+// Refresh re-reads the specs found at paths, updating, adding or removing their
+// commands from the already initialized command tree.
+func Refresh(paths []string) {
+	TreeMutex.Lock()
+	defer TreeMutex.Unlock()
+
+	for _, spec := range paths {
+		if filepath.Ext(spec) != ".yaml" {
+			continue
+		}
+
+		repo := repoFor(spec)
+		if repo == "" || !strings.HasPrefix(spec, filepath.Join(repo, _c.RepoCommandFolderName)+"/") {
+			log.Debugf("ignoring %s, not a command spec", spec)
+			continue
+		}
+
+		executable := executableForSpec(spec)
+		if executable == "" {
+			name := nameForSpec(spec, repo)
+			if existing := findRegistered(name); existing != nil && existing.Cobra != nil && existing.Cobra.HasParent() {
+				log.Infof("Removing %s", strings.Join(name, " "))
+				existing.Hidden = true
+				existing.Cobra.Parent().RemoveCommand(existing.Cobra)
+			}
+			continue
+		}
+
+		cmd, err := command.New(executable, repo, false)
+		if err != nil {
+			log.Warnf("Could not parse spec for %s, keeping the current command: %s", spec, err)
+			continue
+		}
+
+		existing := findRegistered(cmd.Path)
+		if existing == nil || existing.Cobra == nil || !existing.Cobra.HasParent() {
+			log.Infof("Adding %s", strings.Join(cmd.Path, " "))
+			mount(cmd.SetBindings())
+			continue
+		}
+
+		log.Infof("Reloading %s", existing.FullName())
+		cc := existing.Cobra
+		*existing = *cmd
+		existing.Cobra = cc
+		existing.SetBindings()
+		cc.Short = existing.Summary
+		cc.Hidden = existing.Hidden
+		cc.SetHelpFunc(existing.HelpRenderer(ccmd.Root.Options))
+	}
+}
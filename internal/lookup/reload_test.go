@@ -0,0 +1,102 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright © 2021 Roberto Hidalgo <milpa@un.rob.mx>
+package lookup_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	ccmd "git.rob.mx/nidito/chinampa/pkg/command"
+	"github.com/spf13/cobra"
+	"github.com/unrob/milpa/internal/bootstrap"
+	. "github.com/unrob/milpa/internal/lookup"
+)
+
+func childNamed(parent *cobra.Command, name string) *cobra.Command {
+	for _, child := range parent.Commands() {
+		if child.Name() == name {
+			return child
+		}
+	}
+	return nil
+}
+
+func TestRefresh(t *testing.T) {
+	repo := filepath.Join(t.TempDir(), ".milpa")
+	commands := filepath.Join(repo, "commands")
+	if err := os.MkdirAll(filepath.Join(commands, "group"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	mp := bootstrap.MilpaPath
+	root := ccmd.Root.Cobra
+	defer func() {
+		bootstrap.MilpaPath = mp
+		ccmd.Root.Cobra = root
+	}()
+	bootstrap.MilpaPath = []string{repo}
+	ccmd.Root.Cobra = &cobra.Command{Use: "milpa"}
+
+	write := func(name string, contents string) string {
+		t.Helper()
+		path := filepath.Join(commands, name)
+		if err := os.WriteFile(path, []byte(contents), 0644); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+
+	write("hello.sh", "echo hello")
+	spec := write("hello.yaml", "summary: before\ndescription: says hello\n")
+	write("group/nested.sh", "echo nested")
+	nested := write("group/nested.yaml", "summary: nested\ndescription: a nested command\n")
+
+	Refresh([]string{spec, nested, filepath.Join(commands, "hello.sh")})
+	hello := childNamed(ccmd.Root.Cobra, "hello")
+	if hello == nil || hello.Short != "before" {
+		t.Fatalf("command was not added: %+v", hello)
+	}
+
+	group := childNamed(ccmd.Root.Cobra, "group")
+	if group == nil || childNamed(group, "nested") == nil {
+		t.Fatalf("nested command was not added under a group: %+v", group)
+	}
+
+	t.Run("updates", func(t *testing.T) {
+		write("hello.yaml", "summary: after\ndescription: says hello\n")
+		Refresh([]string{spec})
+		if updated := childNamed(ccmd.Root.Cobra, "hello"); updated != hello || updated.Short != "after" {
+			t.Fatalf("command was not updated in place: %+v", updated)
+		}
+	})
+
+	t.Run("keeps commands with broken specs", func(t *testing.T) {
+		write("hello.yaml", "summary: [broken\n")
+		Refresh([]string{spec})
+		if kept := childNamed(ccmd.Root.Cobra, "hello"); kept != hello || kept.Short != "after" {
+			t.Fatalf("command was replaced by a broken one: %+v", kept)
+		}
+	})
+
+	t.Run("removes", func(t *testing.T) {
+		for _, path := range []string{spec, filepath.Join(commands, "hello.sh")} {
+			if err := os.Remove(path); err != nil {
+				t.Fatal(err)
+			}
+		}
+		Refresh([]string{spec})
+		if removed := childNamed(ccmd.Root.Cobra, "hello"); removed != nil {
+			t.Fatalf("command was not removed: %+v", removed)
+		}
+	})
+
+	t.Run("adds back", func(t *testing.T) {
+		write("hello.sh", "echo hello")
+		write("hello.yaml", "summary: again\ndescription: says hello\n")
+		Refresh([]string{spec})
+		if added := childNamed(ccmd.Root.Cobra, "hello"); added == nil || added.Short != "again" {
+			t.Fatalf("command was not added back: %+v", added)
+		}
+	})
+}
@@ -0,0 +1,542 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright © 2021 Roberto Hidalgo <milpa@un.rob.mx>
+package repo
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	_c "github.com/unrob/milpa/internal/constants"
+	"github.com/unrob/milpa/internal/util"
+	"gopkg.in/yaml.v3"
+)
+
+// ArchiveInfoName is the file next to the .milpa folder of repos installed from archives,
+// recording where they came from and the hashes of their contents.
+const ArchiveInfoName = "milpa.archive.yaml"
+
+// archiveExtensions are the archive formats repos can be installed from and packed into.
+var archiveExtensions = []string{".tar.gz", ".tgz", ".zip"}
+
+// packedTime is the modification time of every file in packed archives, so packing the same
+// contents always results in the same hash.
+var packedTime = time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// ArchiveInfo describes the archive a repo was installed from.
+type ArchiveInfo struct {
+	// Source is the path to the archive
+	Source string `json:"source" yaml:"source"`
+	// SHA256 is the hex-encoded hash of the archive
+	SHA256 string `json:"sha256" yaml:"sha256"`
+	// Files maps the path of every file unpacked to the hex-encoded hash of its contents
+	Files map[string]string `json:"files" yaml:"files"`
+	// Trust is the result of verifying the archive's signature
+	Trust TrustStatus `json:"trust" yaml:"trust"`
+	// Signer is the ID of the key that signed the archive
+	Signer string `json:"signer,omitempty" yaml:"signer,omitempty"`
+}
+
+// archiveExtension returns the archive extension of path, if any.
+func archiveExtension(path string) (string, bool) {
+	for _, ext := range archiveExtensions {
+		if strings.HasSuffix(strings.ToLower(path), ext) {
+			return ext, true
+		}
+	}
+	return "", false
+}
+
+// archivePath returns the local path to an archive referred to by source, if any.
+func archivePath(source string) (string, bool) {
+	if _, ok := archiveExtension(source); !ok {
+		return "", false
+	}
+
+	path := strings.TrimPrefix(source, "file://")
+	if strings.Contains(path, "://") {
+		return "", false
+	}
+
+	if strings.HasPrefix(path, "~/") {
+		path = filepath.Join(os.Getenv("HOME"), path[2:])
+	}
+
+	abs, err := filepath.Abs(path)
+	return abs, err == nil
+}
+
+// hashFile returns the hex-encoded sha256 of the contents of path.
+func hashFile(path string) (string, error) {
+	f, err := os.Open(path) // nolint: gosec
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	hash := sha256.New()
+	if _, err := io.Copy(hash, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// ReadArchiveInfo reads the archive info of a repo unpacked at dir.
+func ReadArchiveInfo(dir string) (*ArchiveInfo, error) {
+	contents, err := os.ReadFile(filepath.Join(dir, ArchiveInfoName))
+	if err != nil {
+		return nil, err
+	}
+
+	info := &ArchiveInfo{}
+	if err := yaml.Unmarshal(contents, info); err != nil {
+		return nil, fmt.Errorf("could not parse %s: %w", filepath.Join(dir, ArchiveInfoName), err)
+	}
+	return info, nil
+}
+
+// extractor unpacks archive entries into root, hashing every file written. Going through an
+// os.Root means no entry is written outside of it, even through the symlinks it unpacks.
+type extractor struct {
+	root     *os.Root
+	hashes   map[string]string
+	symlinks []string
+}
+
+// target returns the cleaned name of an entry, refusing names outside of root.
+func (x *extractor) target(name string) (string, error) {
+	clean := filepath.Clean(filepath.FromSlash(name))
+	if filepath.IsAbs(clean) || clean == ".." || strings.HasPrefix(clean, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("refusing to unpack %s outside of the archive root", name)
+	}
+	return clean, nil
+}
+
+func (x *extractor) dir(name string) error {
+	path, err := x.target(name)
+	if err != nil {
+		return err
+	}
+	return x.root.MkdirAll(path, 0755)
+}
+
+func (x *extractor) file(name string, mode fs.FileMode, src io.Reader) error {
+	path, err := x.target(name)
+	if err != nil {
+		return err
+	}
+
+	if err := x.root.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+
+	dst, err := x.root.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, mode.Perm()|0600)
+	if err != nil {
+		return err
+	}
+	defer dst.Close()
+
+	hash := sha256.New()
+	if _, err := io.Copy(io.MultiWriter(dst, hash), src); err != nil { // nolint: gosec
+		return err
+	}
+
+	x.hashes[filepath.ToSlash(path)] = hex.EncodeToString(hash.Sum(nil))
+	return nil
+}
+
+func (x *extractor) symlink(name string, target string) error {
+	path, err := x.target(name)
+	if err != nil {
+		return err
+	}
+
+	if filepath.IsAbs(target) {
+		return fmt.Errorf("refusing to unpack %s, linking to absolute path %s", name, target)
+	}
+
+	if _, err := x.target(filepath.Join(filepath.Dir(path), target)); err != nil {
+		return fmt.Errorf("refusing to unpack %s, linking outside of the archive root", name)
+	}
+
+	if err := x.root.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+
+	x.symlinks = append(x.symlinks, path)
+	return x.root.Symlink(target, path)
+}
+
+// checkSymlinks refuses symlinks that resolve outside of root once every entry is unpacked,
+// as links may point through other links, possibly unpacked after them.
+func (x *extractor) checkSymlinks() error {
+	for _, path := range x.symlinks {
+		if _, err := x.root.Stat(path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return fmt.Errorf("refusing to unpack %s, linking outside of the archive root: %w", filepath.ToSlash(path), err)
+		}
+	}
+	return nil
+}
+
+func (x *extractor) untar(path string) error {
+	f, err := os.Open(path) // nolint: gosec
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		return err
+	}
+	defer gz.Close()
+
+	archive := tar.NewReader(gz)
+	for {
+		header, err := archive.Next()
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+
+		switch header.Typeflag {
+		case tar.TypeDir:
+			err = x.dir(header.Name)
+		case tar.TypeReg:
+			err = x.file(header.Name, header.FileInfo().Mode(), archive)
+		case tar.TypeSymlink:
+			err = x.symlink(header.Name, header.Linkname)
+		default:
+			log.Debugf("skipping %s, unsupported type %c", header.Name, header.Typeflag)
+		}
+
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func (x *extractor) unzip(path string) error {
+	archive, err := zip.OpenReader(path)
+	if err != nil {
+		return err
+	}
+	defer archive.Close()
+
+	for _, entry := range archive.File {
+		mode := entry.Mode()
+		if mode.IsDir() {
+			if err := x.dir(entry.Name); err != nil {
+				return err
+			}
+			continue
+		}
+
+		src, err := entry.Open()
+		if err != nil {
+			return err
+		}
+
+		if mode&fs.ModeSymlink != 0 {
+			var target []byte
+			if target, err = io.ReadAll(src); err == nil {
+				err = x.symlink(entry.Name, string(target))
+			}
+		} else if mode.IsRegular() {
+			err = x.file(entry.Name, mode, src)
+		} else {
+			log.Debugf("skipping %s, unsupported mode %s", entry.Name, mode)
+		}
+		src.Close()
+
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// unpack extracts an archive into a new folder at dir, returning the folder holding its
+// .milpa folder: either the root of the archive, or its single top-level folder.
+func unpack(archive string, dir string) (string, map[string]string, error) {
+	root, err := os.OpenRoot(dir)
+	if err != nil {
+		return "", nil, err
+	}
+	defer root.Close()
+
+	x := &extractor{root: root, hashes: map[string]string{}}
+	ext, _ := archiveExtension(archive)
+	if ext == ".zip" {
+		err = x.unzip(archive)
+	} else {
+		err = x.untar(archive)
+	}
+
+	if err == nil {
+		err = x.checkSymlinks()
+	}
+
+	if err != nil {
+		return "", nil, fmt.Errorf("could not unpack %s: %w", archive, err)
+	}
+
+	if util.IsDir(filepath.Join(dir, _c.RepoRoot)) {
+		return "", x.hashes, nil
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err == nil && len(entries) == 1 && util.IsDir(filepath.Join(dir, entries[0].Name(), _c.RepoRoot)) {
+		prefix := entries[0].Name() + "/"
+		hashes := map[string]string{}
+		for name, hash := range x.hashes {
+			hashes[strings.TrimPrefix(name, prefix)] = hash
+		}
+		return entries[0].Name(), hashes, nil
+	}
+
+	return "", nil, fmt.Errorf("%s does not contain a %s folder", archive, _c.RepoRoot)
+}
+
+// installArchive unpacks archive into dirs, recording the hashes of its contents.
+func installArchive(archive string, dirs Dirs, trust *Trust) (*Installed, error) {
+	name := installName(archive)
+	clone := filepath.Join(dirs.Clones, name)
+	path := filepath.Join(dirs.Repos, name)
+	if _, err := os.Stat(clone); err == nil {
+		return nil, fmt.Errorf("%s already present", clone)
+	}
+
+	if _, err := os.Lstat(path); err == nil {
+		return nil, fmt.Errorf("a repo named %s already exists at %s", name, path)
+	}
+
+	sum, err := hashFile(archive)
+	if err != nil {
+		return nil, fmt.Errorf("could not read archive %s: %w", archive, err)
+	}
+
+	verification, err := VerifyArchive(archive, trust.keys())
+	if err != nil {
+		return nil, fmt.Errorf("could not verify archive %s: %w", archive, err)
+	}
+
+	if err := trust.check(archive, verification); err != nil {
+		return nil, err
+	}
+
+	if err := os.MkdirAll(dirs.Clones, 0755); err != nil {
+		return nil, err
+	}
+
+	log.Infof("Archive detected, unpacking %s...", archive)
+	tmp, err := os.MkdirTemp(dirs.Clones, "."+name+"-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(tmp) // nolint: errcheck
+
+	root, hashes, err := unpack(archive, tmp)
+	if err != nil {
+		return nil, err
+	}
+
+	info, err := yaml.Marshal(&ArchiveInfo{
+		Source: archive,
+		SHA256: sum,
+		Files:  hashes,
+		Trust:  verification.Status,
+		Signer: verification.Signer,
+	})
+	if err == nil {
+		err = os.WriteFile(filepath.Join(tmp, root, ArchiveInfoName), info, 0644) // nolint: gosec
+	}
+
+	if err == nil {
+		err = os.Rename(filepath.Join(tmp, root), clone)
+	}
+
+	if err == nil {
+		err = os.Symlink(filepath.Join(clone, _c.RepoRoot), path)
+	}
+
+	if err != nil {
+		os.RemoveAll(clone) // nolint: errcheck
+		return nil, fmt.Errorf("could not install %s: %w", archive, err)
+	}
+
+	return Inspect(path, dirs)
+}
+
+// Pack writes the .milpa folder of the repo at dir into an archive at dest, skipping
+// .git folders, and returns the archive's hex-encoded sha256.
+func Pack(dir string, dest string) (string, error) {
+	ext, ok := archiveExtension(dest)
+	if !ok {
+		return "", fmt.Errorf("unknown archive format for %s, expected one of %s", dest, strings.Join(archiveExtensions, ", "))
+	}
+
+	dir = strings.TrimSuffix(filepath.Clean(dir), string(filepath.Separator)+_c.RepoRoot)
+	if !util.IsDir(filepath.Join(dir, _c.RepoRoot)) {
+		return "", fmt.Errorf("no %s folder found at %s", _c.RepoRoot, dir)
+	}
+
+	out, err := os.Create(dest) // nolint: gosec
+	if err != nil {
+		return "", err
+	}
+
+	var packErr error
+	if ext == ".zip" {
+		packErr = packZip(dir, out)
+	} else {
+		packErr = packTar(dir, out)
+	}
+
+	if err := out.Close(); packErr == nil {
+		packErr = err
+	}
+
+	if packErr != nil {
+		os.Remove(dest) // nolint: errcheck
+		return "", fmt.Errorf("could not pack %s: %w", dir, packErr)
+	}
+
+	return hashFile(dest)
+}
+
+// walkRepo calls fn for every file, folder and symlink of the .milpa folder at dir, with
+// slash-separated names relative to dir.
+func walkRepo(dir string, fn func(name string, path string, info fs.FileInfo) error) error {
+	return filepath.WalkDir(filepath.Join(dir, _c.RepoRoot), func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if entry.Name() == ".git" {
+			if entry.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		return fn(filepath.ToSlash(rel), path, info)
+	})
+}
+
+func packTar(dir string, out io.Writer) error {
+	gz := gzip.NewWriter(out)
+	archive := tar.NewWriter(gz)
+
+	err := walkRepo(dir, func(name string, path string, info fs.FileInfo) error {
+		link := ""
+		if info.Mode()&fs.ModeSymlink != 0 {
+			var err error
+			if link, err = os.Readlink(path); err != nil {
+				return err
+			}
+		}
+
+		header, err := tar.FileInfoHeader(info, link)
+		if err != nil {
+			return err
+		}
+
+		header.Name = name
+		if info.IsDir() {
+			header.Name += "/"
+		}
+		header.ModTime = packedTime
+		header.Uid, header.Gid, header.Uname, header.Gname = 0, 0, "", ""
+		if err := archive.WriteHeader(header); err != nil {
+			return err
+		}
+
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+		return copyFile(archive, path)
+	})
+
+	if err != nil {
+		return err
+	}
+
+	if err := archive.Close(); err != nil {
+		return err
+	}
+	return gz.Close()
+}
+
+func packZip(dir string, out io.Writer) error {
+	archive := zip.NewWriter(out)
+
+	err := walkRepo(dir, func(name string, path string, info fs.FileInfo) error {
+		header, err := zip.FileInfoHeader(info)
+		if err != nil {
+			return err
+		}
+
+		header.Name = name
+		if info.IsDir() {
+			header.Name += "/"
+		} else {
+			header.Method = zip.Deflate
+		}
+		header.Modified = packedTime
+
+		w, err := archive.CreateHeader(header)
+		if err != nil {
+			return err
+		}
+
+		switch {
+		case info.Mode()&fs.ModeSymlink != 0:
+			link, err := os.Readlink(path)
+			if err != nil {
+				return err
+			}
+			_, err = io.WriteString(w, link)
+			return err
+		case info.Mode().IsRegular():
+			return copyFile(w, path)
+		}
+		return nil
+	})
+
+	if err != nil {
+		return err
+	}
+	return archive.Close()
+}
+
+func copyFile(dst io.Writer, path string) error {
+	src, err := os.Open(path) // nolint: gosec
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	_, err = io.Copy(dst, src)
+	return err
+}
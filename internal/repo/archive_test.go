@@ -0,0 +1,286 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright © 2021 Roberto Hidalgo <milpa@un.rob.mx>
+package repo_test
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"testing"
+
+	. "github.com/unrob/milpa/internal/repo"
+)
+
+func localRepo(t *testing.T) string {
+	t.Helper()
+	dir := filepath.Join(t.TempDir(), "infra")
+	for name, contents := range map[string]string{
+		".milpa/commands/deploy.sh":   "echo deploying\n",
+		".milpa/commands/deploy.yaml": "summary: deploys\ndescription: deploys\n",
+		".milpa/.git/config":          "not packed\n",
+		"README.md":                   "not packed either\n",
+	} {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(contents), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+func TestPackAndInstall(t *testing.T) {
+	src := localRepo(t)
+
+	for _, ext := range []string{".tar.gz", ".zip"} {
+		t.Run(ext, func(t *testing.T) {
+			archive := filepath.Join(t.TempDir(), "infra-1.0"+ext)
+			sum, err := Pack(src, archive)
+			if err != nil {
+				t.Fatalf("could not pack: %s", err)
+			}
+
+			if again, err := Pack(src+"/.milpa", archive); err != nil || again != sum {
+				t.Fatalf("packing the same repo twice resulted in different hashes: %s, %s (%v)", sum, again, err)
+			}
+
+			dirs := GlobalDirs(t.TempDir())
+			inst, err := Install("file://"+archive, dirs, "", nil)
+			if err != nil {
+				t.Fatalf("could not install: %s", err)
+			}
+
+			if inst.Kind != KindArchive || inst.Name != "infra-1-0" || inst.SHA256 != sum || inst.Source != archive {
+				t.Fatalf("unexpected install: %+v", inst)
+			}
+
+			contents, err := os.ReadFile(filepath.Join(inst.Path, "commands", "deploy.sh"))
+			if err != nil || string(contents) != "echo deploying\n" {
+				t.Fatalf("unexpected command contents: %q, %v", contents, err)
+			}
+
+			for _, excluded := range []string{filepath.Join(inst.Path, ".git"), filepath.Join(inst.Clone, "README.md")} {
+				if _, err := os.Stat(excluded); !os.IsNotExist(err) {
+					t.Fatalf("%s was packed", excluded)
+				}
+			}
+
+			info, err := ReadArchiveInfo(inst.Clone)
+			if err != nil {
+				t.Fatalf("could not read archive info: %s", err)
+			}
+
+			expected := sha256.Sum256([]byte("echo deploying\n"))
+			if hash := info.Files[".milpa/commands/deploy.sh"]; hash != hex.EncodeToString(expected[:]) || len(info.Files) != 2 {
+				t.Fatalf("unexpected hashes: %+v", info.Files)
+			}
+
+			if _, err := Upgrade(inst, true, nil); err == nil {
+				t.Fatal("upgraded an archive")
+			}
+
+			if err := Uninstall(inst); err != nil {
+				t.Fatalf("could not uninstall: %s", err)
+			}
+
+			if _, err := os.Stat(inst.Clone); !os.IsNotExist(err) {
+				t.Fatalf("unpacked archive was not removed")
+			}
+		})
+	}
+}
+
+// writeTar creates a .tar.gz with files named after the keys of entries.
+func writeTar(t *testing.T, entries map[string]string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "repo.tar.gz")
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	gz := gzip.NewWriter(f)
+	archive := tar.NewWriter(gz)
+	for name, contents := range entries {
+		if err := archive.WriteHeader(&tar.Header{Name: name, Mode: 0644, Size: int64(len(contents)), Typeflag: tar.TypeReg}); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := archive.Write([]byte(contents)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := archive.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := gz.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// archiveEntry is a file, or a symlink when link is set, written by writeArchive.
+type archiveEntry struct {
+	name     string
+	link     string
+	contents string
+}
+
+// writeArchive creates an archive with the extension ext holding entries, in order.
+func writeArchive(t *testing.T, ext string, entries []archiveEntry) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "repo"+ext)
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	if ext == ".zip" {
+		archive := zip.NewWriter(f)
+		for _, entry := range entries {
+			header := &zip.FileHeader{Name: entry.name}
+			contents := entry.contents
+			if entry.link != "" {
+				header.SetMode(fs.ModeSymlink | 0777)
+				contents = entry.link
+			} else {
+				header.SetMode(0644)
+			}
+			w, err := archive.CreateHeader(header)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := w.Write([]byte(contents)); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := archive.Close(); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+
+	gz := gzip.NewWriter(f)
+	archive := tar.NewWriter(gz)
+	for _, entry := range entries {
+		header := &tar.Header{Name: entry.name, Mode: 0644, Size: int64(len(entry.contents)), Typeflag: tar.TypeReg}
+		if entry.link != "" {
+			header = &tar.Header{Name: entry.name, Mode: 0777, Linkname: entry.link, Typeflag: tar.TypeSymlink}
+		}
+		if err := archive.WriteHeader(header); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := archive.Write([]byte(entry.contents)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := archive.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := gz.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestInstallArchiveSymlinks(t *testing.T) {
+	deploy := archiveEntry{name: ".milpa/commands/deploy.sh", contents: "echo deploying\n"}
+	for _, ext := range []string{".tar.gz", ".zip"} {
+		t.Run(ext, func(t *testing.T) {
+			archive := writeArchive(t, ext, []archiveEntry{deploy, {name: ".milpa/commands/ship.sh", link: "deploy.sh"}})
+			inst, err := Install(archive, GlobalDirs(t.TempDir()), "", nil)
+			if err != nil {
+				t.Fatalf("could not install: %s", err)
+			}
+
+			if contents, err := os.ReadFile(filepath.Join(inst.Path, "commands", "ship.sh")); err != nil || string(contents) != "echo deploying\n" {
+				t.Fatalf("symlink not unpacked: %q, %v", contents, err)
+			}
+
+			for name, entries := range map[string][]archiveEntry{
+				"absolute link": {deploy, {name: ".milpa/escape", link: "/tmp"}},
+				"link outside":  {deploy, {name: ".milpa/escape", link: "../.."}},
+				"chained links": {
+					deploy,
+					{name: "d1/d2/x", link: "../.."},
+					{name: "s", link: "d1/d2/x/.."},
+					{name: "s/pwned.txt", contents: "pwned\n"},
+				},
+				"chained links, last written first": {
+					deploy,
+					{name: "s", link: "d1/d2/x/.."},
+					{name: "d1/d2/x", link: "../.."},
+				},
+			} {
+				t.Run(name, func(t *testing.T) {
+					dirs := GlobalDirs(filepath.Join(t.TempDir(), "data"))
+					if _, err := Install(writeArchive(t, ext, entries), dirs, "", nil); err == nil {
+						t.Fatal("installed an archive linking outside of its root")
+					}
+
+					for _, escaped := range []string{filepath.Join(dirs.Clones, "pwned.txt"), filepath.Join(filepath.Dir(dirs.Clones), "pwned.txt")} {
+						if _, err := os.Stat(escaped); !os.IsNotExist(err) {
+							t.Fatalf("unpacked a file outside of the archive root: %s", escaped)
+						}
+					}
+
+					if leftovers, _ := os.ReadDir(dirs.Clones); len(leftovers) != 0 {
+						t.Fatalf("failed install left files behind: %v", leftovers)
+					}
+				})
+			}
+		})
+	}
+}
+
+func TestInstallArchive(t *testing.T) {
+	t.Run("top-level folder", func(t *testing.T) {
+		archive := writeTar(t, map[string]string{"infra-1.0/.milpa/commands/deploy.sh": "echo deploying\n"})
+		inst, err := Install(archive, GlobalDirs(t.TempDir()), "", nil)
+		if err != nil {
+			t.Fatalf("could not install: %s", err)
+		}
+
+		if _, err := os.Stat(filepath.Join(inst.Path, "commands", "deploy.sh")); err != nil {
+			t.Fatalf("command not installed: %s", err)
+		}
+
+		info, err := ReadArchiveInfo(inst.Clone)
+		if err != nil || info.Files[".milpa/commands/deploy.sh"] == "" {
+			t.Fatalf("unexpected archive info: %+v, %v", info, err)
+		}
+	})
+
+	for name, entries := range map[string]map[string]string{
+		"missing .milpa":  {"commands/deploy.sh": "echo deploying\n"},
+		"outside of root": {".milpa/commands/deploy.sh": "echo deploying\n", "../escaped.sh": "echo escaped\n"},
+		"absolute path":   {"/tmp/escaped.sh": "echo escaped\n"},
+		"nested escape":   {".milpa/../../escaped.sh": "echo escaped\n"},
+	} {
+		t.Run(name, func(t *testing.T) {
+			dirs := GlobalDirs(t.TempDir())
+			if _, err := Install(writeTar(t, entries), dirs, "", nil); err == nil {
+				t.Fatal("installed a bad archive")
+			}
+
+			if leftovers, _ := os.ReadDir(dirs.Clones); len(leftovers) != 0 {
+				t.Fatalf("failed install left files behind: %v", leftovers)
+			}
+		})
+	}
+
+	t.Run("with ref", func(t *testing.T) {
+		archive := writeTar(t, map[string]string{".milpa/commands/deploy.sh": "echo deploying\n"})
+		if _, err := Install(archive, GlobalDirs(t.TempDir()), "v1.0.0", nil); err == nil {
+			t.Fatal("installed an archive at a ref")
+		}
+	})
+}
@@ -0,0 +1,219 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright © 2021 Roberto Hidalgo <milpa@un.rob.mx>
+package repo
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// Dependency is a repo another repo requires, declared as `source@ref` in its repo.yaml.
+type Dependency struct {
+	// Source is anything `milpa itself repo install` accepts
+	Source string `json:"source"`
+	// Ref is the branch, tag or commit to install, if any
+	Ref string `json:"ref,omitempty"`
+}
+
+// ParseDependency splits spec into a source and an optional ref, after its last @. Since
+// refs cannot contain colons, the @ of ssh urls like `git@github.com:org/repo.git` is not
+// mistaken for a ref.
+func ParseDependency(spec string) Dependency {
+	idx := strings.LastIndex(spec, "@")
+	if idx <= 0 {
+		return Dependency{Source: spec}
+	}
+
+	source, ref := spec[:idx], spec[idx+1:]
+	// ssh://git@host/org/repo.git names a user, not a ref
+	_, afterScheme, hasScheme := strings.Cut(source, "://")
+	if ref == "" || strings.Contains(ref, ":") || (hasScheme && !strings.Contains(afterScheme, "/")) {
+		return Dependency{Source: spec}
+	}
+	return Dependency{Source: source, Ref: ref}
+}
+
+func (dep Dependency) String() string {
+	if dep.Ref == "" {
+		return dep.Source
+	}
+	return dep.Source + "@" + dep.Ref
+}
+
+// relativeTo returns dep with its source found from dir, when it's a relative path: either
+// starting with ./ or ../, or naming something at dir.
+func (dep Dependency) relativeTo(dir string) Dependency {
+	if dir == "" || filepath.IsAbs(dep.Source) || strings.HasPrefix(dep.Source, "~/") || strings.Contains(dep.Source, ":") {
+		return dep
+	}
+
+	joined := filepath.Join(dir, dep.Source)
+	if _, err := os.Stat(joined); err == nil || strings.HasPrefix(dep.Source, "./") || strings.HasPrefix(dep.Source, "../") {
+		dep.Source = joined
+	}
+	return dep
+}
+
+// Dependencies returns the repos declared in meta's depends, for the repo whose .milpa folder
+// is at path. Relative paths are found from the folder holding it, not the working directory.
+func (meta *Metadata) Dependencies(path string) []Dependency {
+	if meta == nil {
+		return nil
+	}
+
+	// installed repos are symlinks to the .milpa folder of local repos and clones
+	if real, err := filepath.EvalSymlinks(path); err == nil {
+		path = real
+	}
+	dir := filepath.Dir(filepath.Clean(path))
+
+	deps := make([]Dependency, len(meta.Depends))
+	for idx, spec := range meta.Depends {
+		deps[idx] = ParseDependency(spec).relativeTo(dir)
+	}
+	return deps
+}
+
+// installName returns the name of the folder source is installed as.
+func installName(source string) string {
+	if archive, ok := archivePath(source); ok {
+		ext, _ := archiveExtension(archive)
+		return CleanName(strings.TrimSuffix(filepath.Base(archive), ext))
+	}
+
+	if local, ok := localRepo(source); ok {
+		return strings.TrimPrefix(filepath.Base(local), ".")
+	}
+
+	return CleanName(source)
+}
+
+// findInstalled returns the path of the repo named name in the first of dirs it is installed to.
+func findInstalled(name string, dirs []Dirs) (string, bool) {
+	for _, d := range dirs {
+		path := filepath.Join(d.Repos, name)
+		if fi, err := os.Stat(path); err == nil && fi.IsDir() {
+			return path, true
+		}
+	}
+	return "", false
+}
+
+// resolver installs the dependencies of repos, keeping track of what it installed.
+type resolver struct {
+	dirs      Dirs
+	available []Dirs
+	trust     *Trust
+	seen      map[string]bool
+	// installed holds the repos installed so far, dependencies first
+	installed []*Installed
+}
+
+func (r *resolver) dependencies(meta *Metadata, path string, chain []string) error {
+	for _, dep := range meta.Dependencies(path) {
+		name := installName(dep.Source)
+		if idx := slices.Index(chain, name); idx > -1 {
+			return fmt.Errorf("dependency cycle: %s", strings.Join(append(chain[idx:], name), " -> "))
+		}
+
+		if r.seen[name] {
+			continue
+		}
+		r.seen[name] = true
+
+		if path, ok := findInstalled(name, r.available); ok {
+			log.Infof("Dependency %s of %s already installed at %s", dep, chain[len(chain)-1], path)
+			existing, err := ReadMetadata(path)
+			if err != nil {
+				return err
+			}
+			if err := r.dependencies(existing, path, append(chain, name)); err != nil {
+				return err
+			}
+			continue
+		}
+
+		log.Infof("Installing dependency %s of %s", dep, chain[len(chain)-1])
+		inst, err := Install(dep.Source, r.dirs, dep.Ref, r.trust)
+		if err != nil {
+			return fmt.Errorf("could not install dependency %s of %s: %w", dep, chain[len(chain)-1], err)
+		}
+
+		// installed repos are rolled back even if their own dependencies fail to install
+		r.installed = append(r.installed, inst)
+		if err := r.dependencies(inst.Metadata, inst.Path, append(chain, name)); err != nil {
+			return err
+		}
+
+		// keep dependencies before the repos that need them
+		r.installed = append(slices.DeleteFunc(r.installed, func(i *Installed) bool { return i == inst }), inst)
+	}
+	return nil
+}
+
+// InstallWithDependencies installs source like Install does, along with the repos it depends
+// on, transitively. Dependencies already installed to dirs, or any of available, are left
+// as they are. If any of them fails to install, or they depend on each other, every repo
+// installed is removed. Installed repos are returned with dependencies first.
+func InstallWithDependencies(source string, dirs Dirs, ref string, trust *Trust, available ...Dirs) ([]*Installed, error) {
+	inst, err := Install(source, dirs, ref, trust)
+	if err != nil {
+		return nil, err
+	}
+
+	name := installName(source)
+	r := &resolver{
+		dirs:      dirs,
+		available: append([]Dirs{dirs}, available...),
+		trust:     trust,
+		seen:      map[string]bool{name: true},
+		installed: []*Installed{},
+	}
+
+	if err := r.dependencies(inst.Metadata, inst.Path, []string{name}); err != nil {
+		for _, dep := range append(r.installed, inst) {
+			if rmErr := Uninstall(dep); rmErr != nil {
+				log.Warnf("could not remove %s: %s", dep.Path, rmErr)
+			}
+		}
+		return nil, err
+	}
+
+	return append(r.installed, inst), nil
+}
+
+// DependencyPaths returns the installed paths of the repos the repo at path depends on,
+// transitively, looking for them in each of dirs. Missing dependencies are skipped.
+func DependencyPaths(path string, dirs ...Dirs) []string {
+	paths := []string{}
+	seen := map[string]bool{path: true}
+	pending := []string{path}
+	for len(pending) > 0 {
+		current := pending[0]
+		pending = pending[1:]
+
+		meta, err := ReadMetadata(current)
+		if err != nil {
+			log.Debugf("could not read metadata of %s: %s", current, err)
+			continue
+		}
+
+		for _, dep := range meta.Dependencies(current) {
+			found, ok := findInstalled(installName(dep.Source), dirs)
+			if !ok {
+				log.Debugf("dependency %s of %s is not installed", dep, current)
+				continue
+			}
+
+			if !seen[found] {
+				seen[found] = true
+				paths = append(paths, found)
+				pending = append(pending, found)
+			}
+		}
+	}
+	return paths
+}
@@ -0,0 +1,181 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright © 2021 Roberto Hidalgo <milpa@un.rob.mx>
+package repo_test
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	. "github.com/unrob/milpa/internal/repo"
+)
+
+func TestParseDependency(t *testing.T) {
+	for spec, expected := range map[string]Dependency{
+		"https://github.com/company/util.git":        {Source: "https://github.com/company/util.git"},
+		"https://github.com/company/util.git@v1.2.0": {Source: "https://github.com/company/util.git", Ref: "v1.2.0"},
+		"git@github.com:company/util.git":            {Source: "git@github.com:company/util.git"},
+		"git@github.com:company/util.git@feature/x":  {Source: "git@github.com:company/util.git", Ref: "feature/x"},
+		"ssh://git@git.example.com/company/util.git": {Source: "ssh://git@git.example.com/company/util.git"},
+		"ssh://git@git.example.com/util.git@main":    {Source: "ssh://git@git.example.com/util.git", Ref: "main"},
+		"~/code/util":  {Source: "~/code/util"},
+		"~/code/util@": {Source: "~/code/util@"},
+	} {
+		if dep := ParseDependency(spec); dep != expected {
+			t.Errorf("parsing %s: expected %+v, got %+v", spec, expected, dep)
+		}
+
+		if expected.Ref != "" && ParseDependency(spec).String() != spec {
+			t.Errorf("%s does not round-trip, got %s", spec, ParseDependency(spec))
+		}
+	}
+}
+
+// dependentRepo creates a local repo named name at base, depending on deps.
+func dependentRepo(t *testing.T, base string, name string, deps ...string) string {
+	t.Helper()
+	dir := filepath.Join(base, name)
+	if err := os.MkdirAll(filepath.Join(dir, ".milpa", "util"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	writeFile(t, filepath.Join(dir, ".milpa", "util", name+".sh"), "echo "+name+"\n")
+
+	if len(deps) > 0 {
+		writeFile(t, filepath.Join(dir, ".milpa", "repo.yaml"), "depends:\n  - "+strings.Join(deps, "\n  - ")+"\n")
+	}
+	return dir
+}
+
+func names(installed []*Installed) []string {
+	res := []string{}
+	for _, inst := range installed {
+		res = append(res, inst.Name)
+	}
+	return res
+}
+
+func TestInstallWithDependencies(t *testing.T) {
+	source, commit := remoteRepo(t)
+	remote := filepath.Clean(source[len("file://"):])
+	first := commit("first")
+	run(t, remote, "git", "tag", "v1.0.0")
+	commit("second")
+
+	base := t.TempDir()
+	dependentRepo(t, base, "base", source+"@v1.0.0")
+	dependentRepo(t, base, "util", filepath.Join(base, "base"))
+	dependentRepo(t, base, "shared", filepath.Join(base, "base"))
+	app := dependentRepo(t, base, "app", filepath.Join(base, "util"), filepath.Join(base, "shared"))
+
+	t.Run("transitive", func(t *testing.T) {
+		dirs := GlobalDirs(t.TempDir())
+		installed, err := InstallWithDependencies(app, dirs, "", nil)
+		if err != nil {
+			t.Fatalf("could not install: %s", err)
+		}
+
+		// git repos are named after their cleaned up url
+		remoteName := installed[0].Name
+		expected := []string{remoteName, "base", "util", "shared", "app"}
+		if !strings.HasSuffix(remoteName, "-remote") {
+			t.Fatalf("unexpected name for git dependency: %s", remoteName)
+		}
+		if got := names(installed); !reflect.DeepEqual(got, expected) {
+			t.Fatalf("unexpected installs, wanted %v, got %v", expected, got)
+		}
+
+		if installed[0].Ref != "v1.0.0" || installed[0].Commit != first {
+			t.Fatalf("dependency not installed at its ref: %+v", installed[0])
+		}
+
+		paths := DependencyPaths(installed[len(installed)-1].Path, dirs)
+		expectedPaths := []string{}
+		for _, name := range []string{"util", "shared", "base", remoteName} {
+			expectedPaths = append(expectedPaths, filepath.Join(dirs.Repos, name))
+		}
+		if !reflect.DeepEqual(paths, expectedPaths) {
+			t.Fatalf("unexpected dependency paths, wanted %v, got %v", expectedPaths, paths)
+		}
+	})
+
+	t.Run("relative paths", func(t *testing.T) {
+		relative := t.TempDir()
+		dependentRepo(t, relative, "base")
+		dependentRepo(t, relative, "util", "../base")
+		app := dependentRepo(t, relative, "app", "./../util")
+
+		// relative dependencies are found from the repo declaring them, not the working directory
+		t.Chdir(t.TempDir())
+		dirs := GlobalDirs(t.TempDir())
+		installed, err := InstallWithDependencies(app, dirs, "", nil)
+		if err != nil {
+			t.Fatalf("could not install: %s", err)
+		}
+
+		if got := names(installed); !reflect.DeepEqual(got, []string{"base", "util", "app"}) {
+			t.Fatalf("unexpected installs: %v", got)
+		}
+
+		paths := DependencyPaths(filepath.Join(dirs.Repos, "app"), dirs)
+		if !reflect.DeepEqual(paths, []string{filepath.Join(dirs.Repos, "util"), filepath.Join(dirs.Repos, "base")}) {
+			t.Fatalf("unexpected dependency paths: %v", paths)
+		}
+	})
+
+	t.Run("already installed", func(t *testing.T) {
+		global := GlobalDirs(t.TempDir())
+		if _, err := InstallWithDependencies(filepath.Join(base, "base"), global, "", nil); err != nil {
+			t.Fatalf("could not install dependency: %s", err)
+		}
+
+		dirs := GlobalDirs(t.TempDir())
+		installed, err := InstallWithDependencies(app, dirs, "", nil, global)
+		if err != nil {
+			t.Fatalf("could not install: %s", err)
+		}
+
+		if got := names(installed); !reflect.DeepEqual(got, []string{"util", "shared", "app"}) {
+			t.Fatalf("reinstalled dependencies: %v", got)
+		}
+
+		paths := DependencyPaths(filepath.Join(dirs.Repos, "app"), dirs, global)
+		if len(paths) != 4 || paths[2] != filepath.Join(global.Repos, "base") {
+			t.Fatalf("unexpected dependency paths: %v", paths)
+		}
+	})
+
+	for name, repos := range map[string]map[string][]string{
+		"cycle": {
+			"one":   {"two"},
+			"two":   {"three"},
+			"three": {"one"},
+		},
+		"missing dependency": {
+			"one": {"two"},
+			"two": {"nowhere"},
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			base := t.TempDir()
+			for repo, deps := range repos {
+				for idx, dep := range deps {
+					deps[idx] = filepath.Join(base, dep)
+				}
+				dependentRepo(t, base, repo, deps...)
+			}
+
+			dirs := GlobalDirs(t.TempDir())
+			if _, err := InstallWithDependencies(filepath.Join(base, "one"), dirs, "", nil); err == nil {
+				t.Fatalf("installed repos with a %s", name)
+			} else if name == "cycle" && !strings.Contains(err.Error(), "one -> two -> three -> one") {
+				t.Fatalf("unexpected error: %s", err)
+			}
+
+			if leftovers, _ := os.ReadDir(dirs.Repos); len(leftovers) != 0 {
+				t.Fatalf("failed install left repos behind: %v", leftovers)
+			}
+		})
+	}
+}
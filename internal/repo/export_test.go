@@ -0,0 +1,6 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright © 2021 Roberto Hidalgo <milpa@un.rob.mx>
+package repo
+
+// CloneAt exposes cloneAt to tests.
+var CloneAt = cloneAt
@@ -0,0 +1,462 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright © 2021 Roberto Hidalgo <milpa@un.rob.mx>
+package repo
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	_c "github.com/unrob/milpa/internal/constants"
+	"github.com/unrob/milpa/internal/util"
+)
+
+// Kind describes how a repo was installed.
+type Kind string
+
+const (
+	// KindClone repos are git clones managed by milpa.
+	KindClone Kind = "clone"
+	// KindArchive repos were unpacked from a tarball or zip file.
+	KindArchive Kind = "archive"
+	// KindLink repos are symlinks to a local folder.
+	KindLink Kind = "link"
+	// KindDirectory repos were placed in the repos folder by hand.
+	KindDirectory Kind = "directory"
+)
+
+// Installed is a repo found in the repos folder of a set of Dirs.
+type Installed struct {
+	// Name is the name of the repo's folder
+	Name string `json:"name"`
+	// Path is the repo's .milpa folder, or a symlink to it
+	Path string `json:"path"`
+	// Scope is either user or global
+	Scope Scope `json:"scope"`
+	// Kind is either clone, archive, link or directory
+	Kind Kind `json:"kind"`
+	// Source is the git remote of clones, the path to the archive unpacked, or the folder linked repos point to
+	Source string `json:"source"`
+	// Clone is the folder with the git checkout of clones, or the contents of archives
+	Clone string `json:"clone,omitempty"`
+	// Ref is the branch, tag or commit clones were installed at, if any was requested
+	Ref string `json:"ref,omitempty"`
+	// Branch is the branch clones are checked out at, empty when pinned to a tag or commit
+	Branch string `json:"branch,omitempty"`
+	// Commit is the commit clones are checked out at
+	Commit string `json:"commit,omitempty"`
+	// SHA256 is the hash of the archive a repo was unpacked from
+	SHA256 string `json:"sha256,omitempty"`
+	// Trust tells if the repo's signature was verified when installed: verified, unsigned,
+	// untrusted, or local for repos that are not clones nor archives
+	Trust TrustStatus `json:"trust,omitempty"`
+	// Signer is the ID of the key that signed the repo
+	Signer string `json:"signer,omitempty"`
+	// Metadata is read from the repo's repo.yaml, if any
+	Metadata *Metadata `json:"metadata,omitempty"`
+}
+
+func (inst *Installed) String() string {
+	switch inst.Kind {
+	case KindClone:
+		return fmt.Sprintf("git clone from %s, repo at %s", inst.Source, inst.Clone)
+	case KindArchive:
+		return fmt.Sprintf("unpacked from %s (sha256 %s), repo at %s", inst.Source, inst.SHA256, inst.Clone)
+	case KindLink:
+		return fmt.Sprintf("local symlink, original at %s", inst.Source)
+	default:
+		return "source at local directory"
+	}
+}
+
+// initClone creates an empty git repo at dir that sparsely checks out the .milpa folder of source.
+func initClone(dir string, source string) error {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+
+	for _, args := range [][]string{
+		{"init", "-q"},
+		{"remote", "add", "origin", source},
+		{"config", "core.sparsecheckout", "true"},
+	} {
+		if _, err := git(dir, args...); err != nil {
+			return err
+		}
+	}
+
+	return os.WriteFile(filepath.Join(dir, ".git", "info", "sparse-checkout"), []byte(_c.RepoRoot+"/*\n"), 0644) // nolint: gosec
+}
+
+// defaultBranch returns the branch HEAD points to at source.
+func defaultBranch(source string) (string, error) {
+	out, err := git("", "ls-remote", "--symref", source, "HEAD")
+	if err != nil {
+		return "", err
+	}
+
+	for _, line := range strings.Split(out, "\n") {
+		if ref, found := strings.CutPrefix(line, "ref: refs/heads/"); found {
+			return strings.TrimSuffix(ref, "\tHEAD"), nil
+		}
+	}
+	return "", fmt.Errorf("could not find the default branch of %s", source)
+}
+
+// checkoutBranch updates the clone at dir to the latest commit of branch.
+func checkoutBranch(dir string, branch string) error {
+	if _, err := git(dir, "fetch", "-q", "--depth=1", "origin", branch); err != nil {
+		return err
+	}
+	_, err := git(dir, "checkout", "-q", "-B", branch, "FETCH_HEAD")
+	return err
+}
+
+// localRepo returns the folder containing a .milpa folder referred to by source, if any.
+func localRepo(source string) (string, bool) {
+	base, _, _ := strings.Cut(source, "/"+_c.RepoRoot+"/")
+	base = strings.TrimSuffix(strings.TrimSuffix(base, "/"), "/"+_c.RepoRoot)
+	if strings.HasPrefix(base, "~/") {
+		base = filepath.Join(os.Getenv("HOME"), base[2:])
+	}
+
+	if !util.IsDir(filepath.Join(base, _c.RepoRoot)) {
+		return "", false
+	}
+
+	abs, err := filepath.Abs(base)
+	return abs, err == nil
+}
+
+// Install symlinks a local folder with a .milpa folder into dirs, unpacks a
+// .tar.gz or .zip archive, or clones the .milpa folder of a git repo otherwise.
+// Clones track the remote's default branch, or ref if set, which may name a
+// branch, tag or commit. Archives and tags signed by one of trust's keys are
+// verified, and when trust has keys, repos that cannot be verified are refused
+// unless trust is insecure.
+func Install(source string, dirs Dirs, ref string, trust *Trust) (*Installed, error) {
+	if err := os.MkdirAll(dirs.Repos, 0755); err != nil {
+		return nil, err
+	}
+
+	if archive, ok := archivePath(source); ok {
+		if ref != "" {
+			return nil, fmt.Errorf("cannot install archive %s at ref %s, refs are only supported for git repos", archive, ref)
+		}
+		return installArchive(archive, dirs, trust)
+	}
+
+	if local, ok := localRepo(source); ok {
+		if ref != "" {
+			return nil, fmt.Errorf("cannot install local repo %s at ref %s, refs are only supported for git repos", local, ref)
+		}
+		log.Infof("Local repository detected at %s, symlinking...", local)
+		name := installName(source)
+		path := filepath.Join(dirs.Repos, name)
+		if _, err := os.Lstat(path); err == nil {
+			return nil, fmt.Errorf("a repo named %s already exists at %s", name, path)
+		}
+
+		if err := os.Symlink(filepath.Join(local, _c.RepoRoot), path); err != nil {
+			return nil, fmt.Errorf("could not symlink %s: %w", local, err)
+		}
+		return Inspect(path, dirs)
+	}
+
+	log.Infof("git repository detected, cloning %s...", source)
+	name := installName(source)
+	clone := filepath.Join(dirs.Clones, name)
+	path := filepath.Join(dirs.Repos, name)
+	if _, err := os.Stat(clone); err == nil {
+		return nil, fmt.Errorf("%s already present", clone)
+	}
+
+	if _, err := os.Lstat(path); err == nil {
+		return nil, fmt.Errorf("a repo named %s already exists at %s", name, path)
+	}
+
+	err := cloneAt(clone, source, ref, trust)
+	if err == nil {
+		err = os.Symlink(filepath.Join(clone, _c.RepoRoot), path)
+	}
+
+	if err != nil {
+		os.RemoveAll(clone) // nolint: errcheck
+		return nil, fmt.Errorf("could not clone %s: %w", source, err)
+	}
+
+	return Inspect(path, dirs)
+}
+
+// cloneAt clones source into dir, checked out at ref, or the default branch if ref is empty.
+func cloneAt(dir string, source string, ref string, trust *Trust) error {
+	if ref == "" {
+		branch, err := defaultBranch(source)
+		if err != nil {
+			return err
+		}
+
+		v, err := verifyRef(dir, RefBranch, branch, "", trust)
+		if err == nil {
+			err = trust.check(source, v)
+		}
+		if err == nil {
+			err = initClone(dir, source)
+		}
+		if err == nil {
+			err = checkoutBranch(dir, branch)
+		}
+		if err == nil {
+			err = recordTrust(dir, v)
+		}
+		return err
+	}
+
+	kind, commit, err := resolveRef(source, ref)
+	if err != nil {
+		return err
+	}
+
+	log.Infof("Installing %s %s at %s", kind, ref, short(commit))
+	var v *Verification
+	if kind == RefBranch {
+		v, err = verifyRef(dir, kind, ref, commit, trust)
+		if err == nil {
+			err = trust.check(source, v)
+		}
+		if err == nil {
+			err = initClone(dir, source)
+		}
+		if err == nil {
+			err = checkoutBranch(dir, ref)
+		}
+	} else {
+		// tags can only be verified once fetched, so checkout verifies them before their tree
+		// is checked out, and removes the clone when they fail to
+		_, err = checkout(dir, &Source{Source: source}, commit, func() error {
+			var err error
+			if v, err = verifyRef(dir, kind, ref, commit, trust); err == nil {
+				err = trust.check(source, v)
+			}
+			return err
+		})
+	}
+
+	if err == nil {
+		_, err = git(dir, "config", refConfig, ref)
+	}
+	if err == nil {
+		err = recordTrust(dir, v)
+	}
+	return err
+}
+
+// verifyRef checks the signature of ref, fetched into the clone at dir when a tag. Only
+// signed tags can be verified, branches and commits are always unsigned.
+func verifyRef(dir string, kind RefKind, ref string, commit string, trust *Trust) (*Verification, error) {
+	if kind == RefTag {
+		return verifyTag(dir, ref, commit, trust.keys())
+	}
+	return &Verification{Status: TrustUnsigned, Reason: fmt.Sprintf("%s %s is not a signed tag", kind, ref)}, nil
+}
+
+// Inspect describes the repo at path, installed to dirs.
+func Inspect(path string, dirs Dirs) (*Installed, error) {
+	fi, err := os.Lstat(path)
+	if err != nil {
+		return nil, err
+	}
+
+	inst := &Installed{
+		Name:   filepath.Base(path),
+		Path:   path,
+		Scope:  dirs.Scope,
+		Kind:   KindDirectory,
+		Source: path,
+		Trust:  TrustLocal,
+	}
+
+	if inst.Metadata, err = ReadMetadata(path); err != nil {
+		log.Warnf("Ignoring metadata of repo %s: %s", path, err)
+	}
+
+	if fi.Mode()&os.ModeSymlink == 0 {
+		return inst, nil
+	}
+
+	target, err := os.Readlink(path)
+	if err != nil {
+		return nil, err
+	}
+
+	inst.Kind = KindLink
+	inst.Source = target
+	inst.Trust = TrustLocal
+	if !strings.HasPrefix(target, dirs.Clones+string(filepath.Separator)) {
+		return inst, nil
+	}
+
+	inst.Clone = filepath.Dir(target)
+	if info, err := ReadArchiveInfo(inst.Clone); err == nil {
+		inst.Kind = KindArchive
+		inst.Source = info.Source
+		inst.SHA256 = info.SHA256
+		inst.Trust = info.Trust
+		inst.Signer = info.Signer
+		return inst, nil
+	}
+
+	inst.Kind = KindClone
+	if inst.Source, err = git(inst.Clone, "remote", "get-url", "origin"); err != nil {
+		return nil, err
+	}
+	if inst.Commit, err = git(inst.Clone, "rev-parse", "HEAD"); err != nil {
+		return nil, err
+	}
+	inst.Branch, _ = git(inst.Clone, "branch", "--show-current")
+	// unset refs make git config exit with status 1
+	inst.Ref, _ = git(inst.Clone, "config", "--get", refConfig)
+	// repos installed before verification existed have no trust recorded
+	trust, _ := git(inst.Clone, "config", "--get", trustConfig)
+	inst.Trust = TrustStatus(trust)
+	inst.Signer, _ = git(inst.Clone, "config", "--get", signerConfig)
+
+	return inst, nil
+}
+
+// List returns the repos installed to each of dirs, sorted by name.
+func List(dirs ...Dirs) ([]*Installed, error) {
+	res := []*Installed{}
+	for _, d := range dirs {
+		entries, err := os.ReadDir(d.Repos)
+		if os.IsNotExist(err) {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+
+		found := []*Installed{}
+		for _, entry := range entries {
+			path := filepath.Join(d.Repos, entry.Name())
+			if fi, err := os.Stat(path); err != nil || !fi.IsDir() {
+				log.Debugf("ignoring %s, not a directory", path)
+				continue
+			}
+
+			inst, err := Inspect(path, d)
+			if err != nil {
+				return nil, err
+			}
+			found = append(found, inst)
+		}
+
+		sort.Slice(found, func(i, j int) bool { return found[i].Name < found[j].Name })
+		res = append(res, found...)
+	}
+
+	return res, nil
+}
+
+// Find returns the installed repo referred to by query: its path, clone folder, or name.
+func Find(query string, dirs ...Dirs) (*Installed, error) {
+	installed, err := List(dirs...)
+	if err != nil {
+		return nil, err
+	}
+
+	clean := filepath.Clean(query)
+	for _, inst := range installed {
+		if inst.Path == clean || (inst.Clone != "" && inst.Clone == clean) || inst.Name == query {
+			return inst, nil
+		}
+	}
+
+	return nil, fmt.Errorf("no repo installed at %s", query)
+}
+
+// Upgrade updates a cloned repo to the latest commit of the branch it tracks. Repos pinned
+// to a tag or commit are left as they are, unless latest is set: then, repos pinned to a
+// version tag move to the highest version at their remote, and repos pinned to a commit
+// start tracking the remote's default branch. Like with Install, the new ref is verified
+// against trust's keys.
+func Upgrade(inst *Installed, latest bool, trust *Trust) (*Installed, error) {
+	if inst.Kind == KindArchive {
+		return nil, fmt.Errorf("%s was unpacked from %s, uninstall it and install a newer archive instead", inst.Path, inst.Source)
+	}
+
+	if inst.Kind != KindClone {
+		return nil, fmt.Errorf("%s is not a git clone, and cannot be upgraded", inst.Path)
+	}
+
+	kind := pinKind(inst)
+	switch {
+	case kind == "":
+		return nil, fmt.Errorf("%s is pinned to commit %s, use `milpa itself repo sync` to change it", inst.Path, inst.Commit)
+	case kind != RefBranch && !latest:
+		log.Infof("%s is pinned to %s %s, use --latest to upgrade it anyway", inst.Path, kind, inst.Ref)
+		return inst, nil
+	}
+
+	log.Infof("Upgrading %s", inst.Path)
+	var err error
+	var v *Verification
+	switch kind {
+	case RefBranch:
+		if v, err = verifyRef(inst.Clone, kind, inst.Branch, "", trust); err == nil {
+			if err = trust.check(inst.Path, v); err == nil {
+				err = checkoutBranch(inst.Clone, inst.Branch)
+			}
+		}
+	case RefTag:
+		var tag, commit string
+		if tag, commit, err = newestTag(inst.Source, inst.Ref); err == nil {
+			if v, err = verifyRef(inst.Clone, kind, tag, commit, trust); err == nil {
+				err = trust.check(inst.Path, v)
+			}
+		}
+		if err == nil {
+			if _, err = checkout(inst.Clone, &Source{Source: inst.Source}, commit, nil); err == nil {
+				_, err = git(inst.Clone, "config", refConfig, tag)
+			}
+		}
+	case RefCommit:
+		var branch string
+		if branch, err = defaultBranch(inst.Source); err == nil {
+			if v, err = verifyRef(inst.Clone, RefBranch, branch, "", trust); err == nil {
+				err = trust.check(inst.Path, v)
+			}
+		}
+		if err == nil {
+			if err = checkoutBranch(inst.Clone, branch); err == nil {
+				_, err = git(inst.Clone, "config", "--unset", refConfig)
+			}
+		}
+	}
+
+	if err == nil {
+		err = recordTrust(inst.Clone, v)
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("could not upgrade %s: %w", inst.Path, err)
+	}
+
+	return Inspect(inst.Path, dirsAt(inst.Scope, filepath.Dir(filepath.Dir(inst.Clone))))
+}
+
+// Uninstall removes an installed repo, along with its clone, if any.
+func Uninstall(inst *Installed) error {
+	if inst.Kind == KindClone || inst.Kind == KindArchive {
+		log.Infof("removing cloned source at %s", inst.Clone)
+		if err := os.RemoveAll(inst.Clone); err != nil {
+			return err
+		}
+	}
+
+	if inst.Kind == KindDirectory {
+		return os.RemoveAll(inst.Path)
+	}
+	return os.Remove(inst.Path)
+}
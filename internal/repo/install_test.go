@@ -0,0 +1,160 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright © 2021 Roberto Hidalgo <milpa@un.rob.mx>
+package repo_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	. "github.com/unrob/milpa/internal/repo"
+)
+
+func TestUserDirs(t *testing.T) {
+	t.Setenv("XDG_DATA_HOME", "/xdg")
+	t.Setenv("HOME", "/home/milpa")
+	if dirs, ok := UserDirs(); !ok || dirs.Repos != "/xdg/milpa/repos" || dirs.Clones != "/xdg/milpa/clones" {
+		t.Fatalf("unexpected dirs with XDG_DATA_HOME: %+v", dirs)
+	}
+
+	t.Setenv("XDG_DATA_HOME", "")
+	if dirs, ok := UserDirs(); !ok || dirs.Repos != "/home/milpa/.local/share/milpa/repos" {
+		t.Fatalf("unexpected dirs with HOME: %+v", dirs)
+	}
+
+	t.Setenv("HOME", "")
+	if dirs, ok := UserDirs(); ok {
+		t.Fatalf("found dirs without XDG_DATA_HOME nor HOME: %+v", dirs)
+	}
+}
+
+func TestInstall(t *testing.T) {
+	dirs := GlobalDirs(t.TempDir())
+
+	t.Run("clone", func(t *testing.T) {
+		source, commit := remoteRepo(t)
+		first := commit("first")
+
+		inst, err := Install(source, dirs, "", nil)
+		if err != nil {
+			t.Fatalf("could not install: %s", err)
+		}
+
+		if inst.Kind != KindClone || inst.Scope != ScopeGlobal || inst.Branch != "main" || inst.Commit != first || inst.Source != source {
+			t.Fatalf("unexpected install: %+v", inst)
+		}
+
+		if _, err := os.Stat(filepath.Join(inst.Path, "commands", "hello.sh")); err != nil {
+			t.Fatalf("command not installed: %s", err)
+		}
+
+		if _, err := Install(source, dirs, "", nil); err == nil {
+			t.Fatal("installed the same repo twice")
+		}
+
+		second := commit("second")
+		upgraded, err := Upgrade(inst, false, nil)
+		if err != nil {
+			t.Fatalf("could not upgrade: %s", err)
+		}
+
+		if upgraded.Commit != second {
+			t.Fatalf("upgraded to %s, expected %s", upgraded.Commit, second)
+		}
+
+		if err := Uninstall(upgraded); err != nil {
+			t.Fatalf("could not uninstall: %s", err)
+		}
+
+		for _, path := range []string{upgraded.Path, upgraded.Clone} {
+			if _, err := os.Lstat(path); !os.IsNotExist(err) {
+				t.Fatalf("%s was not removed", path)
+			}
+		}
+	})
+
+	t.Run("link", func(t *testing.T) {
+		local := filepath.Join(t.TempDir(), ".dotfiles")
+		if err := os.MkdirAll(filepath.Join(local, ".milpa", "commands"), 0755); err != nil {
+			t.Fatal(err)
+		}
+
+		inst, err := Install(local+"/.milpa", dirs, "", nil)
+		if err != nil {
+			t.Fatalf("could not install: %s", err)
+		}
+
+		if inst.Kind != KindLink || inst.Name != "dotfiles" || inst.Source != filepath.Join(local, ".milpa") {
+			t.Fatalf("unexpected install: %+v", inst)
+		}
+
+		if _, err := Upgrade(inst, false, nil); err == nil {
+			t.Fatal("upgraded a linked repo")
+		}
+
+		if err := Uninstall(inst); err != nil {
+			t.Fatalf("could not uninstall: %s", err)
+		}
+
+		if _, err := os.Stat(local); err != nil {
+			t.Fatalf("uninstalling removed the original folder: %s", err)
+		}
+	})
+
+	t.Run("missing remote", func(t *testing.T) {
+		source := "file://" + filepath.Join(t.TempDir(), "missing")
+		if _, err := Install(source, dirs, "", nil); err == nil {
+			t.Fatal("installed a missing repo")
+		}
+
+		if _, err := os.Stat(filepath.Join(dirs.Clones, CleanName(source))); !os.IsNotExist(err) {
+			t.Fatal("failed clone was not cleaned up")
+		}
+	})
+}
+
+func TestList(t *testing.T) {
+	user := GlobalDirs(t.TempDir())
+	user.Scope = ScopeUser
+	global := GlobalDirs(t.TempDir())
+
+	source, commit := remoteRepo(t)
+	commit("first")
+	if _, err := Install(source, user, "", nil); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.MkdirAll(filepath.Join(global.Repos, "manual", "commands"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(global.Repos, "README.md"), []byte("not a repo"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	installed, err := List(user, global, GlobalDirs(filepath.Join(t.TempDir(), "missing")))
+	if err != nil {
+		t.Fatalf("could not list: %s", err)
+	}
+
+	if len(installed) != 2 {
+		t.Fatalf("unexpected repos: %+v", installed)
+	}
+
+	if installed[0].Scope != ScopeUser || installed[0].Kind != KindClone {
+		t.Fatalf("unexpected user repo: %+v", installed[0])
+	}
+
+	if installed[1].Scope != ScopeGlobal || installed[1].Kind != KindDirectory || installed[1].Name != "manual" {
+		t.Fatalf("unexpected global repo: %+v", installed[1])
+	}
+
+	for _, query := range []string{installed[0].Path, installed[0].Clone, installed[0].Name} {
+		if found, err := Find(query, user, global); err != nil || found.Path != installed[0].Path {
+			t.Fatalf("could not find %s: %v", query, err)
+		}
+	}
+
+	if _, err := Find("unknown", user, global); err == nil {
+		t.Fatal("found an unknown repo")
+	}
+}
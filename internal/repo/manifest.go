@@ -0,0 +1,123 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright © 2021 Roberto Hidalgo <milpa@un.rob.mx>
+package repo
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// ManifestName is the default file name of a repo manifest.
+const ManifestName = "milpa.repos.yaml"
+
+// LockfileName is the name of the lockfile written next to a manifest.
+const LockfileName = "milpa.repos.lock"
+
+// Source is a repo listed in a manifest.
+type Source struct {
+	// Name is the folder the repo is installed to, defaults to a cleaned up Source
+	Name string `yaml:"name,omitempty" json:"name"`
+	// Source is a git url to clone the repo from
+	Source string `yaml:"source" json:"source"`
+	// Ref is the branch, tag or commit to install, defaults to the remote's HEAD
+	Ref string `yaml:"ref,omitempty" json:"ref,omitempty"`
+}
+
+// Manifest lists the repos a project needs installed.
+type Manifest struct {
+	Repos []*Source `yaml:"repos" json:"repos"`
+}
+
+// Locked is a repo from a manifest resolved to a commit.
+type Locked struct {
+	Source `yaml:",inline"`
+	Commit string `yaml:"commit" json:"commit"`
+}
+
+// Lockfile pins the repos of a manifest to the commits they resolved to.
+type Lockfile struct {
+	Repos []*Locked `yaml:"repos" json:"repos"`
+}
+
+// LockfilePath returns the path to the lockfile for the manifest at manifestPath.
+func LockfilePath(manifestPath string) string {
+	return filepath.Join(filepath.Dir(manifestPath), LockfileName)
+}
+
+// ReadManifest parses and validates the manifest at path.
+func ReadManifest(path string) (*Manifest, error) {
+	contents, err := os.ReadFile(path) // nolint: gosec
+	if err != nil {
+		return nil, err
+	}
+
+	manifest := &Manifest{}
+	if err := yaml.Unmarshal(contents, manifest); err != nil {
+		return nil, fmt.Errorf("invalid manifest %s: %w", path, err)
+	}
+
+	names := map[string]bool{}
+	for idx, src := range manifest.Repos {
+		if src == nil || src.Source == "" {
+			return nil, fmt.Errorf("invalid manifest %s: repo #%d has no source", path, idx+1)
+		}
+
+		if src.Name == "" {
+			src.Name = CleanName(src.Source)
+		}
+
+		if strings.ContainsAny(src.Name, `/\`) || strings.HasPrefix(src.Name, ".") {
+			return nil, fmt.Errorf("invalid manifest %s: repo name <%s> must not contain slashes or start with a dot", path, src.Name)
+		}
+
+		if names[src.Name] {
+			return nil, fmt.Errorf("invalid manifest %s: repo <%s> is listed more than once", path, src.Name)
+		}
+		names[src.Name] = true
+	}
+
+	return manifest, nil
+}
+
+// ReadLockfile parses the lockfile at path, returning an empty one if it does not exist.
+func ReadLockfile(path string) (*Lockfile, error) {
+	lock := &Lockfile{Repos: []*Locked{}}
+	contents, err := os.ReadFile(path) // nolint: gosec
+	if os.IsNotExist(err) {
+		return lock, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	if err := yaml.Unmarshal(contents, lock); err != nil {
+		return nil, fmt.Errorf("invalid lockfile %s: %w", path, err)
+	}
+	return lock, nil
+}
+
+// Find returns the locked commit for src, if it was locked with the same source and ref.
+func (lock *Lockfile) Find(src *Source) string {
+	for _, locked := range lock.Repos {
+		if locked.Name == src.Name && locked.Source.Source == src.Source && locked.Ref == src.Ref {
+			return locked.Commit
+		}
+	}
+	return ""
+}
+
+// Write stores the lockfile at path.
+func (lock *Lockfile) Write(path string) error {
+	var buf bytes.Buffer
+	buf.WriteString("# generated by `milpa itself repo sync`, do not edit by hand\n")
+	enc := yaml.NewEncoder(&buf)
+	enc.SetIndent(2)
+	if err := enc.Encode(lock); err != nil {
+		return err
+	}
+	return os.WriteFile(path, buf.Bytes(), 0644) // nolint: gosec
+}
@@ -0,0 +1,170 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright © 2021 Roberto Hidalgo <milpa@un.rob.mx>
+package repo
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	_c "github.com/unrob/milpa/internal/constants"
+	"gopkg.in/yaml.v3"
+)
+
+// Metadata describes a repo, as read from the optional repo.yaml in its .milpa folder.
+type Metadata struct {
+	// Name is what the repo is called, instead of the name of its folder
+	Name string `json:"name,omitempty" yaml:"name,omitempty"`
+	// Version is the repo's version, i.e. 1.4.0
+	Version string `json:"version,omitempty" yaml:"version,omitempty"`
+	// Description tells what the repo's commands are for
+	Description string `json:"description,omitempty" yaml:"description,omitempty"`
+	// Maintainers are the people responsible for the repo, i.e. `Jane Doe <jane@example.com>`
+	Maintainers []string `json:"maintainers,omitempty" yaml:"maintainers,omitempty"`
+	// RequiresMilpa constrains the milpa versions the repo works with, i.e. `>=0.9, <2`
+	RequiresMilpa string `json:"requires-milpa,omitempty" yaml:"requires-milpa,omitempty"`
+	// Depends lists the repos this one requires, as `source@ref`, see ParseDependency
+	Depends []string `json:"depends,omitempty" yaml:"depends,omitempty"`
+	// Mount is the command prefix the repo's commands are available under, i.e. `infra`
+	Mount string `json:"mount,omitempty" yaml:"mount,omitempty"`
+}
+
+// ReadMetadata reads the repo.yaml of the .milpa folder at path, returning nil if there is none.
+func ReadMetadata(path string) (*Metadata, error) {
+	file := filepath.Join(path, _c.RepoMetadataName)
+	contents, err := os.ReadFile(file) // nolint: gosec
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	meta := &Metadata{}
+	if err := yaml.Unmarshal(contents, meta); err != nil {
+		return nil, fmt.Errorf("could not parse %s: %w", file, err)
+	}
+
+	if _, err := parseConstraints(meta.RequiresMilpa); err != nil {
+		return nil, fmt.Errorf("invalid requires-milpa in %s: %w", file, err)
+	}
+
+	if meta.Mount != "" && !ValidMount(meta.Mount) {
+		return nil, fmt.Errorf("invalid mount in %s: %q is not a valid command name", file, meta.Mount)
+	}
+
+	for _, dep := range meta.Depends {
+		if strings.TrimSpace(dep) == "" {
+			return nil, fmt.Errorf("invalid depends in %s: empty dependency", file)
+		}
+	}
+	return meta, nil
+}
+
+func (meta *Metadata) String() string {
+	if meta == nil || meta.Name == "" {
+		return ""
+	}
+
+	if meta.Version == "" {
+		return meta.Name
+	}
+	return meta.Name + " " + meta.Version
+}
+
+// constraint is a single comparison against a version, like `>=0.9`.
+type constraint struct {
+	op      string
+	version []int
+}
+
+var constraintOps = []string{">=", "<=", "!=", "==", ">", "<", "="}
+
+// parseConstraints reads comma or space separated constraints, like `>=0.9, <2` or `>= 0.9`.
+// Versions with no operator must match exactly.
+func parseConstraints(spec string) ([]constraint, error) {
+	res := []constraint{}
+	pending := ""
+	for _, part := range strings.FieldsFunc(spec, func(r rune) bool { return r == ',' || r == ' ' }) {
+		op := ""
+		for _, candidate := range constraintOps {
+			if rest, found := strings.CutPrefix(part, candidate); found {
+				op, part = candidate, rest
+				break
+			}
+		}
+
+		switch {
+		case op != "" && part == "":
+			// operators may be separated from their version, as in `>= 0.9`
+			if pending != "" {
+				return nil, fmt.Errorf("%q is missing a version", pending)
+			}
+			pending = op
+			continue
+		case pending != "" && op != "":
+			return nil, fmt.Errorf("%q is missing a version", pending)
+		case pending != "":
+			op, pending = pending, ""
+		case op == "":
+			op = "="
+		}
+
+		v, ok := version(part)
+		if !ok {
+			return nil, fmt.Errorf("%q is not a version", part)
+		}
+		res = append(res, constraint{op: op, version: v})
+	}
+
+	if pending != "" {
+		return nil, fmt.Errorf("%q is missing a version", pending)
+	}
+	return res, nil
+}
+
+func (c constraint) matches(v []int) bool {
+	cmp := compareVersions(v, c.version)
+	switch c.op {
+	case ">=":
+		return cmp >= 0
+	case "<=":
+		return cmp <= 0
+	case ">":
+		return cmp > 0
+	case "<":
+		return cmp < 0
+	case "!=":
+		return cmp != 0
+	default:
+		return cmp == 0
+	}
+}
+
+// Supports returns an error if the repo requires a milpa version other than milpaVersion.
+// Development builds, with versions that are not semver, support every repo.
+func (meta *Metadata) Supports(milpaVersion string) error {
+	if meta == nil || meta.RequiresMilpa == "" {
+		return nil
+	}
+
+	// pre-release and build suffixes are ignored, so 1.0.0-beta.1 satisfies >=1.0
+	current, _, _ := strings.Cut(strings.SplitN(milpaVersion, "+", 2)[0], "-")
+	v, ok := version(current)
+	if !ok {
+		log.Debugf("milpa version %s is not a release, skipping requires-milpa %s", milpaVersion, meta.RequiresMilpa)
+		return nil
+	}
+
+	constraints, err := parseConstraints(meta.RequiresMilpa)
+	if err != nil {
+		return err
+	}
+
+	for _, c := range constraints {
+		if !c.matches(v) {
+			return fmt.Errorf("requires milpa %s, but this is milpa %s", meta.RequiresMilpa, milpaVersion)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,121 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright © 2021 Roberto Hidalgo <milpa@un.rob.mx>
+package repo_test
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	. "github.com/unrob/milpa/internal/repo"
+)
+
+func TestReadMetadata(t *testing.T) {
+	dir := t.TempDir()
+	if meta, err := ReadMetadata(dir); meta != nil || err != nil {
+		t.Fatalf("unexpected metadata for repo without repo.yaml: %+v, %v", meta, err)
+	}
+
+	writeFile(t, filepath.Join(dir, "repo.yaml"), `name: infra
+version: 1.4.0
+description: Operates our infrastructure
+maintainers:
+  - Jane Doe <jane@example.com>
+requires-milpa: ">=0.9, <2"
+mount: infra
+`)
+	meta, err := ReadMetadata(dir)
+	if err != nil {
+		t.Fatalf("could not read metadata: %s", err)
+	}
+
+	expected := &Metadata{
+		Name:          "infra",
+		Version:       "1.4.0",
+		Description:   "Operates our infrastructure",
+		Maintainers:   []string{"Jane Doe <jane@example.com>"},
+		RequiresMilpa: ">=0.9, <2",
+		Mount:         "infra",
+	}
+	if !reflect.DeepEqual(meta, expected) || meta.String() != "infra 1.4.0" {
+		t.Fatalf("unexpected metadata: %+v", meta)
+	}
+
+	for name, contents := range map[string]string{
+		"bad yaml":       "name: [",
+		"bad constraint": "requires-milpa: \">=latest\"",
+		"no version":     "requires-milpa: \">= \"",
+		"two operators":  "requires-milpa: \">= <1.0\"",
+		"bad mount":      "mount: infra/deploy",
+	} {
+		writeFile(t, filepath.Join(dir, "repo.yaml"), contents)
+		if _, err := ReadMetadata(dir); err == nil {
+			t.Fatalf("read metadata with %s", name)
+		}
+	}
+
+	if err := os.Remove(filepath.Join(dir, "repo.yaml")); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSupports(t *testing.T) {
+	for _, test := range []struct {
+		requires  string
+		version   string
+		supported bool
+	}{
+		{"", "0.1.0", true},
+		{">=0.9", "0.9.0", true},
+		{">=0.9", "0.8.12", false},
+		{">=0.9, <2", "1.12.0", true},
+		{">=0.9 <2", "2.0.0", false},
+		{">0.9", "0.9", false},
+		{"<=1.0", "1.0.0", true},
+		{"!=1.2.3", "1.2.3", false},
+		{"1.2", "1.2.0", true},
+		{"=1.2", "v1.2.1", false},
+		{">=1.0", "1.0.0-rc.1+abc", true},
+		{">=1.0", "beta", true},
+		{">= 0.9", "0.9.0", true},
+		{">= 0.9", "0.8.0", false},
+		{">= 0.9, < 2", "2.1.0", false},
+		{"> 0.9 <= 1.0", "1.0.0", true},
+	} {
+		err := (&Metadata{RequiresMilpa: test.requires}).Supports(test.version)
+		if (err == nil) != test.supported {
+			t.Errorf("requires-milpa %q with milpa %s: expected supported=%v, got %v", test.requires, test.version, test.supported, err)
+		}
+	}
+
+	var missing *Metadata
+	if err := missing.Supports("1.0.0"); err != nil {
+		t.Fatalf("repo without metadata is unsupported: %s", err)
+	}
+}
+
+func TestSplitMount(t *testing.T) {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		t.Skip("no home directory")
+	}
+
+	for entry, expected := range map[string][2]string{
+		"/code/infra":             {"", "/code/infra"},
+		"infra=/code/infra":       {"infra", "/code/infra"},
+		"infra=~/code/infra":      {"infra", filepath.Join(home, "code/infra")},
+		"~/code/infra":            {"", filepath.Join(home, "code/infra")},
+		"/code/a=b":               {"", "/code/a=b"},
+		"Not A Mount=/code/infra": {"", "Not A Mount=/code/infra"},
+	} {
+		mount, path := SplitMount(entry)
+		if mount != expected[0] || path != expected[1] {
+			t.Errorf("splitting %s: expected %v, got %s, %s", entry, expected, mount, path)
+		}
+
+		if expected[1] == entry && JoinMount(mount, path) != entry {
+			t.Errorf("%s does not round-trip, got %s", entry, JoinMount(mount, path))
+		}
+	}
+}
@@ -0,0 +1,42 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright © 2021 Roberto Hidalgo <milpa@un.rob.mx>
+package repo
+
+import (
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+var mountPattern = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]*$`)
+
+// ValidMount tells if name can prefix the commands of a repo, like `infra`.
+func ValidMount(name string) bool {
+	return mountPattern.MatchString(name)
+}
+
+// SplitMount splits a MILPA_PATH entry like `infra=~/code/infra` into the command prefix
+// its repo is mounted under and its path, expanding a leading `~/`. Entries with no valid
+// prefix are returned as paths.
+func SplitMount(entry string) (mount string, path string) {
+	path = entry
+	if prefix, rest, found := strings.Cut(entry, "="); found && ValidMount(prefix) {
+		mount, path = prefix, rest
+	}
+
+	if rest, found := strings.CutPrefix(path, "~/"); found {
+		if home, err := os.UserHomeDir(); err == nil {
+			path = filepath.Join(home, rest)
+		}
+	}
+	return mount, path
+}
+
+// JoinMount returns the MILPA_PATH entry for path mounted under mount, if any.
+func JoinMount(mount string, path string) string {
+	if mount == "" {
+		return path
+	}
+	return mount + "=" + path
+}
@@ -0,0 +1,232 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright © 2021 Roberto Hidalgo <milpa@un.rob.mx>
+package repo
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// refConfig is the git config key of clones holding the ref they were installed at.
+const refConfig = "milpa.ref"
+
+// RefKind tells what a ref names at a remote.
+type RefKind string
+
+const (
+	RefBranch RefKind = "branch"
+	RefTag    RefKind = "tag"
+	RefCommit RefKind = "commit"
+)
+
+// Drift describes how far behind its remote an installed repo is.
+type Drift struct {
+	*Installed
+	// Latest is the ref upgrading with --latest would move to
+	Latest string `json:"latest"`
+	// LatestCommit is the commit Latest points to
+	LatestCommit string `json:"latest-commit"`
+	// Error tells why the latest version could not be found, if it couldn't
+	Error string `json:"error,omitempty"`
+}
+
+func (d *Drift) String() string {
+	if d.Error != "" {
+		return d.Error
+	}
+
+	current := d.Ref
+	if current == "" {
+		current = d.Branch
+	}
+	return fmt.Sprintf("%s (%s) is behind %s (%s)", current, short(d.Commit), d.Latest, short(d.LatestCommit))
+}
+
+func short(commit string) string {
+	if len(commit) > 7 {
+		return commit[:7]
+	}
+	return commit
+}
+
+// remoteRefs lists refs at a remote, keyed by name; args are passed to `git ls-remote`.
+func remoteRefs(args ...string) (map[string]string, error) {
+	out, err := git("", append([]string{"ls-remote"}, args...)...)
+	if err != nil {
+		return nil, err
+	}
+
+	refs := map[string]string{}
+	for _, line := range strings.Split(out, "\n") {
+		if commit, name, found := strings.Cut(line, "\t"); found {
+			refs[name] = commit
+		}
+	}
+	return refs, nil
+}
+
+// resolveRef finds what ref names at source, and the commit it points to.
+func resolveRef(source string, ref string) (RefKind, string, error) {
+	if commitPattern.MatchString(ref) {
+		return RefCommit, ref, nil
+	}
+
+	name := ref
+	if name == "" {
+		name = "HEAD"
+	}
+
+	refs, err := remoteRefs(source, name, name+"^{}")
+	if err != nil {
+		return "", "", err
+	}
+
+	// annotated tags resolve to the commit they point to, listed with a ^{} suffix
+	for _, candidate := range []struct {
+		name string
+		kind RefKind
+	}{
+		{"refs/tags/" + name + "^{}", RefTag},
+		{"refs/tags/" + name, RefTag},
+		{"refs/heads/" + name, RefBranch},
+		{name, RefBranch},
+	} {
+		if commit, ok := refs[candidate.name]; ok {
+			return candidate.kind, commit, nil
+		}
+	}
+
+	return "", "", fmt.Errorf("could not find ref <%s> at %s", name, source)
+}
+
+// version parses tags like v1.2.3 or 1.2 into their numeric parts.
+func version(tag string) ([]int, bool) {
+	parts := strings.Split(strings.TrimPrefix(tag, "v"), ".")
+	res := make([]int, len(parts))
+	for idx, part := range parts {
+		n, err := strconv.Atoi(part)
+		if err != nil {
+			return nil, false
+		}
+		res[idx] = n
+	}
+	return res, true
+}
+
+// compareVersions returns a negative number if a is older than b, zero if they are the same
+// version, and a positive number otherwise.
+func compareVersions(a, b []int) int {
+	for idx := 0; idx < len(a) || idx < len(b); idx++ {
+		var x, y int
+		if idx < len(a) {
+			x = a[idx]
+		}
+		if idx < len(b) {
+			y = b[idx]
+		}
+		if x != y {
+			return x - y
+		}
+	}
+	return 0
+}
+
+// newestTag returns the highest version tag at source newer than tag, if any.
+func newestTag(source string, tag string) (string, string, error) {
+	current, ok := version(tag)
+	if !ok {
+		log.Debugf("%s is not a version, looking for newer commits instead", tag)
+		_, commit, err := resolveRef(source, tag)
+		return tag, commit, err
+	}
+
+	refs, err := remoteRefs("--tags", source)
+	if err != nil {
+		return "", "", err
+	}
+
+	newest, newestVersion := tag, current
+	for name := range refs {
+		name = strings.TrimPrefix(name, "refs/tags/")
+		if strings.HasSuffix(name, "^{}") {
+			continue
+		}
+
+		if v, ok := version(name); ok && compareVersions(v, newestVersion) > 0 {
+			newest, newestVersion = name, v
+		}
+	}
+
+	commit, ok := refs["refs/tags/"+newest+"^{}"]
+	if !ok {
+		commit = refs["refs/tags/"+newest]
+	}
+	return newest, commit, nil
+}
+
+// pinKind returns the kind of ref an installed clone is pinned to, if any.
+func pinKind(inst *Installed) RefKind {
+	switch {
+	case inst.Branch != "":
+		return RefBranch
+	case inst.Ref == "":
+		return ""
+	case commitPattern.MatchString(inst.Ref):
+		return RefCommit
+	default:
+		return RefTag
+	}
+}
+
+// Latest finds the newest ref an installed clone could be upgraded to: the head of the branch it
+// tracks, the highest version tag for repos pinned to tags, or the default branch otherwise.
+func Latest(inst *Installed) (*Drift, error) {
+	drift := &Drift{Installed: inst}
+	var err error
+	switch pinKind(inst) {
+	case RefBranch:
+		drift.Latest = inst.Branch
+		_, drift.LatestCommit, err = resolveRef(inst.Source, inst.Branch)
+	case RefTag:
+		drift.Latest, drift.LatestCommit, err = newestTag(inst.Source, inst.Ref)
+	case RefCommit:
+		if drift.Latest, err = defaultBranch(inst.Source); err == nil {
+			_, drift.LatestCommit, err = resolveRef(inst.Source, drift.Latest)
+		}
+	default:
+		return nil, fmt.Errorf("%s is managed by `milpa itself repo sync`", inst.Path)
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("could not find the latest version of %s: %w", inst.Path, err)
+	}
+	return drift, nil
+}
+
+// Outdated returns the cloned repos among installed with newer commits or tags at their remote.
+// Repos installed from a manifest are left to `milpa itself repo sync`. Repos that could not be
+// checked are returned with their Error set, and their errors joined.
+func Outdated(installed []*Installed) ([]*Drift, error) {
+	res := []*Drift{}
+	errs := []error{}
+	for _, inst := range installed {
+		if inst.Kind != KindClone || pinKind(inst) == "" {
+			continue
+		}
+
+		log.Debugf("checking %s for updates", inst.Path)
+		drift, err := Latest(inst)
+		if err != nil {
+			res = append(res, &Drift{Installed: inst, Error: err.Error()})
+			errs = append(errs, err)
+			continue
+		}
+
+		if drift.LatestCommit != inst.Commit {
+			res = append(res, drift)
+		}
+	}
+	return res, errors.Join(errs...)
+}
@@ -0,0 +1,126 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright © 2021 Roberto Hidalgo <milpa@un.rob.mx>
+package repo_test
+
+import (
+	"path/filepath"
+	"testing"
+
+	. "github.com/unrob/milpa/internal/repo"
+)
+
+func TestPinnedInstall(t *testing.T) {
+	source, commit := remoteRepo(t)
+	remote := filepath.Clean(source[len("file://"):])
+	first := commit("first")
+	run(t, remote, "git", "tag", "v1.9.0")
+	second := commit("second")
+	run(t, remote, "git", "tag", "-a", "-m", "release", "v1.10.0")
+	run(t, remote, "git", "checkout", "-q", "-b", "next")
+	next := commit("next")
+	run(t, remote, "git", "checkout", "-q", "main")
+	third := commit("third")
+
+	t.Run("tag", func(t *testing.T) {
+		dirs := GlobalDirs(t.TempDir())
+		inst, err := Install(source, dirs, "v1.9.0", nil)
+		if err != nil {
+			t.Fatalf("could not install: %s", err)
+		}
+
+		if inst.Ref != "v1.9.0" || inst.Branch != "" || inst.Commit != first {
+			t.Fatalf("unexpected install: %+v", inst)
+		}
+
+		outdated, err := Outdated([]*Installed{inst})
+		if err != nil {
+			t.Fatalf("could not check for updates: %s", err)
+		}
+
+		if len(outdated) != 1 || outdated[0].Latest != "v1.10.0" || outdated[0].LatestCommit != second {
+			t.Fatalf("unexpected drift: %+v", outdated)
+		}
+
+		if upgraded, err := Upgrade(inst, false, nil); err != nil || upgraded.Commit != first {
+			t.Fatalf("upgrade did not respect pin: %+v, %v", upgraded, err)
+		}
+
+		upgraded, err := Upgrade(inst, true, nil)
+		if err != nil {
+			t.Fatalf("could not upgrade: %s", err)
+		}
+
+		if upgraded.Ref != "v1.10.0" || upgraded.Commit != second {
+			t.Fatalf("did not upgrade to the latest tag: %+v", upgraded)
+		}
+
+		if outdated, err := Outdated([]*Installed{upgraded}); err != nil || len(outdated) != 0 {
+			t.Fatalf("latest tag reported as outdated: %+v, %v", outdated, err)
+		}
+	})
+
+	t.Run("branch", func(t *testing.T) {
+		dirs := GlobalDirs(t.TempDir())
+		inst, err := Install(source, dirs, "next", nil)
+		if err != nil {
+			t.Fatalf("could not install: %s", err)
+		}
+
+		if inst.Ref != "next" || inst.Branch != "next" || inst.Commit != next {
+			t.Fatalf("unexpected install: %+v", inst)
+		}
+
+		if outdated, err := Outdated([]*Installed{inst}); err != nil || len(outdated) != 0 {
+			t.Fatalf("up to date branch reported as outdated: %+v, %v", outdated, err)
+		}
+	})
+
+	t.Run("commit", func(t *testing.T) {
+		dirs := GlobalDirs(t.TempDir())
+		inst, err := Install(source, dirs, first, nil)
+		if err != nil {
+			t.Fatalf("could not install: %s", err)
+		}
+
+		outdated, err := Outdated([]*Installed{inst})
+		if err != nil || len(outdated) != 1 || outdated[0].Latest != "main" || outdated[0].LatestCommit != third {
+			t.Fatalf("unexpected drift: %+v, %v", outdated, err)
+		}
+
+		upgraded, err := Upgrade(inst, true, nil)
+		if err != nil {
+			t.Fatalf("could not upgrade: %s", err)
+		}
+
+		if upgraded.Ref != "" || upgraded.Branch != "main" || upgraded.Commit != third {
+			t.Fatalf("did not upgrade to the default branch: %+v", upgraded)
+		}
+	})
+
+	t.Run("unreachable remote", func(t *testing.T) {
+		dirs := GlobalDirs(t.TempDir())
+		inst, err := Install(source, dirs, first, nil)
+		if err != nil {
+			t.Fatalf("could not install: %s", err)
+		}
+
+		unreachable := *inst
+		unreachable.Path = filepath.Join(t.TempDir(), "unreachable")
+		unreachable.Source = "file://" + filepath.Join(t.TempDir(), "missing")
+
+		outdated, err := Outdated([]*Installed{&unreachable, inst})
+		if err == nil {
+			t.Fatal("checking an unreachable remote did not fail")
+		}
+
+		if len(outdated) != 2 || outdated[0].Error == "" || outdated[1].Error != "" || outdated[1].LatestCommit != third {
+			t.Fatalf("did not keep checking after a failure: %+v", outdated)
+		}
+	})
+
+	t.Run("unknown ref", func(t *testing.T) {
+		if _, err := Install(source, GlobalDirs(t.TempDir()), "v0.0.1", nil); err == nil {
+			t.Fatal("installed an unknown ref")
+		}
+	})
+}
@@ -0,0 +1,113 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright © 2021 Roberto Hidalgo <milpa@un.rob.mx>
+package repo
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"git.rob.mx/nidito/chinampa/pkg/logger"
+)
+
+var log = logger.Sub("itself repo")
+
+// GitTimeout is the longest a single git operation may take.
+var GitTimeout = 5 * time.Minute
+
+// Scope tells who repos installed to a set of Dirs are available for.
+type Scope string
+
+const (
+	ScopeUser   Scope = "user"
+	ScopeGlobal Scope = "global"
+)
+
+// Dirs are the folders repos are installed to: clones hold git checkouts, and
+// repos hold the .milpa folder, or a symlink to it, of every installed repo. Keys
+// holds the public keys trusted to sign repos.
+type Dirs struct {
+	Scope  Scope  `json:"scope"`
+	Repos  string `json:"repos"`
+	Clones string `json:"clones"`
+	Keys   string `json:"keys"`
+}
+
+// UserDirs returns the folders for the current user's repos, at $XDG_DATA_HOME/milpa,
+// or ~/.local/share/milpa if XDG_DATA_HOME is not set. If neither XDG_DATA_HOME nor HOME
+// are set, ok is false.
+func UserDirs() (dirs Dirs, ok bool) {
+	base := os.Getenv("XDG_DATA_HOME")
+	if base == "" {
+		home := os.Getenv("HOME")
+		if home == "" {
+			return Dirs{}, false
+		}
+		base = filepath.Join(home, ".local", "share")
+	}
+	return dirsAt(ScopeUser, filepath.Join(base, "milpa")), true
+}
+
+// GlobalDirs returns the folders for machine-wide repos, at milpaRoot.
+func GlobalDirs(milpaRoot string) Dirs {
+	return dirsAt(ScopeGlobal, milpaRoot)
+}
+
+// AllDirs returns the folders for the current user's repos, if available, and machine-wide
+// repos at milpaRoot.
+func AllDirs(milpaRoot string) []Dirs {
+	all := []Dirs{}
+	if dirs, ok := UserDirs(); ok {
+		all = append(all, dirs)
+	}
+	return append(all, GlobalDirs(milpaRoot))
+}
+
+func dirsAt(scope Scope, root string) Dirs {
+	return Dirs{
+		Scope:  scope,
+		Repos:  filepath.Join(root, "repos"),
+		Clones: filepath.Join(root, "clones"),
+		Keys:   filepath.Join(root, "trusted-keys"),
+	}
+}
+
+var nonAlnum = regexp.MustCompile(`[^[:alnum:]]+`)
+
+// CleanName turns a git url or path into a name suitable for a folder, i.e.
+// `git@github.com:unRob/dotfiles.git` becomes `github-com-unrob-dotfiles`.
+func CleanName(source string) string {
+	if _, rest, found := strings.Cut(source, "://"); found {
+		source = rest
+	}
+	if idx := strings.LastIndex(source, "@"); idx > -1 {
+		source = source[idx+1:]
+	}
+	source = strings.TrimSuffix(source, ".git")
+	return strings.ToLower(strings.Trim(nonAlnum.ReplaceAllString(source, "-"), "-"))
+}
+
+// git runs a git sub-command at dir, returning its trimmed stdout.
+func git(dir string, args ...string) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), GitTimeout)
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, "git", args...) // nolint: gosec
+	cmd.Dir = dir
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	cmd.Env = append(os.Environ(), "GIT_TERMINAL_PROMPT=0")
+
+	log.Debugf("running git %s at %s", strings.Join(args, " "), dir)
+	if err := cmd.Run(); err != nil {
+		return "", fmt.Errorf("git %s failed: %w\n%s", strings.Join(args, " "), err, strings.TrimSpace(stderr.String()))
+	}
+	return strings.TrimSpace(stdout.String()), nil
+}
@@ -0,0 +1,377 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright © 2021 Roberto Hidalgo <milpa@un.rob.mx>
+package repo
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"hash"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/crypto/blake2b"
+	"golang.org/x/crypto/ssh"
+)
+
+// TrustStatus tells if the signature of an installed repo was verified.
+type TrustStatus string
+
+const (
+	// TrustVerified repos were signed by a trusted key.
+	TrustVerified TrustStatus = "verified"
+	// TrustUnsigned repos had no signature to verify.
+	TrustUnsigned TrustStatus = "unsigned"
+	// TrustUntrusted repos were signed by an unknown key, or had a bad signature.
+	TrustUntrusted TrustStatus = "untrusted"
+	// TrustLocal repos are folders on this machine, and are never verified.
+	TrustLocal TrustStatus = "local"
+)
+
+const (
+	sshSignatureStart = "-----BEGIN SSH SIGNATURE-----"
+	sshSignatureEnd   = "-----END SSH SIGNATURE-----"
+	// sshNamespaceGit is the namespace git uses for ssh signatures of tags and commits.
+	sshNamespaceGit = "git"
+	// sshNamespaceFile is the namespace `ssh-keygen -Y sign` uses by convention for files.
+	sshNamespaceFile = "file"
+	// trustConfig and signerConfig are the git config keys of clones holding the result of
+	// verifying them when installed.
+	trustConfig  = "milpa.trust"
+	signerConfig = "milpa.signer"
+)
+
+// Key is a public key trusted to sign repos.
+type Key struct {
+	// ID is the minisign key id, or the SHA256 fingerprint of ssh keys
+	ID string `json:"id"`
+	// File is where the key was read from
+	File string `json:"file"`
+
+	public ed25519.PublicKey
+}
+
+// Verification is the result of checking the signature of a repo.
+type Verification struct {
+	Status TrustStatus `json:"status"`
+	// Signer is the ID of the key that signed the repo
+	Signer string `json:"signer,omitempty"`
+	// Reason explains why a repo was not verified
+	Reason string `json:"reason,omitempty"`
+}
+
+// Trust configures the verification of repos being installed or upgraded.
+type Trust struct {
+	// Keys are the public keys trusted to sign repos. Verification is only enforced when at
+	// least one is configured
+	Keys []*Key
+	// Insecure installs repos that could not be verified anyway
+	Insecure bool
+}
+
+// check returns an error if a repo with verification v should not be installed.
+func (t *Trust) check(what string, v *Verification) error {
+	if v.Status == TrustVerified || t == nil || len(t.Keys) == 0 {
+		return nil
+	}
+
+	if t.Insecure {
+		log.Warnf("installing %s insecurely: %s", what, v.Reason)
+		return nil
+	}
+	return fmt.Errorf("refusing to install %s: %s; use --insecure to install it anyway", what, v.Reason)
+}
+
+func (t *Trust) keys() []*Key {
+	if t == nil {
+		return nil
+	}
+	return t.Keys
+}
+
+// sshSignature is an ssh signature blob, as described by OpenSSH's PROTOCOL.sshsig.
+type sshSignature struct {
+	Magic         [6]byte
+	Version       uint32
+	PublicKey     []byte
+	Namespace     string
+	Reserved      string
+	HashAlgorithm string
+	Signature     []byte
+}
+
+// sshSignedData is what ssh signatures sign, instead of the data itself.
+type sshSignedData struct {
+	Magic         [6]byte
+	Namespace     string
+	Reserved      string
+	HashAlgorithm string
+	Hash          []byte
+}
+
+var sshSignatureMagic = [6]byte{'S', 'S', 'H', 'S', 'I', 'G'}
+
+// parseSSHEd25519 returns the ssh-ed25519 key in a key blob.
+func parseSSHEd25519(blob []byte) (ssh.PublicKey, error) {
+	key, err := ssh.ParsePublicKey(blob)
+	if err != nil {
+		return nil, fmt.Errorf("invalid ssh key: %w", err)
+	}
+
+	if key.Type() != ssh.KeyAlgoED25519 {
+		return nil, fmt.Errorf("unsupported key type %s, only ssh-ed25519 keys are supported", key.Type())
+	}
+	return key, nil
+}
+
+func minisignKeyID(id []byte) string {
+	return fmt.Sprintf("%016X", binary.LittleEndian.Uint64(id))
+}
+
+// ParseKeys reads ssh-ed25519 keys, one per line like in an authorized_keys file, and minisign
+// public keys, after their `untrusted comment:` line.
+func ParseKeys(contents []byte, file string) ([]*Key, error) {
+	keys := []*Key{}
+	for idx, line := range strings.Split(string(contents), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") || strings.HasPrefix(line, "untrusted comment:") {
+			continue
+		}
+
+		if kind, encoded, found := strings.Cut(line, " "); found {
+			if kind != "ssh-ed25519" {
+				return nil, fmt.Errorf("%s:%d: unsupported key type %s, only ssh-ed25519 keys are supported", file, idx+1, kind)
+			}
+
+			encoded, _, _ = strings.Cut(strings.TrimSpace(encoded), " ")
+			blob, err := base64.StdEncoding.DecodeString(encoded)
+			if err != nil {
+				return nil, fmt.Errorf("%s:%d: invalid ssh key: %w", file, idx+1, err)
+			}
+
+			key, err := parseSSHEd25519(blob)
+			if err != nil {
+				return nil, fmt.Errorf("%s:%d: %w", file, idx+1, err)
+			}
+			public := key.(ssh.CryptoPublicKey).CryptoPublicKey().(ed25519.PublicKey)
+			keys = append(keys, &Key{ID: ssh.FingerprintSHA256(key), File: file, public: public})
+			continue
+		}
+
+		blob, err := base64.StdEncoding.DecodeString(line)
+		if err != nil || len(blob) != 42 || string(blob[:2]) != "Ed" {
+			return nil, fmt.Errorf("%s:%d: invalid minisign public key", file, idx+1)
+		}
+		keys = append(keys, &Key{ID: minisignKeyID(blob[2:10]), File: file, public: ed25519.PublicKey(blob[10:])})
+	}
+
+	return keys, nil
+}
+
+// LoadKeys reads the trusted keys in the keys folder of every one of dirs.
+func LoadKeys(dirs ...Dirs) ([]*Key, error) {
+	keys := []*Key{}
+	for _, d := range dirs {
+		entries, err := os.ReadDir(d.Keys)
+		if os.IsNotExist(err) {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+
+		for _, entry := range entries {
+			if entry.IsDir() || strings.HasPrefix(entry.Name(), ".") {
+				continue
+			}
+
+			path := filepath.Join(d.Keys, entry.Name())
+			contents, err := os.ReadFile(path)
+			if err != nil {
+				return nil, err
+			}
+
+			found, err := ParseKeys(contents, path)
+			if err != nil {
+				return nil, err
+			}
+			keys = append(keys, found...)
+		}
+	}
+	return keys, nil
+}
+
+// verifyMinisign checks a minisign signature of data, prehashed or not.
+func verifyMinisign(keys []*Key, data []byte, signature []byte) *Verification {
+	lines := strings.Split(strings.TrimSpace(string(signature)), "\n")
+	if len(lines) != 4 || !strings.HasPrefix(lines[2], "trusted comment: ") {
+		return &Verification{Status: TrustUntrusted, Reason: "invalid minisign signature"}
+	}
+
+	sig, err := base64.StdEncoding.DecodeString(strings.TrimSpace(lines[1]))
+	if err != nil || len(sig) != 74 {
+		return &Verification{Status: TrustUntrusted, Reason: "invalid minisign signature"}
+	}
+
+	global, err := base64.StdEncoding.DecodeString(strings.TrimSpace(lines[3]))
+	if err != nil || len(global) != ed25519.SignatureSize {
+		return &Verification{Status: TrustUntrusted, Reason: "invalid minisign signature"}
+	}
+
+	switch string(sig[:2]) {
+	case "Ed":
+	case "ED":
+		digest := blake2b.Sum512(data)
+		data = digest[:]
+	default:
+		return &Verification{Status: TrustUntrusted, Reason: fmt.Sprintf("unsupported minisign algorithm %q", sig[:2])}
+	}
+
+	id := minisignKeyID(sig[2:10])
+	for _, key := range keys {
+		if key.ID != id {
+			continue
+		}
+
+		trusted := append(append([]byte{}, sig[10:]...), []byte(strings.TrimPrefix(lines[2], "trusted comment: "))...)
+		if !ed25519.Verify(key.public, data, sig[10:]) || !ed25519.Verify(key.public, trusted, global) {
+			return &Verification{Status: TrustUntrusted, Signer: id, Reason: fmt.Sprintf("signature does not match trusted key %s", id)}
+		}
+		return &Verification{Status: TrustVerified, Signer: id}
+	}
+
+	return &Verification{Status: TrustUntrusted, Signer: id, Reason: fmt.Sprintf("signed by unknown key %s", id)}
+}
+
+// verifySSHSignature checks an armored ssh signature of data in namespace, as created by
+// `ssh-keygen -Y sign`.
+func verifySSHSignature(keys []*Key, data []byte, armored string, namespace string) *Verification {
+	invalid := &Verification{Status: TrustUntrusted, Reason: "invalid ssh signature"}
+	armored = strings.TrimSpace(armored)
+	if !strings.HasPrefix(armored, sshSignatureStart) || !strings.HasSuffix(armored, sshSignatureEnd) {
+		return invalid
+	}
+
+	encoded := strings.Join(strings.Fields(armored[len(sshSignatureStart):len(armored)-len(sshSignatureEnd)]), "")
+	blob, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return invalid
+	}
+
+	signature := &sshSignature{}
+	if err := ssh.Unmarshal(blob, signature); err != nil || signature.Magic != sshSignatureMagic || signature.Version != 1 {
+		return invalid
+	}
+
+	if signature.Namespace != namespace {
+		return &Verification{Status: TrustUntrusted, Reason: fmt.Sprintf("signature is for namespace %s, not %s", signature.Namespace, namespace)}
+	}
+
+	signer, err := parseSSHEd25519(signature.PublicKey)
+	if err != nil {
+		return &Verification{Status: TrustUntrusted, Reason: err.Error()}
+	}
+
+	sig := &ssh.Signature{}
+	if err := ssh.Unmarshal(signature.Signature, sig); err != nil {
+		return invalid
+	}
+
+	var h hash.Hash
+	switch signature.HashAlgorithm {
+	case "sha256":
+		h = sha256.New()
+	case "sha512":
+		h = sha512.New()
+	default:
+		return &Verification{Status: TrustUntrusted, Reason: fmt.Sprintf("unsupported hash algorithm %s", signature.HashAlgorithm)}
+	}
+	h.Write(data)
+
+	signed := ssh.Marshal(&sshSignedData{
+		Magic:         sshSignatureMagic,
+		Namespace:     signature.Namespace,
+		Reserved:      signature.Reserved,
+		HashAlgorithm: signature.HashAlgorithm,
+		Hash:          h.Sum(nil),
+	})
+
+	public := signer.(ssh.CryptoPublicKey).CryptoPublicKey().(ed25519.PublicKey)
+	id := ssh.FingerprintSHA256(signer)
+	for _, key := range keys {
+		if !bytes.Equal(key.public, public) {
+			continue
+		}
+
+		if err := signer.Verify(signed, sig); err != nil {
+			return &Verification{Status: TrustUntrusted, Signer: key.ID, Reason: fmt.Sprintf("signature does not match trusted key %s", key.ID)}
+		}
+		return &Verification{Status: TrustVerified, Signer: key.ID}
+	}
+
+	return &Verification{Status: TrustUntrusted, Signer: id, Reason: fmt.Sprintf("signed by unknown key %s", id)}
+}
+
+// VerifyArchive checks the signature of an archive, read from a minisign signature at
+// `ARCHIVE.minisig`, or an ssh signature at `ARCHIVE.sig`.
+func VerifyArchive(archive string, keys []*Key) (*Verification, error) {
+	data, err := os.ReadFile(archive) // nolint: gosec
+	if err != nil {
+		return nil, err
+	}
+
+	if sig, err := os.ReadFile(archive + ".minisig"); err == nil {
+		return verifyMinisign(keys, data, sig), nil
+	}
+
+	if sig, err := os.ReadFile(archive + ".sig"); err == nil {
+		return verifySSHSignature(keys, data, string(sig), sshNamespaceFile), nil
+	}
+
+	return &Verification{Status: TrustUnsigned, Reason: fmt.Sprintf("no %s.minisig or %s.sig signature found", filepath.Base(archive), filepath.Base(archive))}, nil
+}
+
+// verifyTag checks the ssh signature of tag, fetched into the clone at dir, pointing to commit.
+func verifyTag(dir string, tag string, commit string, keys []*Key) (*Verification, error) {
+	ref := "refs/tags/" + tag
+	if _, err := git(dir, "fetch", "-q", "--depth=1", "--no-tags", "origin", "+"+ref+":"+ref); err != nil {
+		return nil, err
+	}
+
+	if kind, err := git(dir, "cat-file", "-t", ref); err != nil || kind != "tag" {
+		return &Verification{Status: TrustUnsigned, Reason: fmt.Sprintf("tag %s is not an annotated, signed tag", tag)}, nil
+	}
+
+	// git output is trimmed, but signed payloads end with a newline before the signature
+	contents, err := git(dir, "cat-file", "tag", ref)
+	if err != nil {
+		return nil, err
+	}
+
+	if !strings.HasPrefix(contents, "object "+commit+"\n") {
+		return &Verification{Status: TrustUntrusted, Reason: fmt.Sprintf("tag %s does not point to %s", tag, commit)}, nil
+	}
+
+	idx := strings.Index(contents, sshSignatureStart)
+	if idx == -1 {
+		if strings.Contains(contents, "-----BEGIN PGP SIGNATURE-----") {
+			return &Verification{Status: TrustUntrusted, Reason: fmt.Sprintf("tag %s has a PGP signature, only ssh signatures are supported", tag)}, nil
+		}
+		return &Verification{Status: TrustUnsigned, Reason: fmt.Sprintf("tag %s is not signed", tag)}, nil
+	}
+
+	return verifySSHSignature(keys, []byte(contents[:idx]), contents[idx:], sshNamespaceGit), nil
+}
+
+// recordTrust stores the result of verifying the clone at dir in its git config.
+func recordTrust(dir string, v *Verification) error {
+	if _, err := git(dir, "config", trustConfig, string(v.Status)); err != nil {
+		return err
+	}
+	_, err := git(dir, "config", signerConfig, v.Signer)
+	return err
+}
@@ -0,0 +1,315 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright © 2021 Roberto Hidalgo <milpa@un.rob.mx>
+package repo_test
+
+import (
+	"crypto/ed25519"
+	"encoding/base64"
+	"encoding/hex"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	. "github.com/unrob/milpa/internal/repo"
+)
+
+// minisignKey signs data like minisign would.
+type minisignKey struct {
+	id      []byte
+	private ed25519.PrivateKey
+}
+
+func newMinisignKey(t *testing.T, id string) *minisignKey {
+	t.Helper()
+	_, private, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &minisignKey{id: []byte(id), private: private}
+}
+
+func (k *minisignKey) public() string {
+	blob := append(append([]byte("Ed"), k.id...), k.private.Public().(ed25519.PublicKey)...)
+	return "untrusted comment: minisign public key\n" + base64.StdEncoding.EncodeToString(blob) + "\n"
+}
+
+// sign returns a minisign signature of signed with algorithm alg, Ed for legacy signatures or
+// ED for prehashed ones.
+func (k *minisignKey) sign(alg string, signed []byte) string {
+	sig := append(append([]byte(alg), k.id...), ed25519.Sign(k.private, signed)...)
+	comment := "timestamp:1609459200"
+	global := ed25519.Sign(k.private, append(append([]byte{}, sig[10:]...), comment...))
+	return "untrusted comment: signature from minisign secret key\n" +
+		base64.StdEncoding.EncodeToString(sig) + "\n" +
+		"trusted comment: " + comment + "\n" +
+		base64.StdEncoding.EncodeToString(global) + "\n"
+}
+
+// sshKey creates an ed25519 ssh key, returning the path to its private half.
+func sshKey(t *testing.T) string {
+	t.Helper()
+	if _, err := exec.LookPath("ssh-keygen"); err != nil {
+		t.Skip("ssh-keygen is not available")
+	}
+	key := filepath.Join(t.TempDir(), "id_ed25519")
+	run(t, filepath.Dir(key), "ssh-keygen", "-q", "-t", "ed25519", "-N", "", "-C", "milpa", "-f", key)
+	return key
+}
+
+func trustKeys(t *testing.T, dirs Dirs, keys ...string) *Trust {
+	t.Helper()
+	if err := os.MkdirAll(dirs.Keys, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dirs.Keys, "keys"), []byte(strings.Join(keys, "\n")), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	loaded, err := LoadKeys(dirs)
+	if err != nil {
+		t.Fatalf("could not load keys: %s", err)
+	}
+	return &Trust{Keys: loaded}
+}
+
+func writeFile(t *testing.T, path string, contents string) {
+	t.Helper()
+	if err := os.WriteFile(path, []byte(contents), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestParseKeys(t *testing.T) {
+	minisign := newMinisignKey(t, "\x01\x02\x03\x04\x05\x06\x07\x08")
+	public, _, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	blob := append([]byte{0, 0, 0, 11}, "ssh-ed25519\x00\x00\x00\x20"...)
+	ssh := "ssh-ed25519 " + base64.StdEncoding.EncodeToString(append(blob, public...)) + " someone@example.com"
+
+	keys, err := ParseKeys([]byte("# release keys\n"+minisign.public()+"\n"+ssh+"\n"), "keys")
+	if err != nil {
+		t.Fatalf("could not parse keys: %s", err)
+	}
+
+	if len(keys) != 2 || keys[0].ID != "0807060504030201" || !strings.HasPrefix(keys[1].ID, "SHA256:") || keys[1].File != "keys" {
+		t.Fatalf("unexpected keys: %+v, %+v", keys[0], keys[1])
+	}
+
+	for name, contents := range map[string]string{
+		"rsa":       "ssh-rsa AAAAB3NzaC1yc2EAAAADAQABAAABAQ== someone@example.com",
+		"garbage":   "not a key",
+		"truncated": "RWQBAgMEBQYHCA==",
+	} {
+		if _, err := ParseKeys([]byte(contents), "keys"); err == nil {
+			t.Fatalf("parsed %s key", name)
+		}
+	}
+}
+
+func TestVerifyArchive(t *testing.T) {
+	src := localRepo(t)
+	trusted := newMinisignKey(t, "trusted!")
+	unknown := newMinisignKey(t, "unknown!")
+
+	// BLAKE2b-512("abc"), from RFC 7693
+	abc, _ := hex.DecodeString("ba80a53f981c4d0d6a2797b69f12f6e94c212f14685ac4b74b12bb6fdbffa2d1" +
+		"7d87c5392aab792dc252d5de4533cc9518d38aa8dbf1925ab92386edd4009923")
+
+	for name, test := range map[string]struct {
+		sign     func(archive string) string
+		status   TrustStatus
+		refused  bool
+		insecure bool
+	}{
+		"legacy": {
+			sign: func(archive string) string {
+				data, _ := os.ReadFile(archive)
+				return trusted.sign("Ed", data)
+			},
+			status: TrustVerified,
+		},
+		"unsigned": {
+			status:  TrustUnsigned,
+			refused: true,
+		},
+		"unsigned and insecure": {
+			status:   TrustUnsigned,
+			insecure: true,
+		},
+		"unknown key": {
+			sign: func(archive string) string {
+				data, _ := os.ReadFile(archive)
+				return unknown.sign("Ed", data)
+			},
+			status:  TrustUntrusted,
+			refused: true,
+		},
+		"tampered": {
+			sign: func(archive string) string {
+				return trusted.sign("Ed", []byte("something else"))
+			},
+			status:  TrustUntrusted,
+			refused: true,
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			archive := filepath.Join(t.TempDir(), "infra.tar.gz")
+			if _, err := Pack(src, archive); err != nil {
+				t.Fatal(err)
+			}
+			if test.sign != nil {
+				writeFile(t, archive+".minisig", test.sign(archive))
+			}
+
+			dirs := GlobalDirs(t.TempDir())
+			trust := trustKeys(t, dirs, trusted.public())
+			trust.Insecure = test.insecure
+
+			inst, err := Install(archive, dirs, "", trust)
+			if test.refused {
+				if err == nil {
+					t.Fatalf("installed %s archive", name)
+				}
+				if leftovers, _ := os.ReadDir(dirs.Clones); len(leftovers) != 0 {
+					t.Fatalf("refused install left files behind: %v", leftovers)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("could not install: %s", err)
+			}
+			if inst.Trust != test.status {
+				t.Fatalf("unexpected trust %s, expected %s", inst.Trust, test.status)
+			}
+		})
+	}
+
+	t.Run("prehashed", func(t *testing.T) {
+		archive := filepath.Join(t.TempDir(), "abc.tar.gz")
+		writeFile(t, archive, "abc")
+		writeFile(t, archive+".minisig", trusted.sign("ED", abc))
+
+		v, err := VerifyArchive(archive, trustKeys(t, GlobalDirs(t.TempDir()), trusted.public()).Keys)
+		if err != nil || v.Status != TrustVerified || v.Signer != "2164657473757274" {
+			t.Fatalf("unexpected verification: %+v, %v", v, err)
+		}
+	})
+
+	t.Run("without trusted keys", func(t *testing.T) {
+		archive := filepath.Join(t.TempDir(), "infra.tar.gz")
+		if _, err := Pack(src, archive); err != nil {
+			t.Fatal(err)
+		}
+
+		inst, err := Install(archive, GlobalDirs(t.TempDir()), "", nil)
+		if err != nil || inst.Trust != TrustUnsigned {
+			t.Fatalf("unexpected install: %+v, %v", inst, err)
+		}
+	})
+
+	t.Run("ssh", func(t *testing.T) {
+		key := sshKey(t)
+		archive := filepath.Join(t.TempDir(), "infra.tar.gz")
+		if _, err := Pack(src, archive); err != nil {
+			t.Fatal(err)
+		}
+		run(t, filepath.Dir(archive), "ssh-keygen", "-q", "-Y", "sign", "-f", key, "-n", "file", archive)
+
+		public, err := os.ReadFile(key + ".pub")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		dirs := GlobalDirs(t.TempDir())
+		inst, err := Install(archive, dirs, "", trustKeys(t, dirs, string(public)))
+		if err != nil || inst.Trust != TrustVerified || !strings.HasPrefix(inst.Signer, "SHA256:") {
+			t.Fatalf("unexpected install: %+v, %v", inst, err)
+		}
+
+		installed, err := List(dirs)
+		if err != nil || len(installed) != 1 || installed[0].Trust != TrustVerified || installed[0].Signer != inst.Signer {
+			t.Fatalf("unexpected list: %+v, %v", installed, err)
+		}
+	})
+}
+
+func TestSignedTag(t *testing.T) {
+	key := sshKey(t)
+	public, err := os.ReadFile(key + ".pub")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	source, commit := remoteRepo(t)
+	remote := filepath.Clean(source[len("file://"):])
+	first := commit("first")
+	run(t, remote, "git", "-c", "gpg.format=ssh", "-c", "user.signingkey="+key, "tag", "-s", "-m", "release", "v1.0.0")
+	commit("second")
+	run(t, remote, "git", "tag", "v1.1.0")
+
+	t.Run("signed", func(t *testing.T) {
+		dirs := GlobalDirs(t.TempDir())
+		inst, err := Install(source, dirs, "v1.0.0", trustKeys(t, dirs, string(public)))
+		if err != nil {
+			t.Fatalf("could not install: %s", err)
+		}
+
+		if inst.Trust != TrustVerified || inst.Commit != first || !strings.HasPrefix(inst.Signer, "SHA256:") {
+			t.Fatalf("unexpected install: %+v", inst)
+		}
+
+		if _, err := Upgrade(inst, true, trustKeys(t, dirs, string(public))); err == nil {
+			t.Fatal("upgraded to an unsigned tag")
+		}
+
+		upgraded, err := Upgrade(inst, true, &Trust{Keys: trustKeys(t, dirs, string(public)).Keys, Insecure: true})
+		if err != nil || upgraded.Ref != "v1.1.0" || upgraded.Trust != TrustUnsigned {
+			t.Fatalf("unexpected upgrade: %+v, %v", upgraded, err)
+		}
+	})
+
+	t.Run("unknown key", func(t *testing.T) {
+		dirs := GlobalDirs(t.TempDir())
+		other := newMinisignKey(t, "someone!")
+		if _, err := Install(source, dirs, "v1.0.0", trustKeys(t, dirs, other.public())); err == nil {
+			t.Fatal("installed a tag signed by an unknown key")
+		}
+
+		if leftovers, _ := os.ReadDir(dirs.Clones); len(leftovers) != 0 {
+			t.Fatalf("refused install left files behind: %v", leftovers)
+		}
+	})
+
+	t.Run("nothing checked out before verification", func(t *testing.T) {
+		dirs := GlobalDirs(t.TempDir())
+		clone := filepath.Join(dirs.Clones, "refused")
+		if err := CloneAt(clone, source, "v1.1.0", trustKeys(t, dirs, string(public))); err == nil {
+			t.Fatal("cloned an unsigned tag")
+		}
+
+		if _, err := os.Stat(clone); !os.IsNotExist(err) {
+			t.Fatalf("refused clone left files behind: %v", err)
+		}
+	})
+
+	for name, ref := range map[string]string{"unsigned tag": "v1.1.0", "branch": ""} {
+		t.Run(name, func(t *testing.T) {
+			dirs := GlobalDirs(t.TempDir())
+			trust := trustKeys(t, dirs, string(public))
+			if _, err := Install(source, dirs, ref, trust); err == nil {
+				t.Fatalf("installed %s", name)
+			}
+
+			trust.Insecure = true
+			inst, err := Install(source, dirs, ref, trust)
+			if err != nil || inst.Trust != TrustUnsigned {
+				t.Fatalf("unexpected insecure install: %+v, %v", inst, err)
+			}
+		})
+	}
+}
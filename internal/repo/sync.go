@@ -0,0 +1,182 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright © 2021 Roberto Hidalgo <milpa@un.rob.mx>
+package repo
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+
+	_c "github.com/unrob/milpa/internal/constants"
+)
+
+// SyncStatus describes what syncing did to a repo.
+type SyncStatus string
+
+const (
+	SyncInstalled SyncStatus = "installed"
+	SyncUpgraded  SyncStatus = "upgraded"
+	SyncUnchanged SyncStatus = "unchanged"
+)
+
+// SyncResult reports the state of a repo after syncing.
+type SyncResult struct {
+	Name   string     `json:"name"`
+	Path   string     `json:"path"`
+	Commit string     `json:"commit"`
+	Status SyncStatus `json:"status"`
+}
+
+var commitPattern = regexp.MustCompile(`^[0-9a-f]{40}$`)
+
+// Resolve finds the commit src.Ref points to at src.Source.
+func Resolve(src *Source) (string, error) {
+	_, commit, err := resolveRef(src.Source, src.Ref)
+	return commit, err
+}
+
+// checkout makes the clone at dir, sparsely checked out to its .milpa folder, point at commit.
+// When set, verify is called once commit is fetched, and before it's checked out.
+func checkout(dir string, src *Source, commit string, verify func() error) (SyncStatus, error) {
+	status := SyncUpgraded
+	if _, err := os.Stat(filepath.Join(dir, ".git")); os.IsNotExist(err) {
+		status = SyncInstalled
+		if err := initClone(dir, src.Source); err != nil {
+			return "", err
+		}
+	} else {
+		origin, err := git(dir, "remote", "get-url", "origin")
+		if err != nil {
+			return "", err
+		}
+
+		if origin != src.Source {
+			return "", fmt.Errorf("%s is a clone of %s, not %s", dir, origin, src.Source)
+		}
+
+		if head, err := git(dir, "rev-parse", "HEAD"); err == nil && head == commit {
+			return SyncUnchanged, nil
+		}
+	}
+
+	if _, err := git(dir, "fetch", "-q", "--depth=1", "origin", commit); err != nil {
+		// not every remote allows fetching commits by sha, so fall back to fetching everything
+		log.Debugf("could not fetch %s directly, fetching all refs: %s", commit, err)
+		if _, err := git(dir, "fetch", "-q", "--tags", "origin", "+refs/heads/*:refs/remotes/origin/*"); err != nil {
+			return "", err
+		}
+	}
+
+	if verify != nil {
+		if err := verify(); err != nil {
+			if status == SyncInstalled {
+				os.RemoveAll(dir) // nolint: errcheck
+			}
+			return "", err
+		}
+	}
+
+	if _, err := git(dir, "-c", "advice.detachedHead=false", "checkout", "-q", "--detach", commit); err != nil {
+		return "", err
+	}
+
+	return status, nil
+}
+
+// link points dirs.Repos/name at the .milpa folder of target.
+func link(dirs Dirs, name string, target string) (string, error) {
+	if err := os.MkdirAll(dirs.Repos, 0755); err != nil {
+		return "", err
+	}
+
+	path := filepath.Join(dirs.Repos, name)
+	milpaDir := filepath.Join(target, _c.RepoRoot)
+	if existing, err := os.Readlink(path); err == nil {
+		if existing != milpaDir {
+			return "", fmt.Errorf("a repo named %s is already installed from %s", name, existing)
+		}
+		return path, nil
+	} else if _, err := os.Lstat(path); err == nil {
+		return "", fmt.Errorf("a repo named %s is already installed at %s", name, path)
+	}
+
+	return path, os.Symlink(milpaDir, path)
+}
+
+// verifySource checks the signature of src at commit, fetched into the clone at dir, against
+// trust. Refs of unknown kind, as read from lockfiles, are resolved again to tell.
+func verifySource(dir string, src *Source, kind RefKind, commit string, trust *Trust) (*Verification, error) {
+	if kind == "" {
+		var err error
+		if kind, _, err = resolveRef(src.Source, src.Ref); err != nil {
+			return nil, err
+		}
+	}
+
+	v, err := verifyRef(dir, kind, src.Ref, commit, trust)
+	if err != nil {
+		return nil, err
+	}
+	return v, trust.check(src.Source, v)
+}
+
+// Sync installs or upgrades the repos of manifest into dirs, at the commits pinned by lock.
+// Repos missing from lock, or all of them when update is set, are resolved again. Repos
+// changing commits are verified against trust, like Install does. The returned lockfile pins
+// every repo in the manifest. When a repo fails to sync, the results
+// of those synced before it are returned along the error, and the lockfile keeps the
+// previous pins of the rest, so it can be written all the same.
+func Sync(manifest *Manifest, lock *Lockfile, dirs Dirs, update bool, trust *Trust) ([]*SyncResult, *Lockfile, error) {
+	results := []*SyncResult{}
+	updated := &Lockfile{Repos: []*Locked{}}
+
+	fail := func(idx int, err error) ([]*SyncResult, *Lockfile, error) {
+		for _, src := range manifest.Repos[idx:] {
+			if commit := lock.Find(src); commit != "" {
+				updated.Repos = append(updated.Repos, &Locked{Source: *src, Commit: commit})
+			}
+		}
+		return results, updated, err
+	}
+
+	for idx, src := range manifest.Repos {
+		commit := ""
+		var kind RefKind
+		if !update {
+			commit = lock.Find(src)
+		}
+
+		if commit == "" {
+			log.Infof("Resolving %s at %s", src.Name, src.Source)
+			var err error
+			if kind, commit, err = resolveRef(src.Source, src.Ref); err != nil {
+				return fail(idx, err)
+			}
+		}
+
+		clone := filepath.Join(dirs.Clones, src.Name)
+		var v *Verification
+		status, err := checkout(clone, src, commit, func() (err error) {
+			v, err = verifySource(clone, src, kind, commit, trust)
+			return err
+		})
+		if err == nil && v != nil {
+			err = recordTrust(clone, v)
+		}
+		if err != nil {
+			return fail(idx, fmt.Errorf("could not sync %s: %w", src.Name, err))
+		}
+
+		path, err := link(dirs, src.Name, clone)
+		if err != nil {
+			return fail(idx, err)
+		}
+
+		log.Infof("%s %s at %s", src.Name, status, commit)
+		results = append(results, &SyncResult{Name: src.Name, Path: path, Commit: commit, Status: status})
+		updated.Repos = append(updated.Repos, &Locked{Source: *src, Commit: commit})
+	}
+
+	return results, updated, nil
+}
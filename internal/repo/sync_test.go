@@ -0,0 +1,266 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright © 2021 Roberto Hidalgo <milpa@un.rob.mx>
+package repo_test
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	. "github.com/unrob/milpa/internal/repo"
+)
+
+// run executes a command at dir, failing the test if it errors.
+func run(t *testing.T, dir string, name string, args ...string) string {
+	t.Helper()
+	cmd := exec.Command(name, args...)
+	cmd.Dir = dir
+	cmd.Env = append(os.Environ(),
+		"GIT_AUTHOR_NAME=milpa", "GIT_AUTHOR_EMAIL=milpa@example.com",
+		"GIT_COMMITTER_NAME=milpa", "GIT_COMMITTER_EMAIL=milpa@example.com",
+		"GIT_CONFIG_GLOBAL=/dev/null",
+	)
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		t.Fatalf("%s %s failed: %s\n%s", name, strings.Join(args, " "), err, out)
+	}
+	return strings.TrimSpace(string(out))
+}
+
+// remoteRepo creates a git repo with a milpa command, returning its file:// url and a
+// function that commits a new version of the command.
+func remoteRepo(t *testing.T) (string, func(message string) string) {
+	t.Helper()
+	dir := filepath.Join(t.TempDir(), "remote")
+	if err := os.MkdirAll(filepath.Join(dir, ".milpa", "commands"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	run(t, dir, "git", "init", "-q", "-b", "main")
+
+	commit := func(message string) string {
+		if err := os.WriteFile(filepath.Join(dir, ".milpa", "commands", "hello.sh"), []byte("echo "+message+"\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		run(t, dir, "git", "add", "-A")
+		run(t, dir, "git", "commit", "-q", "-m", message)
+		return run(t, dir, "git", "rev-parse", "HEAD")
+	}
+
+	return "file://" + dir, commit
+}
+
+func writeManifest(t *testing.T, contents string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), ManifestName)
+	if err := os.WriteFile(path, []byte(contents), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestReadManifest(t *testing.T) {
+	manifest, err := ReadManifest(writeManifest(t, `repos:
+  - source: git@github.com:unRob/dotfiles.git
+  - name: infra
+    source: https://example.com/infra.git
+    ref: v1.2.3
+`))
+	if err != nil {
+		t.Fatalf("could not read manifest: %s", err)
+	}
+
+	if name := manifest.Repos[0].Name; name != "github-com-unrob-dotfiles" {
+		t.Fatalf("unexpected default name: %s", name)
+	}
+
+	if ref := manifest.Repos[1].Ref; ref != "v1.2.3" {
+		t.Fatalf("unexpected ref: %s", ref)
+	}
+
+	for _, bad := range []string{
+		"repos: [{name: missing-source}]",
+		"repos: [{source: a, name: dupe}, {source: b, name: dupe}]",
+		"repos: [{source: a, name: ../escape}]",
+		"repos: {}",
+	} {
+		if _, err := ReadManifest(writeManifest(t, bad)); err == nil {
+			t.Errorf("reading manifest %q did not fail", bad)
+		}
+	}
+}
+
+func TestSync(t *testing.T) {
+	remote, commit := remoteRepo(t)
+	first := commit("first")
+	remoteDir := strings.TrimPrefix(remote, "file://")
+	run(t, remoteDir, "git", "tag", "-a", "v1", "-m", "v1")
+	second := commit("second")
+
+	data := t.TempDir()
+	dirs := Dirs{Repos: filepath.Join(data, "repos"), Clones: filepath.Join(data, "clones")}
+	manifestPath := writeManifest(t, "repos:\n  - name: hello\n    source: "+remote+"\n  - name: pinned\n    source: "+remote+"\n    ref: v1\n")
+	manifest, err := ReadManifest(manifestPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	lock, err := ReadLockfile(LockfilePath(manifestPath))
+	if err != nil || len(lock.Repos) != 0 {
+		t.Fatalf("unexpected lockfile %v: %s", lock, err)
+	}
+
+	results, lock, err := Sync(manifest, lock, dirs, false, nil)
+	if err != nil {
+		t.Fatalf("could not sync: %s", err)
+	}
+
+	expected := map[string]string{"hello": second, "pinned": first}
+	for _, res := range results {
+		if res.Status != SyncInstalled || res.Commit != expected[res.Name] {
+			t.Fatalf("unexpected result for %s: %+v", res.Name, res)
+		}
+
+		contents, err := os.ReadFile(filepath.Join(res.Path, "commands", "hello.sh"))
+		if err != nil {
+			t.Fatalf("repo not linked at %s: %s", res.Path, err)
+		}
+		if res.Name == "pinned" && string(contents) != "echo first\n" {
+			t.Fatalf("pinned repo at the wrong version: %s", contents)
+		}
+	}
+
+	if err := lock.Write(LockfilePath(manifestPath)); err != nil {
+		t.Fatal(err)
+	}
+
+	// new commits upstream don't change locked repos
+	third := commit("third")
+	lock, err = ReadLockfile(LockfilePath(manifestPath))
+	if err != nil {
+		t.Fatal(err)
+	}
+	results, _, err = Sync(manifest, lock, dirs, false, nil)
+	if err != nil {
+		t.Fatalf("could not sync: %s", err)
+	}
+	for _, res := range results {
+		if res.Status != SyncUnchanged || res.Commit != expected[res.Name] {
+			t.Fatalf("locked repo changed: %+v", res)
+		}
+	}
+
+	// until updated
+	results, lock, err = Sync(manifest, lock, dirs, true, nil)
+	if err != nil {
+		t.Fatalf("could not sync: %s", err)
+	}
+	if res := results[0]; res.Status != SyncUpgraded || res.Commit != third || lock.Repos[0].Commit != third {
+		t.Fatalf("repo was not upgraded: %+v", res)
+	}
+	if res := results[1]; res.Status != SyncUnchanged || res.Commit != first {
+		t.Fatalf("pinned repo changed: %+v", res)
+	}
+
+	contents, err := os.ReadFile(filepath.Join(dirs.Repos, "hello", "commands", "hello.sh"))
+	if err != nil || string(contents) != "echo third\n" {
+		t.Fatalf("repo not upgraded: %s %s", contents, err)
+	}
+
+	// sources can't be swapped from under an existing clone
+	other, otherCommit := remoteRepo(t)
+	otherCommit("other")
+	manifest.Repos[0].Source = other
+	if _, _, err := Sync(manifest, lock, dirs, false, nil); err == nil {
+		t.Fatal("syncing a repo from a different source did not fail")
+	}
+}
+
+func TestSyncFailure(t *testing.T) {
+	remote, commit := remoteRepo(t)
+	first := commit("first")
+
+	data := t.TempDir()
+	dirs := Dirs{Repos: filepath.Join(data, "repos"), Clones: filepath.Join(data, "clones")}
+	missing := "file://" + filepath.Join(t.TempDir(), "missing")
+	manifest, err := ReadManifest(writeManifest(t, "repos:\n  - name: hello\n    source: "+remote+"\n  - name: missing\n    source: "+missing+"\n  - name: later\n    source: "+remote+"\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	lock := &Lockfile{Repos: []*Locked{{Source: *manifest.Repos[2], Commit: first}}}
+	results, updated, err := Sync(manifest, lock, dirs, false, nil)
+	if err == nil {
+		t.Fatal("syncing a missing repo did not fail")
+	}
+
+	// repos synced before the failure are reported and locked, the rest keep their pins
+	if len(results) != 1 || results[0].Name != "hello" || results[0].Status != SyncInstalled {
+		t.Fatalf("unexpected results: %+v", results)
+	}
+
+	if len(updated.Repos) != 2 || updated.Find(manifest.Repos[0]) != first || updated.Find(manifest.Repos[2]) != first {
+		t.Fatalf("unexpected lockfile: %+v", updated.Repos)
+	}
+}
+
+func TestSyncTrust(t *testing.T) {
+	key := sshKey(t)
+	public, err := os.ReadFile(key + ".pub")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	source, commit := remoteRepo(t)
+	remote := strings.TrimPrefix(source, "file://")
+	commit("first")
+	run(t, remote, "git", "-c", "gpg.format=ssh", "-c", "user.signingkey="+key, "tag", "-s", "-m", "release", "v1.0.0")
+	commit("second")
+
+	manifest, err := ReadManifest(writeManifest(t, "repos:\n  - name: signed\n    source: "+source+"\n    ref: v1.0.0\n  - name: unsigned\n    source: "+source+"\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data := t.TempDir()
+	dirs := GlobalDirs(data)
+	trust := trustKeys(t, dirs, string(public))
+	results, lock, err := Sync(manifest, &Lockfile{}, dirs, false, trust)
+	if err == nil {
+		t.Fatal("synced an unsigned repo")
+	}
+
+	if len(results) != 1 {
+		t.Fatalf("unexpected results: %+v", results)
+	}
+
+	inst, err := Inspect(results[0].Path, dirs)
+	if err != nil || inst.Trust != TrustVerified || !strings.HasPrefix(inst.Signer, "SHA256:") {
+		t.Fatalf("unexpected trust for signed repo: %+v, %v", inst, err)
+	}
+
+	if _, err := os.Stat(filepath.Join(dirs.Clones, "unsigned")); !os.IsNotExist(err) {
+		t.Fatalf("refused repo left its clone behind: %v", err)
+	}
+
+	trust.Insecure = true
+	results, _, err = Sync(manifest, lock, dirs, false, trust)
+	if err != nil {
+		t.Fatalf("could not sync insecurely: %s", err)
+	}
+
+	if inst, err := Inspect(results[1].Path, dirs); err != nil || inst.Trust != TrustUnsigned {
+		t.Fatalf("unexpected trust for unsigned repo: %+v, %v", inst, err)
+	}
+
+	// repos locked by someone else are verified too, and resolved again to tell tags apart
+	other := GlobalDirs(t.TempDir())
+	results, _, err = Sync(manifest, lock, other, false, trustKeys(t, other, string(public)))
+	if err == nil || len(results) != 1 || results[0].Status != SyncInstalled {
+		t.Fatalf("unexpected sync from lockfile: %+v, %v", results, err)
+	}
+	if inst, err := Inspect(results[0].Path, other); err != nil || inst.Trust != TrustVerified {
+		t.Fatalf("unexpected trust for locked repo: %+v, %v", inst, err)
+	}
+}
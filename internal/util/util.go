@@ -5,7 +5,6 @@ package util
 import (
 	"os"
 	"strconv"
-	"strings"
 
 	"git.rob.mx/nidito/chinampa/pkg/env"
 	"git.rob.mx/nidito/chinampa/pkg/runtime"
@@ -32,10 +31,11 @@ func IsTrueIsh(val string) bool {
 	return false
 }
 
-// EnvironmentMap returns the resolved environment map.
-func EnvironmentMap(mp []string) map[string]string {
+// EnvironmentMap returns the resolved environment map, for milpaPath as joined by
+// bootstrap.MilpaPathEnv.
+func EnvironmentMap(milpaPath string) map[string]string {
 	res := map[string]string{
-		_c.EnvVarMilpaPath:       strings.Join(mp, ":"),
+		_c.EnvVarMilpaPath:       milpaPath,
 		_c.EnvVarMilpaPathParsed: "true",
 	}
 	trueString := strconv.FormatBool(true)
@@ -58,3 +58,9 @@ func EnvironmentMap(mp []string) map[string]string {
 
 	return res
 }
+
+// IsDir tells if path is a directory, following symlinks.
+func IsDir(path string) bool {
+	fi, err := os.Stat(path) // nolint: gosec
+	return err == nil && fi.Mode().IsDir()
+}
@@ -9,6 +9,8 @@ else
 fi
 cd "$MILPA_ROOT" || @milpa.fail "could not cd into $MILPA_ROOT"
 
+milpa dev vendor || @milpa.fail "could not vendor docs javascript"
+
 args=(
   -ldflags "-s -w -X main.version=${VERSION}" -o compa
 )
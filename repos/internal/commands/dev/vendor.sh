@@ -0,0 +1,43 @@
+#!/usr/bin/env bash
+# SPDX-License-Identifier: Apache-2.0
+# Copyright © 2021 Roberto Hidalgo <milpa@un.rob.mx>
+
+vendor="$MILPA_ROOT/internal/docs/static/js/vendor"
+checksums="$MILPA_ROOT/internal/docs/vendor.sha256"
+mkdir -p "$vendor" || @milpa.fail "could not create $vendor"
+
+files=(
+  "mermaid.min.js=https://cdn.jsdelivr.net/npm/mermaid@10.9.1/dist/mermaid.min.js"
+  "viz-standalone.js=https://cdn.jsdelivr.net/npm/@viz-js/viz@3.7.0/lib/viz-standalone.js"
+)
+
+function pinned_sum () {
+  [[ -f "$checksums" ]] || return 0
+  awk -v name="$1" '$2 == name { print $1 }' "$checksums"
+}
+
+for file in "${files[@]}"; do
+  name="${file%%=*}"
+  url="${file#*=}"
+  if [[ -f "$vendor/$name" ]] && [[ ! "$MILPA_OPT_FORCE" ]]; then
+    @milpa.log info "$name already vendored"
+  else
+    @milpa.log info "Downloading $name from $url"
+    curl --silent --fail --show-error --location "$url" -o "$vendor/$name.tmp" || {
+      rm -f "$vendor/$name.tmp"
+      @milpa.fail "could not download $name"
+    }
+    mv "$vendor/$name.tmp" "$vendor/$name" || @milpa.fail "could not write $vendor/$name"
+  fi
+
+  sum="$(sha256sum "$vendor/$name" | awk '{print $1}')"
+  expected="$(pinned_sum "$name")"
+  if [[ "$expected" == "" ]]; then
+    echo "$sum  $name" >> "$checksums" || @milpa.fail "could not record checksum of $name"
+    @milpa.log warning "Recorded checksum of $name in $checksums, review and commit it"
+  elif [[ "$sum" != "$expected" ]]; then
+    rm -f "$vendor/$name"
+    @milpa.fail "$name does not match its checksum, wanted $expected, got $sum"
+  fi
+  @milpa.log success "Vendored $name"
+done
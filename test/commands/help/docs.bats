@@ -35,6 +35,12 @@ internals
 Completion ended with directive: ShellCompDirectiveNoFileComp"
 }
 
+@test "itself docs suggests similar topics" {
+  run -64 --separate-stderr milpa help docs milpa enviornment
+  assert_output --partial "milpa help docs"
+  echo "$stderr" | grep -m1 'did you mean `milpa help docs milpa environment`?'
+}
+
 
 @test "itself docs --server" {
   # regenerate with
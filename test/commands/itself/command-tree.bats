@@ -55,3 +55,88 @@ itself"
   assert_success
   assert_output "virtual"
 }
+
+@test "itself command-tree --output dot" {
+  run milpa itself command-tree --depth 1 --output dot
+  assert_success
+  assert_line --index 0 "digraph commands {"
+  assert_output --partial '"milpa" -> "milpa itself";'
+  assert_output --partial '"milpa itself" [label="itself", tooltip="subcommands that operate on `milpa`, itself", shape=folder'
+  assert_line "}"
+}
+
+@test "itself command-tree --output markdown" {
+  run milpa itself command-tree --depth 2 --output markdown itself
+  assert_success
+  assert_line --index 0 '- `milpa itself`: subcommands that operate on `milpa`, itself'
+  assert_output --partial '  - `milpa itself command-tree`: Prints a tree of known commands'
+  assert_output --partial '      - `--depth`: The maximum depth to search for commands'
+  assert_output --partial '      - `[PREFIX...]`: Sets the name prefix to list from'
+}
+
+@test "itself command-tree --kind" {
+  run compa __command_tree --kind source --format autocomplete
+  assert_success
+  assert_output "itself"
+}
+
+@test "itself command-tree --where" {
+  run compa __command_tree --where '{{ eq .Name "version" }}' --format markdown
+  assert_success
+  assert_output --partial '  - `milpa itself`'
+  assert_output --partial '    - `milpa itself version`'
+  refute_output --partial '`milpa itself create`'
+}
+
+@test "itself command-tree --include-hidden" {
+  run compa __command_tree --include-hidden --depth 1 --format autocomplete
+  assert_success
+  assert_line "__command_tree"
+  assert_line "itself"
+}
+
+@test "itself command-tree --repo" {
+  mkdir -p "$LOCAL_REPO/commands"
+  echo "summary: a filtered command" > "$LOCAL_REPO/commands/filtered.yaml"
+  echo "echo filtered" > "$LOCAL_REPO/commands/filtered.sh"
+
+  run compa __command_tree --repo "$XDG_DATA_HOME" --format autocomplete
+  assert_success
+  assert_line "filtered"
+  refute_line "itself"
+}
+
+@test "itself command-tree --repo through a symlink" {
+  mkdir -p "$LOCAL_REPO/commands"
+  echo "summary: a filtered command" > "$LOCAL_REPO/commands/filtered.yaml"
+  echo "echo filtered" > "$LOCAL_REPO/commands/filtered.sh"
+  ln -s "$XDG_DATA_HOME" "$BATS_TEST_TMPDIR/linked"
+
+  run compa __command_tree --repo "$BATS_TEST_TMPDIR/linked" --format autocomplete
+  assert_success
+  assert_line "filtered"
+  refute_line "itself"
+}
+
+@test "itself command-tree diff without changes" {
+  milpa itself command-tree --output json > before.json
+
+  run --separate-stderr milpa itself command-tree diff before.json
+  assert_success
+  assert_output ""
+}
+
+@test "itself command-tree diff with breaking changes" {
+  milpa itself command-tree --output json itself > current.json
+  jq '.children |= map(select(.command.path[-1] != "version")) | .children += [{"command": {"path": ["milpa", "itself", "gone"]}, "children": []}]' current.json > previous.json
+
+  run --separate-stderr milpa itself command-tree diff previous.json current.json
+  assert_failure 3
+  assert_line "! milpa itself gone: command-removed"
+  assert_line "  milpa itself version: command-added"
+
+  run --separate-stderr milpa itself command-tree diff --output json previous.json current.json
+  assert_failure 3
+  run jq -r '.[] | select(.breaking) | .command' <<<"$output"
+  assert_output "milpa itself gone"
+}
@@ -0,0 +1,62 @@
+#!/usr/bin/env bats
+# SPDX-License-Identifier: Apache-2.0
+# Copyright © 2021 Roberto Hidalgo <milpa@un.rob.mx>
+bats_load_library 'milpa'
+_suite_setup
+bats_load_library 'bats-file'
+export LOCAL_REPO="$XDG_DATA_HOME/.milpa"
+
+setup() {
+  _common_setup
+  export XDG_CACHE_HOME="$BATS_TEST_TMPDIR/cache"
+  mkdir -p "$LOCAL_REPO/commands"
+  cat > "$LOCAL_REPO/commands/slow-values.yaml" <<'YAML'
+summary: completes slow values
+description: completes slow values
+arguments:
+  - name: value
+    description: a slow value
+    values:
+      script: date +%s%N
+      cache: 1m
+YAML
+  echo 'echo "$MILPA_ARG_VALUE"' > "$LOCAL_REPO/commands/slow-values.sh"
+}
+
+teardown() {
+  rm -f "$LOCAL_REPO/commands/slow-values."*
+}
+
+@test "itself completion-cache" {
+  run milpa __complete slow-values ""
+  assert_success
+  first="$output"
+
+  run milpa __complete slow-values ""
+  assert_success
+  assert_output "$first"
+  assert_dir_exist "$XDG_CACHE_HOME/milpa/completions/slow-values"
+
+  run milpa itself completion-cache
+  assert_success
+  assert_output "milpa slow-values - 1 cached value source(s)"
+
+  run milpa itself completion-cache --refresh slow-values
+  assert_success
+  assert_dir_not_exist "$XDG_CACHE_HOME/milpa/completions/slow-values"
+
+  run milpa __complete slow-values ""
+  assert_success
+  refute_output "$first"
+}
+
+@test "itself completion-cache keeps scripts in the command tree" {
+  milpa itself command-tree --output json > before.json
+  run jq -r '.children[] | select(.command.path[-1] == "slow-values") | .command.arguments[0].values.script' before.json
+  assert_success
+  assert_output 'date +%s%N'
+
+  run --separate-stderr milpa itself command-tree diff before.json
+  assert_success
+  assert_output ""
+}
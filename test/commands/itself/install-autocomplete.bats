@@ -0,0 +1,51 @@
+#!/usr/bin/env bats
+# SPDX-License-Identifier: Apache-2.0
+# Copyright © 2021 Roberto Hidalgo <milpa@un.rob.mx>
+bats_load_library 'milpa'
+_suite_setup
+bats_load_library 'bats-file'
+
+setup() {
+  _common_setup
+  export XDG_CONFIG_HOME="$BATS_TEST_TMPDIR/config"
+}
+
+@test "itself install-autocomplete nushell" {
+  SHELL=/usr/bin/nu run milpa itself install-autocomplete
+  assert_success
+  assert_file_exist "$XDG_CONFIG_HOME/nushell/autoload/milpa.nu"
+  run cat "$XDG_CONFIG_HOME/nushell/autoload/milpa.nu"
+  assert_output --partial '^milpa __complete ...($spans | skip 1)'
+  assert_output --partial '($directive bit-and 1) != 0'
+  assert_output --partial '($directive bit-and 24) != 0'
+  assert_output --partial '($directive bit-and 4) == 0'
+
+  if command -v nu >/dev/null; then
+    run nu -c "source $XDG_CONFIG_HOME/nushell/autoload/milpa.nu; do \$env.config.completions.external.completer [milpa itself ''] | get value | str join ' '"
+    assert_success
+    assert_output --partial "install-autocomplete"
+  fi
+}
+
+@test "itself install-autocomplete pwsh" {
+  SHELL=/usr/bin/pwsh run milpa itself install-autocomplete
+  assert_success
+  assert_file_exist "$XDG_CONFIG_HOME/powershell/milpa.ps1"
+  run cat "$XDG_CONFIG_HOME/powershell/Microsoft.PowerShell_profile.ps1"
+  assert_output ". '$XDG_CONFIG_HOME/powershell/milpa.ps1'"
+
+  # installing again does not load completions twice
+  SHELL=/usr/bin/pwsh run milpa itself install-autocomplete
+  assert_success
+  run cat "$XDG_CONFIG_HOME/powershell/Microsoft.PowerShell_profile.ps1"
+  assert_output ". '$XDG_CONFIG_HOME/powershell/milpa.ps1'"
+
+  run cat "$XDG_CONFIG_HOME/powershell/milpa.ps1"
+  assert_output --partial "Register-ArgumentCompleter"
+  assert_output --partial "__complete"
+}
+
+@test "__completion_script with unknown shells" {
+  run milpa __completion_script tcsh
+  assert_failure
+}
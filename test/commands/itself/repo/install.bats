@@ -0,0 +1,140 @@
+#!/usr/bin/env bats
+# SPDX-License-Identifier: Apache-2.0
+# Copyright © 2021 Roberto Hidalgo <milpa@un.rob.mx>
+bats_load_library 'milpa'
+_suite_setup
+bats_load_library 'bats-file'
+
+setup() {
+  _common_setup
+  export GIT_AUTHOR_NAME=milpa GIT_AUTHOR_EMAIL=milpa@example.com
+  export GIT_COMMITTER_NAME=milpa GIT_COMMITTER_EMAIL=milpa@example.com
+  export REMOTE="$BATS_TEST_TMPDIR/remote"
+  export XDG_DATA_HOME="$BATS_TEST_TMPDIR/data"
+  export HOOKS="$BATS_TEST_TMPDIR/hooks.log"
+
+  mkdir -p "$REMOTE/.milpa/commands" "$REMOTE/.milpa/hooks"
+  echo "echo installed >> '$HOOKS'" > "$REMOTE/.milpa/hooks/post-install.sh"
+  echo "echo uninstalled >> '$HOOKS'" > "$REMOTE/.milpa/hooks/post-uninstall.sh"
+  git -C "$REMOTE" init -q -b main
+  remote_commit first
+}
+
+remote_commit() {
+  echo "echo $1" > "$REMOTE/.milpa/commands/cloned.sh"
+  git -C "$REMOTE" add -A
+  git -C "$REMOTE" commit -q -m "$1"
+}
+
+@test "itself repo install from git" {
+  run milpa itself repo install "file://$REMOTE"
+  assert_success
+  repo="$(milpa itself repo list --paths-only)"
+  assert_file_exist "$repo/commands/cloned.sh"
+  assert_file_contains "$HOOKS" "installed"
+
+  run milpa itself repo list --format json
+  assert_success
+  assert_output --partial '"kind": "clone"'
+  assert_output --partial '"branch": "main"'
+  assert_output --partial "\"commit\": \"$(git -C "$REMOTE" rev-parse main)\""
+
+  remote_commit second
+  run milpa itself repo upgrade "$repo"
+  assert_success
+  run cat "$repo/commands/cloned.sh"
+  assert_output "echo second"
+
+  run milpa itself repo uninstall "$repo"
+  assert_success
+  assert_file_not_exist "$repo"
+  assert_file_contains "$HOOKS" "uninstalled"
+  run milpa itself repo list --paths-only
+  assert_output ""
+}
+
+@test "itself repo install from a local folder" {
+  run milpa itself repo install "$REMOTE"
+  assert_success
+  assert_link_exist "$XDG_DATA_HOME/milpa/repos/remote"
+
+  run milpa itself repo list
+  assert_success
+  assert_output --partial "$XDG_DATA_HOME/milpa/repos/remote - local symlink, original at $REMOTE/.milpa"
+
+  run milpa itself repo upgrade remote
+  assert_failure
+
+  run milpa itself repo uninstall remote
+  assert_success
+  assert_file_not_exist "$XDG_DATA_HOME/milpa/repos/remote"
+  assert_dir_exist "$REMOTE/.milpa"
+}
+
+@test "itself repo uninstall unknown repo" {
+  run milpa itself repo uninstall "$BATS_TEST_TMPDIR/nope"
+  assert_failure
+}
+
+@test "itself repo install with trusted keys" {
+  ssh-keygen -q -t ed25519 -N "" -C milpa -f "$BATS_TEST_TMPDIR/key"
+  mkdir -p "$XDG_DATA_HOME/milpa/trusted-keys"
+  cp "$BATS_TEST_TMPDIR/key.pub" "$XDG_DATA_HOME/milpa/trusted-keys/release"
+  git -C "$REMOTE" -c gpg.format=ssh -c user.signingkey="$BATS_TEST_TMPDIR/key" tag -s -m release v1.0.0
+
+  run milpa itself repo install "file://$REMOTE"
+  assert_failure
+  assert_output --partial "use --insecure to install it anyway"
+
+  run milpa itself repo install --ref v1.0.0 "file://$REMOTE"
+  assert_success
+  run milpa itself repo list
+  assert_success
+  assert_output --partial "verified by SHA256:"
+
+  run milpa itself repo uninstall "$(milpa itself repo list --paths-only)"
+  assert_success
+  run milpa itself repo install --insecure "file://$REMOTE"
+  assert_success
+  run milpa itself repo list --format json
+  assert_output --partial '"trust": "unsigned"'
+}
+
+@test "itself repo list shows repo metadata" {
+  printf 'name: remote\nversion: 1.4.0\ndescription: Remote commands\nrequires-milpa: ">=0.1"\n' > "$REMOTE/.milpa/repo.yaml"
+  run milpa itself repo install "$REMOTE"
+  assert_success
+
+  run milpa itself repo list
+  assert_success
+  assert_output --partial "remote 1.4.0: Remote commands"
+
+  run milpa itself repo list --format json
+  assert_output --partial '"requires-milpa": ">=0.1"'
+}
+
+@test "itself repo install with dependencies" {
+  mkdir -p "$BATS_TEST_TMPDIR/app/.milpa/commands" "$BATS_TEST_TMPDIR/shared/.milpa/util"
+  echo 'shared_util() { echo "from shared"; }' > "$BATS_TEST_TMPDIR/shared/.milpa/util/shared.sh"
+  printf 'depends:\n  - %s\n' "$BATS_TEST_TMPDIR/shared" > "$BATS_TEST_TMPDIR/app/.milpa/repo.yaml"
+  printf '@milpa.load_util shared\nshared_util\n' > "$BATS_TEST_TMPDIR/app/.milpa/commands/app.sh"
+  echo "summary: uses shared utils" > "$BATS_TEST_TMPDIR/app/.milpa/commands/app.yaml"
+
+  run milpa itself repo install "$BATS_TEST_TMPDIR/app"
+  assert_success
+  assert_output --partial "shared"
+  run milpa itself repo list --paths-only
+  assert_output --partial "/repos/shared"
+  assert_output --partial "/repos/app"
+
+  run milpa app
+  assert_success
+  assert_output "from shared"
+
+  printf 'depends:\n  - %s\n' "$BATS_TEST_TMPDIR/app" > "$BATS_TEST_TMPDIR/shared/.milpa/repo.yaml"
+  run milpa itself repo uninstall "$(milpa itself repo list --paths-only | grep app)"
+  run milpa itself repo uninstall "$(milpa itself repo list --paths-only | grep shared)"
+  run milpa itself repo install "$BATS_TEST_TMPDIR/app"
+  assert_failure
+  assert_output --partial "dependency cycle: app -> shared -> app"
+}
@@ -0,0 +1,55 @@
+#!/usr/bin/env bats
+# SPDX-License-Identifier: Apache-2.0
+# Copyright © 2021 Roberto Hidalgo <milpa@un.rob.mx>
+bats_load_library 'milpa'
+_suite_setup
+bats_load_library 'bats-file'
+
+setup() {
+  _common_setup
+  export GIT_AUTHOR_NAME=milpa GIT_AUTHOR_EMAIL=milpa@example.com
+  export GIT_COMMITTER_NAME=milpa GIT_COMMITTER_EMAIL=milpa@example.com
+  export REMOTE="$BATS_TEST_TMPDIR/remote"
+  export XDG_DATA_HOME="$BATS_TEST_TMPDIR/data"
+
+  mkdir -p "$REMOTE/.milpa/commands"
+  git -C "$REMOTE" init -q -b main
+  remote_commit first
+  git -C "$REMOTE" tag v1.0.0
+}
+
+remote_commit() {
+  echo "echo $1" > "$REMOTE/.milpa/commands/pinned.sh"
+  git -C "$REMOTE" add -A
+  git -C "$REMOTE" commit -q -m "$1"
+}
+
+@test "itself repo outdated with pinned tags" {
+  run milpa itself repo install --ref v1.0.0 "file://$REMOTE"
+  assert_success
+  repo="$(milpa itself repo list --paths-only)"
+
+  run milpa itself repo outdated
+  assert_success
+  assert_output ""
+
+  remote_commit second
+  git -C "$REMOTE" tag v1.1.0
+  run milpa itself repo outdated --format json
+  assert_success
+  assert_output --partial '"ref": "v1.0.0"'
+  assert_output --partial '"latest": "v1.1.0"'
+
+  run milpa itself repo upgrade "$repo"
+  assert_success
+  run cat "$repo/commands/pinned.sh"
+  assert_output "echo first"
+
+  run milpa itself repo upgrade --latest "$repo"
+  assert_success
+  run cat "$repo/commands/pinned.sh"
+  assert_output "echo second"
+
+  run milpa itself repo outdated
+  assert_output ""
+}
@@ -0,0 +1,46 @@
+#!/usr/bin/env bats
+# SPDX-License-Identifier: Apache-2.0
+# Copyright © 2021 Roberto Hidalgo <milpa@un.rob.mx>
+bats_load_library 'milpa'
+_suite_setup
+bats_load_library 'bats-file'
+
+setup() {
+  _common_setup
+  export SOURCE="$BATS_TEST_TMPDIR/infra"
+  export XDG_DATA_HOME="$BATS_TEST_TMPDIR/data"
+
+  mkdir -p "$SOURCE/.milpa/commands" "$SOURCE/.milpa/.git"
+  echo "echo packed" > "$SOURCE/.milpa/commands/packed.sh"
+  echo "ignored" > "$SOURCE/.milpa/.git/config"
+}
+
+@test "itself repo pack" {
+  cd "$BATS_TEST_TMPDIR"
+  run milpa itself repo pack "$SOURCE"
+  assert_success
+  assert_file_exist "$BATS_TEST_TMPDIR/infra.tar.gz"
+  assert_output "$(sha256sum infra.tar.gz | cut -d' ' -f1) infra.tar.gz"
+
+  run tar -tzf infra.tar.gz
+  assert_output --partial ".milpa/commands/packed.sh"
+  refute_output --partial ".git"
+}
+
+@test "itself repo install from an archive" {
+  cd "$BATS_TEST_TMPDIR"
+  run milpa itself repo pack --file infra-1.0.zip "$SOURCE"
+  assert_success
+
+  run milpa itself repo install "file://$BATS_TEST_TMPDIR/infra-1.0.zip"
+  assert_success
+  assert_file_exist "$XDG_DATA_HOME/milpa/repos/infra-1-0/commands/packed.sh"
+  assert_file_exist "$XDG_DATA_HOME/milpa/clones/infra-1-0/milpa.archive.yaml"
+
+  run milpa itself repo list --format json
+  assert_output --partial '"kind": "archive"'
+  assert_output --partial "\"sha256\": \"$(sha256sum infra-1.0.zip | cut -d' ' -f1)\""
+
+  run milpa itself repo upgrade infra-1-0
+  assert_failure
+}
@@ -0,0 +1,70 @@
+#!/usr/bin/env bats
+# SPDX-License-Identifier: Apache-2.0
+# Copyright © 2021 Roberto Hidalgo <milpa@un.rob.mx>
+bats_load_library 'milpa'
+_suite_setup
+bats_load_library 'bats-file'
+
+setup() {
+  _common_setup
+  export GIT_AUTHOR_NAME=milpa GIT_AUTHOR_EMAIL=milpa@example.com
+  export GIT_COMMITTER_NAME=milpa GIT_COMMITTER_EMAIL=milpa@example.com
+  export REMOTE="$BATS_TEST_TMPDIR/remote"
+  export PROJECT="$BATS_TEST_TMPDIR/project"
+  export XDG_DATA_HOME="$BATS_TEST_TMPDIR/data"
+
+  mkdir -p "$REMOTE/.milpa/commands" "$PROJECT"
+  git -C "$REMOTE" init -q -b main
+  remote_commit first
+  git -C "$REMOTE" tag v1
+  remote_commit second
+
+  cat > "$PROJECT/milpa.repos.yaml" <<YAML
+repos:
+  - name: remote
+    source: file://$REMOTE
+  - name: pinned
+    source: file://$REMOTE
+    ref: v1
+YAML
+}
+
+remote_commit() {
+  cat > "$REMOTE/.milpa/commands/synced.yaml" <<YAML
+summary: $1
+description: $1
+YAML
+  echo "echo $1" > "$REMOTE/.milpa/commands/synced.sh"
+  git -C "$REMOTE" add -A
+  git -C "$REMOTE" commit -q -m "$1"
+}
+
+@test "itself repo sync" {
+  cd "$PROJECT"
+  run milpa itself repo sync
+  assert_success
+  assert_file_exist "$PROJECT/milpa.repos.lock"
+  assert_file_contains "$PROJECT/milpa.repos.lock" "commit: $(git -C "$REMOTE" rev-parse v1^{})"
+  assert_file_contains "$PROJECT/milpa.repos.lock" "commit: $(git -C "$REMOTE" rev-parse main)"
+  assert_file_exist "$XDG_DATA_HOME/milpa/repos/remote/commands/synced.sh"
+  run cat "$XDG_DATA_HOME/milpa/repos/pinned/commands/synced.sh"
+  assert_output "echo first"
+
+  locked="$(git -C "$REMOTE" rev-parse main)"
+  remote_commit third
+  run milpa itself repo sync
+  assert_success
+  run git -C "$XDG_DATA_HOME/milpa/clones/remote" rev-parse HEAD
+  assert_output "$locked"
+
+  run milpa itself repo sync --update
+  assert_success
+  run cat "$XDG_DATA_HOME/milpa/repos/remote/commands/synced.sh"
+  assert_output "echo third"
+  assert_file_contains "$PROJECT/milpa.repos.lock" "commit: $(git -C "$REMOTE" rev-parse main)"
+}
+
+@test "itself repo sync without a manifest" {
+  run milpa itself repo sync --manifest "$BATS_TEST_TMPDIR/nope.yaml"
+  assert_failure
+}
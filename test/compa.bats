@@ -43,3 +43,13 @@ setup () {
   echo "$last_line"
   echo "${last_line}" | grep -m1 "unknown flag: --bad-flag"
 }
+
+@test "compa suggests similar commands and options" {
+  run -127 --separate-stderr compa debug-evn
+  echo "$stderr"
+  echo "$stderr" | grep -m1 'no command `milpa debug-evn`; did you mean `milpa debug-env`?'
+
+  run -64 --separate-stderr compa debug-env --completion-tets
+  echo "$stderr"
+  echo "$stderr" | grep -m1 'no option `--completion-tets` for `milpa debug-env`; did you mean `--completion-test`?'
+}
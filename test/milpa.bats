@@ -68,6 +68,61 @@ ERROR: Invalid configuration: cannot run command <milpa bad-command>: Invalid co
   assert_output "${BATS_SUITE_TMPDIR//\/\///}/somewhere/.milpa:$(readlink -f $MILPA_ROOT/.milpa):$(readlink -f $MILPA_ROOT/repos/test-suite)"
 }
 
+@test "milpa mounts repos under a prefix" {
+  repo="${BATS_SUITE_TMPDIR}/mounted/.milpa"
+  mkdir -pv "$repo/commands"
+  echo "summary: deploys"$'\n'"description: deploys" > "$repo/commands/deploy.yaml"
+  printf '#!/usr/bin/env bash\necho "deploying as $MILPA_COMMAND_NAME"\n' > "$repo/commands/deploy.sh"
+  export MILPA_PATH="infra=${BATS_SUITE_TMPDIR}/mounted"
+
+  run milpa infra deploy
+  assert_success
+  assert_output "deploying as infra deploy"
+
+  run -127 milpa deploy
+  assert_failure 127
+
+  run milpa __complete infra ""
+  assert_success
+  assert_output --partial "deploy"$'\t'"deploys"
+
+  # scripts get MILPA_PATH with its mounts, so milpa runs the same commands when they call it
+  echo "summary: nested"$'\n'"description: nested" > "$repo/commands/nested.yaml"
+  printf '#!/usr/bin/env bash\nmilpa infra deploy\n' > "$repo/commands/nested.sh"
+  run milpa infra nested
+  assert_success
+  assert_output "deploying as infra deploy"
+  rm "$repo/commands/nested."*
+
+  # repo.yaml mounts apply unless MILPA_PATH mounts the repo elsewhere
+  echo "mount: ops" > "$repo/repo.yaml"
+  export MILPA_PATH="${BATS_SUITE_TMPDIR}/mounted"
+  run milpa ops deploy
+  assert_success
+  assert_output "deploying as ops deploy"
+}
+
+@test "milpa looks for repos in parent folders" {
+  mono="$BATS_TEST_TMPDIR/mono"
+  mkdir -pv "$mono/.milpa/commands" "$mono/services/api/.milpa" "$mono/services/api/src"
+  echo "summary: top"$'\n'"description: top" > "$mono/.milpa/commands/top.yaml"
+  printf '#!/usr/bin/env bash\necho "from the top"\n' > "$mono/.milpa/commands/top.sh"
+  cd "$mono/services/api/src" || return 2
+
+  run -127 milpa top
+
+  export MILPA_LOOKUP_PARENTS=true MILPA_LOOKUP_BOUNDARY="$mono"
+  run milpa top
+  assert_success
+  assert_output "from the top"
+
+  run milpa debug-env MILPA_PATH
+  assert_output --partial "$mono/services/api/.milpa:$mono/.milpa"
+
+  export MILPA_LOOKUP_BOUNDARY="$mono/services"
+  run -127 milpa top
+}
+
 @test "milpa completes recursively" {
   # path must have a milpa repo or it will be ignored!
   run milpa __complete debug-env --completion-test ""
@@ -77,3 +132,104 @@ _activeHelp_ tests if milpa can call itself during completion
 :0
 Completion ended with directive: ShellCompDirectiveDefault"
 }
+
+@test "milpa completes values with descriptions" {
+  mkdir -p "$XDG_DATA_HOME/.milpa/commands"
+  cat > "$XDG_DATA_HOME/.milpa/commands/described-values.yaml" <<'YAML'
+summary: completes described values
+description: completes described values
+arguments:
+  - name: increment
+    description: the increment
+    values:
+      static:
+        major: breaking changes
+        minor: new features
+  - name: server
+    description: the server
+    values:
+      script: printf 'i-0a12f\tweb\ni-0b34c\tdatabase\n'
+YAML
+  echo 'echo "$MILPA_ARG_INCREMENT $MILPA_ARG_SERVER"' > "$XDG_DATA_HOME/.milpa/commands/described-values.sh"
+
+  run milpa __complete described-values ""
+  assert_success
+  assert_line --index 0 $'major\tbreaking changes'
+  assert_line --index 1 $'minor\tnew features'
+
+  run milpa __complete described-values minor ""
+  assert_success
+  assert_line --index 0 $'i-0a12f\tweb'
+  assert_line --index 1 $'i-0b34c\tdatabase'
+
+  run milpa described-values minor i-0b34c
+  assert_success
+  assert_output "minor i-0b34c"
+
+  run milpa described-values minor database
+  assert_failure
+  rm -f "$XDG_DATA_HOME/.milpa/commands/described-values."*
+}
+
+@test "milpa runs commands by their aliases" {
+  mkdir -p "$XDG_DATA_HOME/.milpa/commands/database"
+  cat > "$XDG_DATA_HOME/.milpa/commands/database/migrate.yaml" <<'YAML'
+summary: migrates the database
+description: migrates the database
+aliases: [up]
+deprecated-alias: [db migrate]
+arguments:
+  - name: version
+    description: the version to migrate to
+YAML
+  echo 'echo "$MILPA_COMMAND_NAME $MILPA_ARG_VERSION"' > "$XDG_DATA_HOME/.milpa/commands/database/migrate.sh"
+
+  run milpa database up 42
+  assert_success
+  assert_output "database migrate 42"
+
+  run milpa __complete database ""
+  assert_success
+  assert_line --index 0 $'migrate\tmigrates the database'
+  assert_line --index 1 $'up\tmigrates the database'
+
+  run --separate-stderr milpa db migrate 42
+  assert_success
+  assert_output "database migrate 42"
+  echo "$stderr" | grep -m1 "milpa db migrate is deprecated, use milpa database migrate instead"
+
+  run milpa __complete ""
+  refute_line --partial "db"
+  rm -rf "$XDG_DATA_HOME/.milpa/commands/database"
+}
+
+@test "milpa warns about deprecated commands" {
+  mkdir -p "$XDG_DATA_HOME/.milpa/commands"
+  cat > "$XDG_DATA_HOME/.milpa/commands/retired.yaml" <<'YAML'
+summary: a retired command
+description: a retired command
+deprecated: use milpa debug-env instead
+removed-after: 2001-01-01
+YAML
+  echo 'echo "still running"' > "$XDG_DATA_HOME/.milpa/commands/retired.sh"
+
+  run --separate-stderr milpa retired
+  assert_success
+  assert_output "still running"
+  echo "$stderr" | grep -m1 "milpa retired is deprecated: use milpa debug-env instead; it will be removed after 2001-01-01"
+
+  run milpa __complete ""
+  assert_success
+  refute_line --partial "retired"
+
+  run milpa retired --help
+  assert_output --partial "Deprecated"
+
+  run milpa --help
+  assert_output --partial "retired"
+
+  run milpa itself doctor --summary
+  assert_failure
+  assert_output --partial "scheduled for removal after 2001-01-01"
+  rm -f "$XDG_DATA_HOME/.milpa/commands/retired."*
+}